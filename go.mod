@@ -1,6 +1,6 @@
 module github.com/LeoMarche/blenderer
 
-go 1.17
+go 1.18
 
 require (
 	github.com/foize/go.fifo v0.0.0-20130327144150-3a04cfeec121
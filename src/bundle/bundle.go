@@ -0,0 +1,226 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+//IsArchive returns true if name has the extension of an archive that can be extracted
+func IsArchive(name string) bool {
+	name = strings.ToLower(name)
+	for _, ext := range []string{".zip", ".tar", ".tar.gz", ".tgz"} {
+		if strings.HasSuffix(name, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+//IsLocal returns true if the slash separated path name is relative and stays inside the folder it is relative to
+func IsLocal(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.Contains(name, "\\") || filepath.IsAbs(name) {
+		return false
+	}
+	cleaned := path.Clean(name)
+	return cleaned != "." && cleaned != ".." && !strings.HasPrefix(cleaned, "../")
+}
+
+//Create writes the content of the folder dir in the zip archive dst, with paths relative to dir
+func Create(dir, dst string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		rel = filepath.ToSlash(rel)
+
+		//Don't put the archive inside itself
+		if abs, err := filepath.Abs(p); err == nil {
+			if absDst, err := filepath.Abs(dst); err == nil && abs == absDst {
+				return nil
+			}
+		}
+
+		if info.IsDir() {
+			_, err = zw.Create(rel + "/")
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is not a regular file", p)
+		}
+
+		h, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		h.Name = rel
+		h.Method = zip.Deflate
+
+		w, err := zw.CreateHeader(h)
+		if err != nil {
+			return err
+		}
+
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		_, err = io.Copy(w, src)
+		return err
+	})
+
+	if err != nil {
+		zw.Close()
+		return err
+	}
+
+	return zw.Close()
+}
+
+//Extract unpacks the zip, tar or gzipped tar archive in the folder dst, keeping the relative paths of its files.
+//Entries that would be written outside of dst and entries that aren't files or folders are refused.
+func Extract(archive, dst string) error {
+	name := strings.ToLower(archive)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(archive, dst)
+	case strings.HasSuffix(name, ".tar"):
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return extractTar(f, dst)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		f, err := os.Open(archive)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		return extractTar(gz, dst)
+	}
+
+	return fmt.Errorf("unknown archive type of %s", archive)
+}
+
+//target returns the path in dst where the entry name of an archive must be written
+func target(dst, name string) (string, error) {
+	if !IsLocal(name) {
+		return "", fmt.Errorf("illegal path %s in archive", name)
+	}
+	return filepath.Join(dst, filepath.FromSlash(path.Clean(name))), nil
+}
+
+//writeFile writes the content of r in the file p, creating its parent folders
+func writeFile(p string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(p), os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.Create(p)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+	return err
+}
+
+func extractZip(archive, dst string) error {
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, zf := range zr.File {
+		p, err := target(dst, zf.Name)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case zf.FileInfo().IsDir():
+			err = os.MkdirAll(p, os.ModePerm)
+		case zf.FileInfo().Mode().IsRegular():
+			var rc io.ReadCloser
+			rc, err = zf.Open()
+			if err != nil {
+				return err
+			}
+			err = writeFile(p, rc)
+			rc.Close()
+		default:
+			err = fmt.Errorf("unsupported entry %s in archive", zf.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func extractTar(r io.Reader, dst string) error {
+	tr := tar.NewReader(r)
+
+	for {
+		h, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		p, err := target(dst, h.Name)
+		if err != nil {
+			return err
+		}
+
+		switch h.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(p, os.ModePerm)
+		case tar.TypeReg:
+			err = writeFile(p, tr)
+		default:
+			err = fmt.Errorf("unsupported entry %s in archive", h.Name)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+}
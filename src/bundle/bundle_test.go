@@ -0,0 +1,178 @@
+package bundle
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//writeTar writes a gzipped tar archive containing files in dst
+func writeTar(dst string, files map[string]string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for name, content := range files {
+		h := &tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+//writeZip writes a zip archive containing files in dst
+func writeZip(dst string, files map[string]string) error {
+	f, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func TestIsLocal(t *testing.T) {
+	assert := assert.New(t)
+
+	names := []string{"scene.blend", "scenes/main.blend", "a/../b.blend", "", ".", "..", "../scene.blend", "a/../../b", "/etc/passwd", "a\\..\\b"}
+	expected := []bool{true, true, true, false, false, false, false, false, false, false}
+
+	for i := 0; i < len(names); i++ {
+		assert.Equal(expected[i], IsLocal(names[i]), "Bad result for %q", names[i])
+	}
+}
+
+func TestCreateExtract(t *testing.T) {
+	assert := assert.New(t)
+
+	os.MkdirAll(filepath.Join("tmp", "project", "textures"), os.ModePerm)
+	defer os.RemoveAll("tmp")
+
+	files := map[string]string{
+		"main.blend":                          "blend",
+		filepath.Join("textures", "wood.png"): "png",
+	}
+	for name, content := range files {
+		assert.NoError(ioutil.WriteFile(filepath.Join("tmp", "project", name), []byte(content), 0644))
+	}
+
+	assert.NoError(Create(filepath.Join("tmp", "project"), filepath.Join("tmp", "project.zip")))
+	assert.NoError(Extract(filepath.Join("tmp", "project.zip"), filepath.Join("tmp", "out")))
+
+	for name, content := range files {
+		b, err := ioutil.ReadFile(filepath.Join("tmp", "out", name))
+		assert.NoError(err)
+		assert.Equal(content, string(b), "Bad content of extracted file %s", name)
+	}
+
+	assert.NoError(writeTar(filepath.Join("tmp", "project.tar.gz"), map[string]string{"libs/lib.blend": "lib"}))
+	assert.NoError(Extract(filepath.Join("tmp", "project.tar.gz"), filepath.Join("tmp", "out")))
+	b, err := ioutil.ReadFile(filepath.Join("tmp", "out", "libs", "lib.blend"))
+	assert.NoError(err)
+	assert.Equal("lib", string(b), "Bad content of file extracted from tar")
+
+	assert.Error(Extract(filepath.Join("tmp", "project.rar"), filepath.Join("tmp", "out")))
+}
+
+func TestExtractOutside(t *testing.T) {
+	assert := assert.New(t)
+
+	os.MkdirAll("tmp", os.ModePerm)
+	defer os.RemoveAll("tmp")
+
+	assert.NoError(writeZip(filepath.Join("tmp", "evil.zip"), map[string]string{"../evil.txt": "evil"}))
+	assert.NoError(writeTar(filepath.Join("tmp", "evil.tgz"), map[string]string{"/tmp/evil.txt": "evil"}))
+
+	assert.Error(Extract(filepath.Join("tmp", "evil.zip"), filepath.Join("tmp", "out")))
+	assert.Error(Extract(filepath.Join("tmp", "evil.tgz"), filepath.Join("tmp", "out")))
+
+	_, err := os.Stat(filepath.Join("tmp", "evil.txt"))
+	assert.True(os.IsNotExist(err), "File written outside of the destination folder")
+}
+
+//fakeBlender writes in dst a shell script printing lines like the collect script of blender,
+//and saving the blend file in the folder given as last argument if save is true
+func fakeBlender(dst, lines string, save bool) error {
+	script := "#!/bin/sh\nprintf '" + lines + "'\n"
+	if save {
+		script += "for last; do :; done\necho collected > \"$last/scene.blend\"\n"
+	}
+	return ioutil.WriteFile(dst, []byte(script), 0755)
+}
+
+func TestCollect(t *testing.T) {
+	assert := assert.New(t)
+
+	os.MkdirAll(filepath.Join("tmp", "project", "textures"), os.ModePerm)
+	os.MkdirAll(filepath.Join("tmp", "hdri"), os.ModePerm)
+	defer os.RemoveAll("tmp")
+
+	ioutil.WriteFile(filepath.Join("tmp", "project", "scene.blend"), []byte("blend"), 0644)
+	ioutil.WriteFile(filepath.Join("tmp", "project", "textures", "wood.png"), []byte("png"), 0644)
+	ioutil.WriteFile(filepath.Join("tmp", "hdri", "sky.exr"), []byte("exr"), 0644)
+
+	wood, _ := filepath.Abs(filepath.Join("tmp", "project", "textures", "wood.png"))
+	sky, _ := filepath.Abs(filepath.Join("tmp", "hdri", "sky.exr"))
+	lost, _ := filepath.Abs(filepath.Join("tmp", "lost.png"))
+
+	assert.NoError(fakeBlender(filepath.Join("tmp", "blender"),
+		"Blender 2.91.0\\nBLENDERER_DEP\\t"+wood+"\\ttextures/wood.png\\nBLENDERER_DEP\\t"+sky+"\\texternal/1_sky.exr\\n", true))
+	assert.NoError(fakeBlender(filepath.Join("tmp", "blender_missing"),
+		"BLENDERER_DEP\\t"+wood+"\\ttextures/wood.png\\nBLENDERER_MISSING\\t"+lost+"\\n", false))
+	assert.NoError(fakeBlender(filepath.Join("tmp", "blender_evil"),
+		"BLENDERER_DEP\\t"+wood+"\\t../wood.png\\n", true))
+
+	deps, err := Collect(filepath.Join("tmp", "blender"), filepath.Join("tmp", "project", "scene.blend"), filepath.Join("tmp", "out"))
+	assert.NoError(err)
+	assert.Equal([]Dependency{{Source: wood, Path: "textures/wood.png"}, {Source: sky, Path: "external/1_sky.exr"}}, deps, "Bad dependencies collected")
+
+	expectedFiles := []string{"scene.blend", filepath.Join("textures", "wood.png"), filepath.Join("external", "1_sky.exr")}
+	expectedContent := []string{"collected\n", "png", "exr"}
+	for i := 0; i < len(expectedFiles); i++ {
+		b, err := ioutil.ReadFile(filepath.Join("tmp", "out", expectedFiles[i]))
+		assert.NoError(err)
+		assert.Equal(expectedContent[i], string(b), "Bad content of collected file %s", expectedFiles[i])
+	}
+
+	_, err = Collect(filepath.Join("tmp", "blender_missing"), filepath.Join("tmp", "project", "scene.blend"), filepath.Join("tmp", "out_missing"))
+	missing, ok := err.(*MissingFilesError)
+	assert.True(ok, "Missing files not reported")
+	if ok {
+		assert.Equal([]string{lost}, missing.Files, "Bad missing files reported")
+	}
+	_, err = os.Stat(filepath.Join("tmp", "out_missing", "textures", "wood.png"))
+	assert.True(os.IsNotExist(err), "Files copied despite missing ones")
+
+	_, err = Collect(filepath.Join("tmp", "blender_evil"), filepath.Join("tmp", "project", "scene.blend"), filepath.Join("tmp", "out_evil"))
+	assert.Error(err)
+
+	_, err = Collect(filepath.Join("tmp", "no_blender"), filepath.Join("tmp", "project", "scene.blend"), filepath.Join("tmp", "out_none"))
+	assert.Error(err)
+}
@@ -0,0 +1,127 @@
+package bundle
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+//collectScript is run by blender on the file to collect. It prints the external files used by the file and,
+//if none is missing, saves a copy of the file in the folder given after "--" with these files remapped
+//to their relative path in the folder. Files outside of the folder of the blend file are put in external/.
+const collectScript = `import bpy, os, sys
+
+dst = sys.argv[sys.argv.index("--") + 1]
+root = os.path.dirname(bpy.data.filepath)
+
+collections = [bpy.data.images, bpy.data.libraries, bpy.data.sounds, bpy.data.movieclips, bpy.data.fonts, bpy.data.cache_files]
+if hasattr(bpy.data, "volumes"):
+    collections.append(bpy.data.volumes)
+
+used = {}
+missing = False
+for coll in collections:
+    for block in coll:
+        if getattr(block, "packed_file", None) is not None or getattr(block, "library", None) is not None:
+            continue
+        fp = getattr(block, "filepath", "")
+        if not fp or fp == "<builtin>":
+            continue
+        src = os.path.normpath(bpy.path.abspath(fp))
+        if not os.path.isfile(src):
+            print("BLENDERER_MISSING\t" + src)
+            missing = True
+            continue
+        if src not in used:
+            try:
+                rel = os.path.relpath(src, root)
+            except ValueError:
+                rel = ".."
+            if rel.startswith(".."):
+                rel = os.path.join("external", str(len(used)) + "_" + os.path.basename(src))
+            used[src] = rel.replace(os.sep, "/")
+            print("BLENDERER_DEP\t" + src + "\t" + used[src])
+        block.filepath = "//" + used[src]
+
+if not missing:
+    bpy.ops.wm.save_as_mainfile(filepath=os.path.join(dst, os.path.basename(bpy.data.filepath)), copy=True, relative_remap=False)
+`
+
+//Dependency is an external file used by a blend file
+type Dependency struct {
+	Source string
+	Path   string
+}
+
+//MissingFilesError is returned when external files used by a blend file don't exist
+type MissingFilesError struct {
+	Files []string
+}
+
+func (e *MissingFilesError) Error() string {
+	return "missing files : " + strings.Join(e.Files, ", ")
+}
+
+//Collect runs blender in background mode to copy the blend file in the folder dst along with the external files it uses,
+//their paths being rewritten relative to dst. A *MissingFilesError is returned if some of them don't exist,
+//in which case nothing is copied.
+func Collect(blender, file, dst string) ([]Dependency, error) {
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(absDst, os.ModePerm); err != nil {
+		return nil, err
+	}
+
+	out, err := exec.Command(blender, "-b", "-noaudio", file, "--python-exit-code", "1", "--python-expr", collectScript, "--", absDst).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("couldn't collect the dependencies of %s : %w\n%s", file, err, out)
+	}
+
+	var deps []Dependency
+	var missing []string
+
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Split(strings.TrimRight(line, "\r"), "\t")
+
+		switch {
+		case fields[0] == "BLENDERER_DEP" && len(fields) == 3:
+			if !IsLocal(fields[2]) {
+				return nil, fmt.Errorf("illegal path %s for dependency %s", fields[2], fields[1])
+			}
+			deps = append(deps, Dependency{Source: fields[1], Path: fields[2]})
+		case fields[0] == "BLENDERER_MISSING" && len(fields) == 2:
+			missing = append(missing, fields[1])
+		}
+	}
+
+	if len(missing) > 0 {
+		return deps, &MissingFilesError{Files: missing}
+	}
+
+	if _, err := os.Stat(filepath.Join(absDst, filepath.Base(file))); err != nil {
+		return deps, fmt.Errorf("blender didn't save the collected copy of %s", file)
+	}
+
+	for _, d := range deps {
+		if err := copyFile(d.Source, filepath.Join(absDst, filepath.FromSlash(d.Path))); err != nil {
+			return deps, err
+		}
+	}
+
+	return deps, nil
+}
+
+//copyFile copies the file src to dst, creating the parent folders of dst
+func copyFile(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return writeFile(dst, f)
+}
@@ -4,18 +4,23 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/LeoMarche/blenderer/src/bundle"
+	"github.com/LeoMarche/blenderer/src/filexchange"
+	"github.com/LeoMarche/blenderer/src/render"
 	"github.com/LeoMarche/blenderer/src/rendererapi"
 )
 
@@ -23,9 +28,29 @@ var certPath = flag.String("c", "../host.cert", "Path to the SSL cert for connec
 var apiKey = flag.String("k", "sample_unsecure", "API key to use to connect to the API")
 var URL = flag.String("u", "https://localhost:9000", "URL to use to connect to the API")
 var fileServer = flag.String("fs", "localhost:9005", "IP and port of the fileserver distributing the render files")
-var insecure = flag.Bool("i", false, "set this flag to allow insecure connections to API")
-
-func initialize() *http.Client {
+var insecure = flag.Bool("i", false, "set this flag to allow insecure connections to API and file server")
+var scene = flag.String("scene", "", "scene to render instead of the one saved in the file")
+var camera = flag.String("camera", "", "camera to render from instead of the one saved in the file")
+var resX = flag.Int("resX", 0, "horizontal resolution of the render, 0 keeps the one saved in the file")
+var resY = flag.Int("resY", 0, "vertical resolution of the render, 0 keeps the one saved in the file")
+var resPercent = flag.Int("resPercent", 0, "percentage of the resolution to render, 0 keeps the one saved in the file")
+var samples = flag.Int("samples", 0, "number of samples of the render, 0 keeps the one saved in the file")
+var format = flag.String("format", "", "output format among PNG, EXR, JPEG and TIFF, PNG by default")
+var depth = flag.String("depth", "", "color depth of the output images, e.g. 8, 16 or 32")
+var mainFile = flag.String("main", "", "path of the file to render inside the posted folder or archive")
+var collect = flag.Bool("collect", false, "set this flag to post a blend file along with the external files it uses")
+var blenderPath = flag.String("blender", "blender", "blender executable used to collect the external files of a blend file")
+var priority = flag.Int("priority", 0, "priority of the posted job, jobs of higher priority are rendered first")
+var chunkSize = flag.Int("chunk", 1, "number of consecutive frames rendered by a node in a single run of the renderer")
+var frameStep = flag.Int("step", 1, "render every Nth frame from frameStart to frameStop, e.g. for previews")
+var tilesX = flag.Int("tilesX", 0, "number of columns of tiles each frame is split into, rendered by different nodes")
+var tilesY = flag.Int("tilesY", 0, "number of rows of tiles each frame is split into, rendered by different nodes")
+var stop = flag.Bool("stop", false, "set this flag to stop the frames being rendered when pausing a job, the frames already rendered being kept")
+var force = flag.Bool("force", false, "set this flag to delete a job even if frames of it are being rendered")
+var attempts = flag.Int("attempts", 5, "number of attempts of a transfer interrupted by the network, each of them resuming where the previous one stopped")
+
+//tlsConfig returns the TLS configuration trusting the certificate of the server, used by the API and the file server
+func tlsConfig() *tls.Config {
 	// Get the SystemCertPool, continue with an empty pool on error
 	rootCAs, _ := x509.SystemCertPool()
 	if rootCAs == nil {
@@ -44,83 +69,184 @@ func initialize() *http.Client {
 	}
 
 	// Trust the augmented cert pool in our client
-	config := &tls.Config{
+	return &tls.Config{
 		InsecureSkipVerify: *insecure,
 		RootCAs:            rootCAs,
 	}
+}
+
+//printProgress displays the progress of the transfer of a file on a single line, each time its percentage changes
+func printProgress(action, name string) func(done, total int64) {
+	last := int64(-1)
+	return func(done, total int64) {
+		percent := done * 100 / total
+		if percent == last {
+			return
+		}
+		last = percent
+		fmt.Printf("\r%s %s : %d%% (%d/%d bytes)", action, name, percent, done, total)
+		if done == total {
+			fmt.Println()
+		}
+	}
+}
+
+func initialize(config *tls.Config) *http.Client {
 	tr := &http.Transport{TLSClientConfig: config}
 	return &http.Client{Transport: tr}
 }
 
-func uploadFile(serverIP, ID, filepath string) error {
-	c, err := net.Dial("tcp", serverIP)
+func postJob(APIendpoint, APIkey, project, input, output, frames, rendererName, rendererVersion, startTime, bundleName string, priority, chunkSize int, settings render.Settings, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/postJob"
+
+	values := url.Values{
+		"api_key":         {APIkey},
+		"project":         {project},
+		"input":           {input},
+		"output":          {output},
+		"frames":          {frames},
+		"rendererName":    {rendererName},
+		"rendererVersion": {rendererVersion},
+		"startTime":       {startTime},
+		"bundle":          {bundleName},
+		"priority":        {strconv.Itoa(priority)},
+		"chunkSize":       {strconv.Itoa(chunkSize)},
+		"scene":           {settings.Scene},
+		"camera":          {settings.Camera},
+		"format":          {settings.Format},
+		"colorDepth":      {settings.ColorDepth}}
+
+	// Only send the numeric settings that override the file
+	for name, v := range map[string]int{
+		"resolutionX":       settings.ResolutionX,
+		"resolutionY":       settings.ResolutionY,
+		"resolutionPercent": settings.ResolutionPercent,
+		"samples":           settings.Samples,
+		"tilesX":            settings.TilesX,
+		"tilesY":            settings.TilesY} {
+		if v != 0 {
+			values.Set(name, strconv.Itoa(v))
+		}
+	}
+
+	// Uses local self-signed cert
+	resp, err := client.PostForm(finalEndpoint, values)
+
 	if err != nil {
 		return err
 	}
-	st, err := os.Stat(filepath)
+
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+//id, api_key, size, sha256 and project
+func uploadCompleted(APIendpoint, APIkey, project, id, size, sum, input string, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/uploadCompleted"
+
+	// Uses local self-signed cert
+	resp, err := client.PostForm(finalEndpoint, url.Values{
+		"api_key": {APIkey},
+		"project": {project},
+		"id":      {id},
+		"size":    {size},
+		"sha256":  {sum},
+		"input":   {input}})
+
 	if err != nil {
 		return err
 	}
-	toSend := []byte("SEND " + strconv.FormatInt(st.Size(), 10) + " " + ID + " " + path.Base(filepath))
-	_, err = c.Write(toSend)
+
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+func setPriority(APIendpoint, APIkey, id, priority string, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/setPriority"
+
+	resp, err := client.PostForm(finalEndpoint, url.Values{
+		"api_key":  {APIkey},
+		"id":       {id},
+		"priority": {priority}})
+
 	if err != nil {
 		return err
 	}
-	src, err := os.Open(filepath)
+
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+//id, api_key, size and project
+func getAllRenders(APIendpoint, APIkey string, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/getAllRenderTasks"
+
+	// Uses local self-signed cert
+	resp, err := client.PostForm(finalEndpoint, url.Values{
+		"api_key": {APIkey}})
+
 	if err != nil {
 		return err
 	}
 
-	buf := make([]byte, 1024)
-	n, err := c.Read(buf)
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+//getFrames fills target with the tasks of the job, an error being returned with the state of the API if it refuses
+func getFrames(APIendpoint, APIkey, id string, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/getFrames"
+
+	resp, err := client.PostForm(finalEndpoint, url.Values{
+		"api_key": {APIkey},
+		"id":      {id}})
+
 	if err != nil {
 		return err
 	}
-	status := string(buf[:n])
 
-	if status != "READY" {
-		return fmt.Errorf("encountered status code %s instead of READY when trying to upload", status)
-	}
-	buf = make([]byte, 1024)
-	var nRead int64
-	nRead = 0
+	defer resp.Body.Close()
 
-	for nRead != st.Size() {
-		n, err := src.Read(buf)
-		if err != nil {
-			return err
+	if resp.StatusCode != http.StatusOK {
+		rv := new(rendererapi.ReturnValue)
+		if err := json.NewDecoder(resp.Body).Decode(rv); err != nil {
+			return fmt.Errorf("encountered status code %d when getting the frames", resp.StatusCode)
 		}
-		nRead += int64(n)
-		c.Write(buf[:n])
+		return errors.New(rv.State)
 	}
 
-	buf = make([]byte, 1024)
-	n, err = c.Read(buf)
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+//retryJob renders again the failed frames of the job
+func retryJob(APIendpoint, APIkey, id string, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/retryJob"
+
+	resp, err := client.PostForm(finalEndpoint, url.Values{
+		"api_key": {APIkey},
+		"id":      {id}})
+
 	if err != nil {
 		return err
 	}
-	fin := string(buf[:n])
 
-	if fin != "SUCCESS" {
-		return fmt.Errorf("encountered bad status after finishing upload : %s instead of SUCCESS", fin)
-	}
-	return nil
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(target)
 }
 
-func postJob(APIendpoint, APIkey, project, input, output, frameStart, frameStop, rendererName, rendererVersion, startTime string, client *http.Client, target interface{}) error {
-	finalEndpoint := APIendpoint + "/postJob"
+//rerenderFrames renders again the given frames of the job, even if they were rendered
+func rerenderFrames(APIendpoint, APIkey, id, frames string, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/rerenderFrames"
 
-	// Uses local self-signed cert
 	resp, err := client.PostForm(finalEndpoint, url.Values{
-		"api_key":         {APIkey},
-		"project":         {project},
-		"input":           {input},
-		"output":          {output},
-		"frameStart":      {frameStart},
-		"frameStop":       {frameStop},
-		"rendererName":    {rendererName},
-		"rendererVersion": {rendererVersion},
-		"startTime":       {startTime}})
+		"api_key": {APIkey},
+		"id":      {id},
+		"frames":  {frames}})
 
 	if err != nil {
 		return err
@@ -131,17 +257,14 @@ func postJob(APIendpoint, APIkey, project, input, output, frameStart, frameStop,
 	return json.NewDecoder(resp.Body).Decode(target)
 }
 
-//id, api_key, size and project
-func uploadCompleted(APIendpoint, APIkey, project, id, size, input string, client *http.Client, target interface{}) error {
-	finalEndpoint := APIendpoint + "/uploadCompleted"
+//pauseJob stops giving the waiting frames of the job to the nodes, stopping the frames being rendered if stop is set
+func pauseJob(APIendpoint, APIkey, id string, stop bool, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/pauseJob"
 
-	// Uses local self-signed cert
 	resp, err := client.PostForm(finalEndpoint, url.Values{
 		"api_key": {APIkey},
-		"project": {project},
 		"id":      {id},
-		"size":    {size},
-		"input":   {input}})
+		"stop":    {strconv.FormatBool(stop)}})
 
 	if err != nil {
 		return err
@@ -152,13 +275,31 @@ func uploadCompleted(APIendpoint, APIkey, project, id, size, input string, clien
 	return json.NewDecoder(resp.Body).Decode(target)
 }
 
-//id, api_key, size and project
-func getAllRenders(APIendpoint, APIkey string, client *http.Client, target interface{}) error {
-	finalEndpoint := APIendpoint + "/getAllRenderTasks"
+//resumeJob gives the paused frames of the job to the nodes again
+func resumeJob(APIendpoint, APIkey, id string, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/resumeJob"
 
-	// Uses local self-signed cert
 	resp, err := client.PostForm(finalEndpoint, url.Values{
-		"api_key": {APIkey}})
+		"api_key": {APIkey},
+		"id":      {id}})
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	return json.NewDecoder(resp.Body).Decode(target)
+}
+
+//deleteJob removes the job and its files from the server
+func deleteJob(APIendpoint, APIkey, id string, force bool, client *http.Client, target interface{}) error {
+	finalEndpoint := APIendpoint + "/deleteJob"
+
+	resp, err := client.PostForm(finalEndpoint, url.Values{
+		"api_key": {APIkey},
+		"id":      {id},
+		"force":   {strconv.FormatBool(force)}})
 
 	if err != nil {
 		return err
@@ -184,17 +325,68 @@ func customUsage() {
 
 	operationsHelp := `Operations :
     post-job <file> <frameStart> <frameStop> <rendererName> <rendererVersion>
+    post-job <file> <frames> <rendererName> <rendererVersion>
         Description:
             Posts a new render to the rendering system
         Arguments:
-            <file> : path to the file that needs to be posted
+            <file> : path to the file that needs to be posted, or to a folder or a zip/tar archive containing the project,
+                     the file to render inside of it being given with -main. With -collect, the blend file is posted
+                     along with the external files it uses, after checking that none is missing
             <frameStart> : number of the initial frame to render
-            <frameStop> : number of the last frame to render
+            <frameStop> : number of the last frame to render, every -step frames being rendered from frameStart
+            <frames> : list of the frames and ranges to render, such as 1-10,15,20-40x5 where x5 renders every 5th frame
             <rendererName> : name of the renderer to use
-            <rendererVersion> : version of the renderer to use
+            <rendererVersion> : version of the renderer to use, or a constraint such as "~3.6" or ">=3.6 <4.0"
     get-all
         Description:
             Get all tasks handled by the rendering system and returns stats
+    set-priority <id> <priority>
+        Description:
+            Changes the priority of a job, jobs of higher priority are rendered first
+        Arguments:
+            <id> : token/ID of the job
+            <priority> : new priority of the job
+    get-frames <id>
+        Description:
+            Lists the frames of a job with their state, the number of times they failed and their last error.
+            Frames failing too many times are "failed" and aren't rendered again
+        Arguments:
+            <id> : token/ID of the job
+    retry <id>
+        Description:
+            Renders again the failed frames of a job, forgetting their failed attempts
+        Arguments:
+            <id> : token/ID of the job
+    rerender <id> <frames>
+        Description:
+            Renders again frames of a job, even if they were rendered, e.g. after noticing a bad output
+        Arguments:
+            <id> : token/ID of the job
+            <frames> : list of the frames and ranges to render again, such as 1-10,15,20-40x5
+    pause <id>
+        Description:
+            Pauses a job, its waiting frames aren't rendered until it is resumed. With -stop, the frames being rendered
+            are stopped too, the frames of their chunks already rendered being kept
+        Arguments:
+            <id> : token/ID of the job
+    resume <id>
+        Description:
+            Resumes a paused job
+        Arguments:
+            <id> : token/ID of the job
+    delete <id>
+        Description:
+            Deletes a job, its frames and its files on the server. It is refused while frames of the job are being
+            rendered, unless -force is set
+        Arguments:
+            <id> : token/ID of the job
+    download <id> <folder>
+        Description:
+            Downloads the rendered frames of a job from the file server, verifying their SHA-256. The frames already
+            in the folder are skipped
+        Arguments:
+            <id> : token/ID of the job
+            <folder> : folder where the frames are written
 
 `
 	fmt.Fprint(flag.CommandLine.Output(), operationsHelp)
@@ -202,8 +394,39 @@ func customUsage() {
 	examplesHelp := `Examples :
     Post a new render:
         ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 post-job dummy.blend 1 5 blender 2.91.0
+    Post a new render with the latest 3.6 LTS patch installed on the nodes:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 post-job dummy.blend 1 5 blender "~3.6"
+    Post a new render of a project folder with its textures and libraries:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 -main scenes/dummy.blend post-job dummy_project 1 5 blender 2.91.0
+    Post a new render of a blend file along with the textures and libraries it uses:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 -collect -blender /opt/blender/blender post-job dummy.blend 1 5 blender 2.91.0
+    Post a new render at half resolution with 64 samples in 32 bits EXR:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 -resPercent 50 -samples 64 -format EXR -depth 32 post-job dummy.blend 1 5 blender 2.91.0
+    Post a new render of fast frames, rendering them by chunks of 10 frames:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 -chunk 10 post-job dummy.blend 1 250 blender 2.91.0
+    Post a preview rendering every 10th frame:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 -step 10 post-job dummy.blend 1 250 blender 2.91.0
+    Post a new render of a list of frames and ranges:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 post-job dummy.blend 1-10,15,20-40x5 blender 2.91.0
+    Post a still at print resolution split in 4x4 tiles rendered on different nodes and stitched by the server:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 -resX 12000 -resY 8000 -tilesX 4 -tilesY 4 post-job dummy.blend 1 1 blender 2.91.0
     Get stats on renders:
         ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 get-all
+    List the frames of a job and their failures:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 get-frames <token>
+    Render again the failed frames of a job:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 retry <token>
+    Render again some frames of a job:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 rerender <token> 12-14,40
+    Pause a job and stop its frames being rendered, then resume it:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 -stop pause <token>
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 resume <token>
+    Delete a job and its files, stopping its frames being rendered:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 -force delete <token>
+    Render a job before the others:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 set-priority <token> 10
+    Download the rendered frames of a job:
+        ./cli -c dummy_cert.cert -fs fil.server:9005 -i -k secured_key -u api.server:9000 download <token> renders/
 
 `
 
@@ -216,7 +439,8 @@ func main() {
 	flag.Usage = customUsage
 
 	flag.Parse()
-	client := initialize()
+	config := tlsConfig()
+	client := initialize(config)
 
 	argTab := flag.Args()
 
@@ -225,43 +449,145 @@ func main() {
 	switch command {
 	case "post-job":
 		// check if the correct number of arguments was given
-		if len(argTab) != 6 {
-			log.Fatal(fmt.Errorf("post-job called with %d arguments instead of 6", len(argTab)))
+		if len(argTab) != 5 && len(argTab) != 6 {
+			log.Fatal(fmt.Errorf("post-job called with %d arguments instead of 5 or 6", len(argTab)))
 		}
 
 		fPath := argTab[1]
-		frameStart := argTab[2]
-		frameStop := argTab[3]
-		rName := argTab[4]
-		rVer := argTab[5]
+		frames := argTab[2]
+		rName := argTab[len(argTab)-2]
+		rVer := argTab[len(argTab)-1]
+
+		// Turn the range into a list of frames
+		if len(argTab) == 6 {
+			frameStart := argTab[2]
+			frameStop := argTab[3]
+			if _, err := strconv.Atoi(frameStart); err != nil {
+				log.Fatal(fmt.Errorf("post-job called with frameStart=%s which doesn't looks like an int", frameStart))
+			}
+			if _, err := strconv.Atoi(frameStop); err != nil {
+				log.Fatal(fmt.Errorf("post-job called with frameStop=%s which doesn't looks like an int", frameStop))
+			}
+			frames = fmt.Sprintf("%s-%sx%d", frameStart, frameStop, *frameStep)
+		}
+
+		// Verify the frames before uploading anything
+		if _, err := render.ParseFrames(frames); err != nil {
+			log.Fatal(fmt.Errorf("post-job called with %v", err))
+		}
 
-		// Verify that frames can be casted to ints
-		if _, err := strconv.Atoi(frameStart); err != nil {
-			log.Fatal(fmt.Errorf("post-job called with frameStart=%s which doesn't looks like an int", frameStart))
+		// Collect the blend file and its external files in a folder posted as a bundle
+		if *collect {
+			if st, err := os.Stat(fPath); err != nil || st.IsDir() || bundle.IsArchive(fPath) {
+				log.Fatal(fmt.Errorf("post-job called with -collect on %s which isn't a blend file", fPath))
+			}
+
+			tmpDir, err := ioutil.TempDir("", "blenderer")
+			if err != nil {
+				log.Fatal(err)
+			}
+			defer os.RemoveAll(tmpDir)
+
+			collected := filepath.Join(tmpDir, strings.TrimSuffix(filepath.Base(fPath), filepath.Ext(fPath)))
+			deps, err := bundle.Collect(*blenderPath, fPath, collected)
+			if missing, ok := err.(*bundle.MissingFilesError); ok {
+				fmt.Printf("Files used by %s are missing, nothing was uploaded :\n", fPath)
+				for _, f := range missing.Files {
+					fmt.Printf("    - %s\n", f)
+				}
+				os.RemoveAll(tmpDir)
+				os.Exit(1)
+			}
+			if err != nil {
+				log.Fatal(err)
+			}
+			fmt.Printf("Collected %d external files used by %s\n", len(deps), fPath)
+
+			*mainFile = filepath.Base(fPath)
+			fPath = collected
+		}
+
+		// Folders and archives are posted as a bundle containing the main file
+		st, err := os.Stat(fPath)
+		if err != nil {
+			log.Fatal(err)
 		}
-		if _, err := strconv.Atoi(frameStop); err != nil {
-			log.Fatal(fmt.Errorf("post-job called with frameStop=%s which doesn't looks like an int", frameStop))
+
+		project := path.Base(fPath)
+		input := path.Base(fPath)
+		bundleName := ""
+		toUpload := fPath
+
+		if st.IsDir() || bundle.IsArchive(fPath) {
+			if *mainFile == "" {
+				log.Fatal(fmt.Errorf("post-job called on the bundle %s without -main", fPath))
+			}
+			input = filepath.ToSlash(*mainFile)
+			if !bundle.IsLocal(input) {
+				log.Fatal(fmt.Errorf("main file %s must be a relative path inside the bundle", *mainFile))
+			}
+
+			if st.IsDir() {
+				tmpDir, err := ioutil.TempDir("", "blenderer")
+				if err != nil {
+					log.Fatal(err)
+				}
+				defer os.RemoveAll(tmpDir)
+
+				project = filepath.Base(filepath.Clean(fPath))
+				toUpload = filepath.Join(tmpDir, project+".zip")
+				if err := bundle.Create(fPath, toUpload); err != nil {
+					log.Fatal(err)
+				}
+			}
+			bundleName = filepath.Base(toUpload)
 		}
 
 		startTime := strconv.FormatInt(time.Now().UnixNano(), 10)
 		up := new(rendererapi.Upload)
-		err := postJob(*URL, *apiKey, path.Base(fPath), path.Base(fPath), path.Base(fPath), frameStart, frameStop, rName, rVer, startTime, client, up)
+		settings := render.Settings{
+			Scene:             *scene,
+			Camera:            *camera,
+			ResolutionX:       *resX,
+			ResolutionY:       *resY,
+			ResolutionPercent: *resPercent,
+			Samples:           *samples,
+			Format:            *format,
+			ColorDepth:        *depth,
+			TilesX:            *tilesX,
+			TilesY:            *tilesY,
+		}
+		err = postJob(*URL, *apiKey, project, input, path.Base(input), frames, rName, rVer, startTime, bundleName, *priority, *chunkSize, settings, client, up)
 		if err != nil {
 			log.Fatal(err)
 		}
+		if up.State != "ready" {
+			log.Fatal(fmt.Errorf("job refused by the API : %s", up.State))
+		}
 		fmt.Printf("Task created, token/ID : %s, project : %s, current state : %s\n", up.Token, up.Project, up.State)
-		err = uploadFile(*fileServer, up.Token, fPath)
+		fileClient := &filexchange.Client{
+			Address:    *fileServer,
+			TLS:        config,
+			Credential: up.Transfer,
+			Attempts:   *attempts,
+			Progress:   printProgress("Uploading", filepath.Base(toUpload)),
+		}
+		err = fileClient.Send(up.Token, toUpload)
 		if err != nil {
 			log.Fatal(err)
 		}
-		st, err := os.Stat(fPath)
+		st, err = os.Stat(toUpload)
 		if err != nil {
 			log.Fatal(err)
 		}
 		size := strconv.FormatInt(st.Size(), 10)
+		sum, err := filexchange.HashFile(toUpload)
+		if err != nil {
+			log.Fatal(err)
+		}
 		ID := up.Token
 		rv := new(rendererapi.ReturnValue)
-		err = uploadCompleted(*URL, *apiKey, path.Base(fPath), ID, size, path.Base(fPath), client, rv)
+		err = uploadCompleted(*URL, *apiKey, project, ID, size, sum, filepath.Base(toUpload), client, rv)
 		if err != nil {
 			log.Fatal(err)
 		}
@@ -280,5 +606,166 @@ func main() {
 		} else {
 			fmt.Println(*ret)
 		}
+
+	case "set-priority":
+		//Check the number of arguments to call set-priority
+		if len(argTab) != 3 {
+			log.Fatal(fmt.Errorf("set-priority called with %d arguments instead of 3", len(argTab)))
+		}
+
+		if _, err := strconv.Atoi(argTab[2]); err != nil {
+			log.Fatal(fmt.Errorf("set-priority called with priority=%s which doesn't looks like an int", argTab[2]))
+		}
+
+		rv := new(rendererapi.ReturnValue)
+		err := setPriority(*URL, *apiKey, argTab[1], argTab[2], client, rv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Priority changed, current state : %s\n", rv.State)
+
+	case "get-frames":
+		//Check the number of arguments to call get-frames
+		if len(argTab) != 2 {
+			log.Fatal(fmt.Errorf("get-frames called with %d arguments instead of 2", len(argTab)))
+		}
+
+		frames := new([]rendererapi.FrameStatus)
+		err := getFrames(*URL, *apiKey, argTab[1], client, frames)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tiled := false
+		for _, f := range *frames {
+			tiled = tiled || f.Tile > 0
+		}
+		for _, f := range *frames {
+			name := strconv.Itoa(f.Frame)
+			if f.FrameEnd > f.Frame {
+				name += "-" + strconv.Itoa(f.FrameEnd)
+			}
+			if tiled {
+				name += " tile " + strconv.Itoa(f.Tile)
+			}
+			fmt.Printf("Frame %s : %s, %d failed attempts", name, f.State, f.Attempts)
+			if f.LastError != "" {
+				fmt.Printf(", last error : %s", f.LastError)
+			}
+			fmt.Println()
+		}
+
+	case "retry":
+		//Check the number of arguments to call retry
+		if len(argTab) != 2 {
+			log.Fatal(fmt.Errorf("retry called with %d arguments instead of 2", len(argTab)))
+		}
+
+		rv := new(rendererapi.ReturnValue)
+		err := retryJob(*URL, *apiKey, argTab[1], client, rv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Failed frames retried, current state : %s\n", rv.State)
+
+	case "rerender":
+		//Check the number of arguments to call rerender
+		if len(argTab) != 3 {
+			log.Fatal(fmt.Errorf("rerender called with %d arguments instead of 3", len(argTab)))
+		}
+
+		if _, err := render.ParseFrames(argTab[2]); err != nil {
+			log.Fatal(fmt.Errorf("rerender called with %v", err))
+		}
+
+		rv := new(rendererapi.ReturnValue)
+		err := rerenderFrames(*URL, *apiKey, argTab[1], argTab[2], client, rv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Frames rerendered, current state : %s\n", rv.State)
+
+	case "pause":
+		//Check the number of arguments to call pause
+		if len(argTab) != 2 {
+			log.Fatal(fmt.Errorf("pause called with %d arguments instead of 2", len(argTab)))
+		}
+
+		rv := new(rendererapi.ReturnValue)
+		err := pauseJob(*URL, *apiKey, argTab[1], *stop, client, rv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Job paused, current state : %s\n", rv.State)
+
+	case "resume":
+		//Check the number of arguments to call resume
+		if len(argTab) != 2 {
+			log.Fatal(fmt.Errorf("resume called with %d arguments instead of 2", len(argTab)))
+		}
+
+		rv := new(rendererapi.ReturnValue)
+		err := resumeJob(*URL, *apiKey, argTab[1], client, rv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Job resumed, current state : %s\n", rv.State)
+
+	case "delete":
+		//Check the number of arguments to call delete
+		if len(argTab) != 2 {
+			log.Fatal(fmt.Errorf("delete called with %d arguments instead of 2", len(argTab)))
+		}
+
+		rv := new(rendererapi.ReturnValue)
+		err := deleteJob(*URL, *apiKey, argTab[1], *force, client, rv)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("Job deleted, current state : %s\n", rv.State)
+
+	case "download":
+		//Check the number of arguments to call download
+		if len(argTab) != 3 {
+			log.Fatal(fmt.Errorf("download called with %d arguments instead of 3", len(argTab)))
+		}
+
+		frames := new([]rendererapi.FrameStatus)
+		err := getFrames(*URL, *apiKey, argTab[1], client, frames)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := os.MkdirAll(argTab[2], os.ModePerm); err != nil {
+			log.Fatal(err)
+		}
+
+		//The frames already downloaded are skipped, the others are verified once downloaded
+		downloaded := 0
+		for _, f := range *frames {
+			for _, o := range f.Outputs {
+				dst := filepath.Join(argTab[2], o.Name)
+				if sum, err := filexchange.HashFile(dst); err == nil && sum == o.Checksum {
+					continue
+				}
+				fileClient := &filexchange.Client{
+					Address:    *fileServer,
+					TLS:        config,
+					Credential: *apiKey,
+					Attempts:   *attempts,
+					Progress:   printProgress("Downloading", o.Name),
+				}
+				if err := fileClient.Receive(argTab[1], o.Name, argTab[2]); err != nil {
+					log.Fatal(err)
+				}
+				sum, err := filexchange.HashFile(dst)
+				if err != nil {
+					log.Fatal(err)
+				}
+				if sum != o.Checksum {
+					log.Fatal(fmt.Errorf("checksum %s of the downloaded frame %s instead of %s", sum, o.Name, o.Checksum))
+				}
+				downloaded++
+			}
+		}
+		fmt.Printf("%d frames downloaded to %s\n", downloaded, argTab[2])
 	}
 }
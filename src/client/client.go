@@ -4,12 +4,10 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"encoding/json"
-	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
@@ -17,16 +15,19 @@ import (
 	"path"
 	"path/filepath"
 	"strconv"
-	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
+	"github.com/LeoMarche/blenderer/src/bundle"
+	"github.com/LeoMarche/blenderer/src/filexchange"
+	"github.com/LeoMarche/blenderer/src/node"
 	"github.com/LeoMarche/blenderer/src/render"
 	"github.com/LeoMarche/blenderer/src/rendererapi"
 )
 
 var nameFlag = flag.String("n", "no_name", "The name of the worker")
-var insecure = flag.Bool("i", false, "set this flag to allow insecure connections to API")
+var insecure = flag.Bool("i", false, "set this flag to allow insecure connections to API and file server")
 var folderFlag = flag.String("f", "", "by setting this flag, you can optionnally provide a folder not using the config file")
 
 type configuration struct {
@@ -46,6 +47,8 @@ type returnvalue struct {
 
 const (
 	localCertFile = "../host.cert"
+	//transferAttempts is the number of attempts of a transfer with the file server interrupted by the network
+	transferAttempts = 3
 )
 
 var mustStop = false
@@ -74,7 +77,8 @@ func SetupCloseHandler() {
 	}()
 }
 
-func getClient() *http.Client {
+//getTLSConfig returns the TLS configuration trusting the certificate of the master, used by the API and the file server
+func getTLSConfig() *tls.Config {
 
 	flag.Parse()
 
@@ -96,10 +100,13 @@ func getClient() *http.Client {
 	}
 
 	// Trust the augmented cert pool in our client
-	config := &tls.Config{
+	return &tls.Config{
 		InsecureSkipVerify: *insecure,
 		RootCAs:            rootCAs,
 	}
+}
+
+func getClient(config *tls.Config) *http.Client {
 	tr := &http.Transport{TLSClientConfig: config}
 	return &http.Client{Transport: tr}
 }
@@ -123,7 +130,7 @@ func getJob(APIendpoint string, APIkey string, name string, target interface{},
 	return json.NewDecoder(resp.Body).Decode(target)
 }
 
-func updateJob(APIendpoint, APIkey, state, name string, frame int, percent, mem float64, id string, target interface{}, client *http.Client) error {
+func updateJob(APIendpoint, APIkey, state, name string, frame, tile int, percent, mem float64, done int, warning, id string, target interface{}, client *http.Client) error {
 
 	finalEndpoint := APIendpoint + "/updateJob"
 
@@ -134,8 +141,11 @@ func updateJob(APIendpoint, APIkey, state, name string, frame int, percent, mem
 		"state":   {state},
 		"name":    {name},
 		"frame":   {strconv.Itoa(frame)},
+		"tile":    {strconv.Itoa(tile)},
 		"percent": {strconv.FormatFloat(percent, 'f', -1, 64)},
-		"mem":     {strconv.FormatFloat(mem, 'f', -1, 64)}})
+		"mem":     {strconv.FormatFloat(mem, 'f', -1, 64)},
+		"done":    {strconv.Itoa(done)},
+		"warning": {warning}})
 
 	if err != nil {
 		return err
@@ -146,12 +156,23 @@ func updateJob(APIendpoint, APIkey, state, name string, frame int, percent, mem
 	return json.NewDecoder(resp.Body).Decode(target)
 }
 
-func postNode(APIendpoint, APIkey, name string, target interface{}, client *http.Client) error {
+func postNode(APIendpoint, APIkey, name string, executables []render.Renderer, target interface{}, client *http.Client) error {
 	finalEndpoint := APIendpoint + "/postNode"
 
+	//Advertise the installed renderers so that the master only gives the node the jobs it can render
+	renderers := []node.Renderer{}
+	for _, e := range executables {
+		renderers = append(renderers, node.Renderer{Name: e.Name, Version: e.Version})
+	}
+	js, err := json.Marshal(renderers)
+	if err != nil {
+		return err
+	}
+
 	resp, err := client.PostForm(finalEndpoint, url.Values{
-		"api_key": {APIkey},
-		"name":    {name}})
+		"api_key":   {APIkey},
+		"name":      {name},
+		"renderers": {string(js)}})
 
 	if err != nil {
 		return err
@@ -196,12 +217,14 @@ func setDown(APIendpoint, APIkey, name string, target interface{}, client *http.
 	return json.NewDecoder(resp.Body).Decode(target)
 }
 
-func errorNode(APIendpoint, APIkey, name string, target interface{}, client *http.Client) error {
+//errorNode puts the node in error on the master, the frames it was rendering failing with message
+func errorNode(APIendpoint, APIkey, name, message string, target interface{}, client *http.Client) error {
 	finalEndpoint := APIendpoint + "/errorNode"
 
 	resp, err := client.PostForm(finalEndpoint, url.Values{
 		"api_key": {APIkey},
-		"name":    {name}})
+		"name":    {name},
+		"error":   {message}})
 
 	if err != nil {
 		return err
@@ -212,120 +235,103 @@ func errorNode(APIendpoint, APIkey, name string, target interface{}, client *htt
 	return json.NewDecoder(resp.Body).Decode(target)
 }
 
-func uploadFile(serverIP, ID, filepath string) error {
-	c, err := net.Dial("tcp", serverIP)
-	if err != nil {
-		return err
-	}
-	st, err := os.Stat(filepath)
-	if err != nil {
-		return err
-	}
-	toSend := []byte("SEND " + strconv.FormatInt(st.Size(), 10) + " " + ID + " " + path.Base(filepath))
-	_, err = c.Write(toSend)
-	if err != nil {
-		return err
-	}
-	src, err := os.Open(filepath)
-	if err != nil {
-		return err
-	}
+//verified holds the checksums of the files of the jobs already verified, by path
+var verified = map[string]string{}
 
-	buf := make([]byte, 1024)
-	n, err := c.Read(buf)
-	if err != nil {
-		return err
-	}
-	status := string(buf[:n])
+//receiveFile fetches the file name of the job id in dstFolder, unless it is there with the checksum sent with the job,
+//and verifies the checksum of the received file. Files are only fetched if missing when the checksum is unknown.
+//It returns true if the file was fetched.
+func receiveFile(fileServer *filexchange.Client, id, name, dstFolder, checksum string) (bool, error) {
+	file := filepath.Join(dstFolder, name)
 
-	if status != "READY" {
-		return fmt.Errorf("encountered status code %s instead of READY when trying to upload", status)
-	}
-	buf = make([]byte, 1024)
-	var nRead int64
-	nRead = 0
-
-	for nRead != st.Size() {
-		n, err := src.Read(buf)
-		if err != nil {
-			return err
+	if _, err := os.Stat(file); err == nil {
+		if checksum == "" || verified[file] == checksum {
+			return false, nil
 		}
-		nRead += int64(n)
-		c.Write(buf[:n])
-	}
-
-	buf = make([]byte, 1024)
-	n, err = c.Read(buf)
-	if err != nil {
-		return err
+		if sum, err := filexchange.HashFile(file); err == nil && sum == checksum {
+			verified[file] = checksum
+			return false, nil
+		}
+		fmt.Printf("Checksum of %s doesn't match the one of the job, fetching it again\n", name)
 	}
-	fin := string(buf[:n])
 
-	if fin != "SUCCESS" {
-		return fmt.Errorf("encountered bad status after finishing upload : %s instead of SUCCESS", fin)
+	if err := fileServer.Receive(id, name, dstFolder); err != nil {
+		return true, err
 	}
-	return nil
-}
-
-func receiveFile(fileServer, id, srcFile, dstFolder string) error {
-	c, err := net.Dial("tcp", fileServer)
-	if err != nil {
-		return err
+	if checksum == "" {
+		return true, nil
 	}
-	defer c.Close()
-	toSend := []byte("RECEIVE " + id + " " + srcFile)
-	_, err = c.Write(toSend)
+	sum, err := filexchange.HashFile(file)
 	if err != nil {
-		return err
+		return true, err
 	}
-	dst := path.Join(dstFolder, srcFile)
-	destination, err := os.Create(dst)
-	if err != nil {
-		return err
+	if sum != checksum {
+		os.Remove(file)
+		return true, fmt.Errorf("checksum %s of %s instead of %s", sum, name, checksum)
 	}
-	buf := make([]byte, 1024)
-	n, err := c.Read(buf)
+	verified[file] = checksum
+	return true, nil
+}
+
+//receiveBundle fetches the archive name of the job id if it isn't in dstFolder yet with the checksum of the job and
+//unpacks it there, unless its main file was already extracted from it
+func receiveBundle(fileServer *filexchange.Client, id, name, dstFolder, main, checksum string) error {
+	archive := filepath.Join(dstFolder, name)
+
+	fetched, err := receiveFile(fileServer, id, name, dstFolder, checksum)
 	if err != nil {
 		return err
 	}
-	instr := strings.Split(string(buf[:n]), " ")
-	if len(instr) != 2 {
-		return fmt.Errorf("expected instructions of length 2, got %d instead", len(instr))
+
+	if _, err := os.Stat(main); err == nil && !fetched {
+		return nil
 	}
-	status := instr[0]
-	ln, err := strconv.ParseInt(instr[1], 10, 64)
-	if err != nil {
+
+	if err := bundle.Extract(archive, dstFolder); err != nil {
 		return err
 	}
 
-	if status != "READY" {
-		return fmt.Errorf("expected status READY, got %s instead", status)
+	if _, err := os.Stat(main); err != nil {
+		return fmt.Errorf("main file %s not found in bundle %s", main, name)
 	}
+	return nil
+}
 
-	_, err = c.Write([]byte("GO"))
+//checkRender returns the progress of rT and a warning to report to the master if part of its output couldn't be parsed.
+//The warning is printed when it differs from the last one.
+func checkRender(rT *render.RendererTask, lastWarning string) (render.Progress, string) {
+	progress, err := rT.CheckRender()
 	if err != nil {
-		return err
+		if err.Error() != lastWarning {
+			fmt.Printf("Warning : couldn't parse the output of the render : %s\n", err.Error())
+		}
+		return progress, err.Error()
 	}
+	return progress, ""
+}
 
-	var nRead int64
-	nRead = 0
-
-	for nRead != ln {
-		n, err := c.Read(buf)
-		if err != nil {
-			return err
+//uploadRendered uploads the frames of the chunk of rT rendered after the first done ones and returns the new number of
+//frames done. A frame is rendered once the renderer moved to a next frame, or once the whole render is.
+func uploadRendered(fileServer *filexchange.Client, rT *render.RendererTask, progress render.Progress, done int) int {
+	for f := rT.Task.Frame + done; f <= rT.Task.LastFrame(); f++ {
+		if progress.State != "rendered" && f >= progress.Frame {
+			break
+		}
+		if err := fileServer.Send(rT.Task.ID, rT.OutputFile(f)); err != nil {
+			fmt.Printf("Couldn't upload frame %d : %s\n", f, err.Error())
+			break
 		}
-		nRead += int64(n)
-		destination.Write(buf[:n])
+		done++
 	}
+	return done
+}
 
-	_, err = c.Write([]byte("SUCCESS"))
-
-	if err != nil {
-		return err
+//chunkFraction returns the fraction of the chunk of rT rendered, the frames done counting fully
+func chunkFraction(rT *render.RendererTask, progress render.Progress, done int) float64 {
+	if progress.State == "rendered" {
+		return 1
 	}
-
-	return nil
+	return (float64(done) + progress.Fraction) / float64(rT.Task.Frames())
 }
 
 func run(configPath string) {
@@ -360,85 +366,121 @@ func run(configPath string) {
 	}
 
 	// Register the client on the master
-	client := getClient()
+	tlsConfig := getTLSConfig()
+	client := getClient(tlsConfig)
 	job := new(render.Task)
 	resp := new(rendererapi.ReturnValue)
-	err = postNode(config.API.Endpoint, config.API.Key, *nameFlag, resp, client)
+	err = postNode(config.API.Endpoint, config.API.Key, *nameFlag, config.Executables, resp, client)
 	if err != nil || (resp.State != "Exists" && resp.State != "Added") {
 		log.Fatalf("Error during initialization : %e, state : %s", err, resp.State)
 	}
 
 	rT := new(render.RendererTask)
-	var state string
-	var percent, mem float64
+	var progress render.Progress
+	var warning string
+	var done int
 
 	for !mustStop {
 
 		// Retrieve a job from the master
-		err = getJob(config.API.Endpoint, config.API.Key, *nameFlag, job, client)
+		sent := rendererapi.JobToSend{Task: job}
+		err = getJob(config.API.Endpoint, config.API.Key, *nameFlag, &sent, client)
 		if err != nil {
 			log.Fatal(err)
 		}
+		fileServer := &filexchange.Client{Address: config.Fileserver, TLS: tlsConfig, Credential: sent.Transfer, Attempts: transferAttempts}
 
 		if job.ID != "" {
 
 			//Adapt the render.Task to fit reality
 			outputFolder := filepath.Join(config.Folder, job.ID)
 			os.MkdirAll(outputFolder, os.FileMode(0777))
-			job.Input = filepath.Join(outputFolder, job.Input)
+			job.Input = filepath.Join(outputFolder, filepath.FromSlash(job.Input))
 			job.Output = filepath.Join(outputFolder, job.Output)
 
-			if _, err := os.Stat(job.Input); errors.Is(err, os.ErrNotExist) {
-				err = receiveFile(config.Fileserver, job.ID, path.Base(job.Input), outputFolder)
+			//The files are verified before rendering
+			if job.Bundle != "" {
+				err = receiveBundle(fileServer, job.ID, job.Bundle, outputFolder, job.Input, sent.Checksum)
 				if err != nil {
-					log.Fatalf("Error during receiving of file : %s", err.Error())
+					log.Fatalf("Error during receiving of bundle : %s", err.Error())
 				}
+			} else if _, err := receiveFile(fileServer, job.ID, path.Base(job.Input), outputFolder, sent.Checksum); err != nil {
+				log.Fatalf("Error during receiving of file : %s", err.Error())
 			}
 
 			//Create render task
 			rT = job.MatchRenderer(config.Executables)
 
+			// The master only gives the jobs of the advertised renderers, give it back if it isn't found anyway
+			if rT == nil {
+				s := new(returnvalue)
+				err = updateJob(config.API.Endpoint, config.API.Key, "requeue", *nameFlag, job.Frame, job.Tile, 0.0, 0.0, 0, "", job.ID, s, client)
+				if err != nil || s.State != "REQUEUED" {
+					log.Fatalf("Error while giving back a job with no matching renderer, state : %s", s.State)
+				}
+				job = new(render.Task)
+				time.Sleep(1 * time.Second)
+				continue
+			}
+
 			if rT != nil {
 
 				// Launch render if the rendering engine is present
-				// TODO : verify the available rendering engines on the master before assigning
+				done = 0
+				//Set when the render is killed on purpose, read by the goroutine waiting for it
+				var stopped int32
 				pr, err := rT.LaunchRender()
 
 				// If error during launching render, stop the client and put the node in error for the master
 				if err != nil {
 					rt := new(rendererapi.ReturnValue)
-					errorNode(config.API.Endpoint, config.API.Key, *nameFlag, rt, client)
+					errorNode(config.API.Endpoint, config.API.Key, *nameFlag, err.Error(), rt, client)
 					log.Fatal(err)
 				}
 
-				go func() {
-					err := pr.Wait()
+				go func(rT *render.RendererTask) {
+					err := rT.Wait()
 					var rt rendererapi.ReturnValue
-					if err != nil && !mustStop {
-						errorNode(config.API.Endpoint, config.API.Key, *nameFlag, rt, client)
+					if err != nil && !mustStop && atomic.LoadInt32(&stopped) == 0 {
+						errorNode(config.API.Endpoint, config.API.Key, *nameFlag, err.Error(), rt, client)
 						log.Fatalf("Error during rendering : %e", err)
 					}
-				}()
+				}(rT)
 
-				// Wait up to 100 seconds for the render engine to start
+				// Wait up to 100 seconds for the render engine to start, renewing the lease of the render every second
 				for i := 0; i < 1000; i++ {
-					state, percent, mem = rT.CheckRender()
-					if state == "rendering" || state == "rendered" {
+					progress, warning = checkRender(rT, warning)
+					if progress.State == "rendering" || progress.State == "rendered" {
 						break
 					}
+					if i%10 == 9 {
+						s := new(returnvalue)
+						updateJob(config.API.Endpoint, config.API.Key, "rendering", *nameFlag, rT.Task.Frame, rT.Task.Tile, 0.0, 0.0, 0, warning, rT.Task.ID, s, client)
+					}
 					time.Sleep(100 * time.Millisecond)
 				}
 
 				// Wait for the render to end (aborted or rendered)
-				for state != "rendered" {
+				for progress.State != "rendered" {
+					done = uploadRendered(fileServer, rT, progress, done)
 					s := new(returnvalue)
-					rv := updateJob(config.API.Endpoint, config.API.Key, state, *nameFlag, rT.Task.Frame, percent, mem, rT.Task.ID, s, client)
+					rv := updateJob(config.API.Endpoint, config.API.Key, progress.State, *nameFlag, rT.Task.Frame, rT.Task.Tile, chunkFraction(rT, progress, done), progress.Mem, done, warning, rT.Task.ID, s, client)
 					if rv != nil || s.State != "OK" {
 
-						//If aborting render
+						//If aborting render, the master already freed the node and doesn't wait for the render anymore
 						switch st := s.State; st {
 						case "ABORT":
 							fmt.Println("Order from master to abort render")
+							atomic.StoreInt32(&stopped, 1)
+						case "PAUSE":
+							fmt.Println("Order from master to pause render")
+							atomic.StoreInt32(&stopped, 1)
+						case "DELETED":
+							fmt.Println("Job deleted by the master, the render is cancelled")
+							atomic.StoreInt32(&stopped, 1)
+						case "FAILED":
+							fmt.Println("Render failed, the master gives the frame to another node")
+							atomic.StoreInt32(&stopped, 1)
 						default:
 							fmt.Println(st)
 						}
@@ -447,25 +489,35 @@ func run(configPath string) {
 						break
 					}
 					time.Sleep(1 * time.Second)
-					state, percent, mem = rT.CheckRender()
+					progress, warning = checkRender(rT, warning)
 
 					if mustStop {
 						break
 					}
 				}
 
-				state, percent, mem = rT.CheckRender()
+				// Kill the render stopped by the master, kill can't return useful errors
+				if atomic.LoadInt32(&stopped) == 1 {
+					pr.Process.Kill()
+					job = new(render.Task)
+					continue
+				}
+
+				progress, warning = checkRender(rT, warning)
 
-				// Upload file if rendered
-				if state == "rendered" {
-					uploadFile(config.Fileserver, rT.Task.ID, rT.Task.Output+fmt.Sprintf("%05d", rT.Task.Frame)+".png")
+				// Upload the frames not uploaded yet, the ones that couldn't be are rendered again by another node
+				state := progress.State
+				done = uploadRendered(fileServer, rT, progress, done)
+				if state == "rendered" && done < rT.Task.Frames() {
+					state = "requeue"
 				}
 
 				// Try to update and abort process if aborted or problem
 				s := new(returnvalue)
-				rv := updateJob(config.API.Endpoint, config.API.Key, state, *nameFlag, rT.Task.Frame, percent, mem, rT.Task.ID, s, client)
+				rv := updateJob(config.API.Endpoint, config.API.Key, state, *nameFlag, rT.Task.Frame, rT.Task.Tile, chunkFraction(rT, progress, done), progress.Mem, done, warning, rT.Task.ID, s, client)
 				if rv != nil || s.State != "OK" {
-					// Kill can't return useful errors
+					// Kill can't return useful errors, the render killed isn't reported as failed
+					atomic.StoreInt32(&stopped, 1)
 					pr.Process.Kill()
 					setAvailable(config.API.Endpoint, config.API.Key, *nameFlag, s, client)
 				}
@@ -478,10 +530,10 @@ func run(configPath string) {
 
 	// When asked to stop
 	s := new(returnvalue)
-	if job.ID != "" && state != "rendered" {
+	if job.ID != "" && progress.State != "rendered" {
 
 		// If a job was running, requeue it
-		err = updateJob(config.API.Endpoint, config.API.Key, "requeue", *nameFlag, rT.Task.Frame, 0.0, 0.0, rT.Task.ID, s, client)
+		err = updateJob(config.API.Endpoint, config.API.Key, "requeue", *nameFlag, rT.Task.Frame, rT.Task.Tile, 0.0, 0.0, done, "", rT.Task.ID, s, client)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -0,0 +1,49 @@
+package filexchange
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+//ChecksumSuffix is appended to the name of a file stored by the file server to get the one holding its SHA-256
+const ChecksumSuffix = ".sha256"
+
+//HashFile returns the SHA-256 of the file in hexadecimal
+func HashFile(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	st, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	h, err := hashPrefix(f, st.Size())
+	if err != nil {
+		return "", err
+	}
+	return hexSum(h), nil
+}
+
+//Checksum returns the SHA-256 of a stored file, read from the file written alongside it or computed if there is none
+func Checksum(filePath string) (string, error) {
+	if _, err := os.Stat(filePath); err != nil {
+		return "", err
+	}
+	if b, err := ioutil.ReadFile(filePath + ChecksumSuffix); err == nil {
+		return strings.TrimSpace(string(b)), nil
+	}
+	return HashFile(filePath)
+}
+
+//WriteChecksum stores sum, the SHA-256 of filePath, alongside it
+func WriteChecksum(filePath, sum string) error {
+	tmp := filePath + ChecksumSuffix + ".part"
+	if err := ioutil.WriteFile(tmp, []byte(sum+"\n"), 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filePath+ChecksumSuffix)
+}
@@ -0,0 +1,217 @@
+package filexchange
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+//Client exchanges the files of the jobs with a file server
+//Credential is the API key or the transfer token given with the job, sent with every instruction.
+//A transfer interrupted by the network is resumed where it stopped, up to Attempts times in total (1 if 0).
+//Progress, if not nil, is called with the number of bytes of the file transferred and its size.
+type Client struct {
+	Address    string
+	TLS        *tls.Config
+	Credential string
+	Attempts   int
+	Progress   func(done, total int64)
+}
+
+//dial opens a TLS connection to the file server
+func (c *Client) dial() (net.Conn, error) {
+	return tls.Dial("tcp", c.Address, c.TLS)
+}
+
+//retry runs the transfer f until it succeeds, the server refuses it or a local file can't be accessed.
+//The transfers of files whose checksum doesn't match are run again from the start.
+func (c *Client) retry(f func() error) error {
+	var err error
+	for i := 0; i == 0 || i < c.Attempts; i++ {
+		if i > 0 {
+			time.Sleep(time.Second)
+		}
+		err = f()
+		var protocolError *ProtocolError
+		var pathError *os.PathError
+		if err == nil || (errors.As(err, &protocolError) && protocolError.Code != ErrChecksum) || errors.As(err, &pathError) {
+			return err
+		}
+	}
+	return err
+}
+
+//progressWriter calls progress after each write to w
+type progressWriter struct {
+	w        io.Writer
+	done     int64
+	total    int64
+	progress func(done, total int64)
+}
+
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.done += int64(n)
+	if p.progress != nil {
+		p.progress(p.done, p.total)
+	}
+	return n, err
+}
+
+//Send uploads the file to the folder of the job id on the file server, resuming the upload of a previous attempt.
+//The server stores the file only if its SHA-256 is the one of the file sent.
+func (c *Client) Send(id, file string) error {
+	return c.retry(func() error { return c.send(id, file) })
+}
+
+func (c *Client) send(id, file string) error {
+	src, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	st, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := writeMessage(conn, MsgSend, c.Credential, id, filepath.Base(file), strconv.FormatInt(st.Size(), 10)); err != nil {
+		return err
+	}
+	fields, err := expect(conn, MsgReady, 2)
+	if err != nil {
+		return err
+	}
+
+	//The upload is resumed if the server has the beginning of the file
+	var start int64
+	h := sha256.New()
+	offset, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || offset < 0 || offset > st.Size() {
+		return fmt.Errorf("invalid offset %s sent by the file server", fields[0])
+	}
+	if offset > 0 {
+		prefix, err := hashPrefix(src, offset)
+		if err != nil {
+			return err
+		}
+		if hexSum(prefix) == fields[1] {
+			start = offset
+			h = prefix
+		}
+	}
+	if err := writeMessage(conn, MsgStart, strconv.FormatInt(start, 10)); err != nil {
+		return err
+	}
+
+	if _, err := src.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	w := &progressWriter{w: io.MultiWriter(conn, h), done: start, total: st.Size(), progress: c.Progress}
+	if _, err := io.CopyN(w, src, st.Size()-start); err != nil {
+		return err
+	}
+	if err := writeMessage(conn, MsgChecksum, hexSum(h)); err != nil {
+		return err
+	}
+	_, err = expect(conn, MsgSuccess, 0)
+	return err
+}
+
+//Receive downloads the file name of the job id from the file server to dstFolder. The file is written in name.part
+//until it is complete, a later call resuming the download from it, and is renamed if its SHA-256 is the one sent by
+//the server.
+func (c *Client) Receive(id, name, dstFolder string) error {
+	return c.retry(func() error { return c.receive(id, name, dstFolder) })
+}
+
+func (c *Client) receive(id, name, dstFolder string) error {
+	destination := path.Join(dstFolder, name)
+	part := destination + ".part"
+
+	//Ask for the rest of the file if a part of it was already received
+	var offset int64
+	h := sha256.New()
+	if f, err := os.Open(part); err == nil {
+		st, err := f.Stat()
+		if err == nil {
+			offset = st.Size()
+			h, err = hashPrefix(f, offset)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	conn, err := c.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := writeMessage(conn, MsgReceive, c.Credential, id, name, strconv.FormatInt(offset, 10), hexSum(h)); err != nil {
+		return err
+	}
+	fields, err := expect(conn, MsgReady, 2)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid size %s sent by the file server", fields[0])
+	}
+	start, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || (start != 0 && start != offset) || start > size {
+		return fmt.Errorf("invalid offset %s sent by the file server", fields[1])
+	}
+	if start == 0 {
+		h = sha256.New()
+	}
+
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := f.Truncate(start); err != nil {
+		return err
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return err
+	}
+	w := &progressWriter{w: io.MultiWriter(f, h), done: start, total: size, progress: c.Progress}
+	if _, err := io.CopyN(w, conn, size-start); err != nil {
+		return err
+	}
+	fields, err = expect(conn, MsgChecksum, 1)
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	//A corrupted file is received again from the start
+	if sum := hexSum(h); sum != fields[0] {
+		os.Remove(part)
+		return fmt.Errorf("checksum %s of the received file %s instead of %s", sum, name, fields[0])
+	}
+	return os.Rename(part, destination)
+}
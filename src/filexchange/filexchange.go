@@ -1,160 +1,277 @@
 package filexchange
 
 import (
-	"io/fs"
+	"crypto/sha256"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"os"
-	"path"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/LeoMarche/blenderer/src/utils"
 )
 
-func handleSender(conn net.Conn, l int, id, fileName, filesFolder string) {
+//sending holds the paths of the files being sent, a file being sent by a single client at a time
+var sending sync.Map
 
-	// Make the folder if not exists
-	err := os.MkdirAll(path.Join(filesFolder, id), 0777) //TODO: change to a better perm value
+//idleTimeout is the time a client has to send or read each part of a message or of a file before its connection is
+//closed, so that stalled clients don't hold their connection forever
+const idleTimeout = time.Minute
 
-	if err != nil {
-		conn.Write([]byte("ABORT"))
+//timeoutConn renews the deadline of each read and write of the connection
+type timeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *timeoutConn) Read(b []byte) (int, error) {
+	if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *timeoutConn) Write(b []byte) (int, error) {
+	if err := c.Conn.SetWriteDeadline(time.Now().Add(c.timeout)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
+
+//handleSender stores the size bytes sent by the client in filePath. They are written in filePath.part, kept if the
+//transfer is interrupted so that it can be resumed, and renamed to filePath once complete if their SHA-256 is the one
+//sent by the client. The SHA-256 is then stored alongside the file.
+func handleSender(conn net.Conn, size int64, filePath string) {
+	if _, busy := sending.LoadOrStore(filePath, true); busy {
+		writeError(conn, ErrBadRequest, "file already being sent")
 		return
 	}
+	defer sending.Delete(filePath)
 
-	// Create the file
-	f, err := os.Create(path.Join(filesFolder, id, fileName))
+	// Make the folder if not exists
+	err := os.MkdirAll(filepath.Dir(filePath), 0777) //TODO: change to a better perm value
 	if err != nil {
-		conn.Write([]byte("ABORT"))
+		writeError(conn, ErrInternal, "can't create the folder of the job")
 		return
 	}
 
+	// Open the part of the file already received
+	part := filePath + ".part"
+	f, err := os.OpenFile(part, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		writeError(conn, ErrInternal, "can't create the file")
+		return
+	}
 	defer f.Close()
 
-	// Receive the file
-	total := 0
-	var rcvbuffer [1024]byte
-	conn.Write([]byte("READY"))
-
-	for total < l {
-		n, err := conn.Read(rcvbuffer[:])
-		if err != nil {
-			conn.Write([]byte("ABORT"))
-			return
-		}
-
-		total += n
-
-		f.Write(rcvbuffer[:n])
+	st, err := f.Stat()
+	if err != nil {
+		writeError(conn, ErrInternal, "can't read the file")
+		return
+	}
+	offset := st.Size()
+	if offset > size {
+		offset = 0
+	}
+	h, err := hashPrefix(f, offset)
+	if err != nil {
+		writeError(conn, ErrInternal, "can't read the file")
+		return
 	}
-	conn.Write([]byte("SUCCESS"))
-}
-
-func handleReceiver(conn net.Conn, id, fileName, filesFolder string) {
-
-	// Checks if file is available and open it
-	filepath := path.Join(filesFolder, id, fileName)
-
-	var st fs.FileInfo
-	var err error
 
-	if st, err = os.Stat(filepath); err != nil {
-		conn.Write([]byte("ABORT 0"))
+	// Receive the file from where the client starts
+	if err := writeMessage(conn, MsgReady, strconv.FormatInt(offset, 10), hexSum(h)); err != nil {
+		return
+	}
+	fields, err := expect(conn, MsgStart, 1)
+	if err != nil {
+		return
+	}
+	start, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil || (start != 0 && start != offset) {
+		writeError(conn, ErrBadRequest, "invalid offset")
+		return
+	}
+	if start == 0 {
+		h = sha256.New()
+	}
+	if err := f.Truncate(start); err != nil {
+		writeError(conn, ErrInternal, "can't write the file")
+		return
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		writeError(conn, ErrInternal, "can't write the file")
 		return
 	}
 
-	f, err := os.Open(filepath)
+	if _, err := io.CopyN(io.MultiWriter(f, h), conn, size-start); err != nil {
+		writeError(conn, ErrBadRequest, "transfer interrupted")
+		return
+	}
+	fields, err = expect(conn, MsgChecksum, 1)
 	if err != nil {
-		conn.Write([]byte("ABORT 0"))
+		return
+	}
+	if err := f.Close(); err != nil {
+		writeError(conn, ErrInternal, "can't write the file")
 		return
 	}
 
-	// Ready to Send
-	conn.Write([]byte("READY " + strconv.FormatInt(st.Size(), 10)))
+	// The file is kept only if it is the one of the client
+	sum := hexSum(h)
+	if sum != fields[0] {
+		os.Remove(part)
+		writeError(conn, ErrChecksum, "checksum mismatch")
+		return
+	}
+	if err := WriteChecksum(filePath, sum); err != nil {
+		writeError(conn, ErrInternal, "can't write the checksum of the file")
+		return
+	}
+	if err := os.Rename(part, filePath); err != nil {
+		writeError(conn, ErrInternal, "can't write the file")
+		return
+	}
+	writeMessage(conn, MsgSuccess)
+}
 
-	var readbuffer [1024]byte
-	n, err := conn.Read(readbuffer[:])
+//handleReceiver sends the size of filePath and its content to the client, from offset if the client has its
+//beginning, hash being the SHA-256 of the part the client has. The SHA-256 of the whole file is sent at the end.
+func handleReceiver(conn net.Conn, filePath string, offset int64, hash string) {
 
-	if err != nil || string(readbuffer[:n]) != "GO" {
-		conn.Write([]byte("ABORT"))
+	// Checks if file is available and open it
+	f, err := os.Open(filePath)
+	if err != nil {
+		writeError(conn, ErrNotFound, "file not found")
 		return
 	}
+	defer f.Close()
 
-	// Send to the end of file
-	var ferr error = nil
-	var sendbuffer [1024]byte
+	st, err := f.Stat()
+	if err != nil || st.IsDir() {
+		writeError(conn, ErrNotFound, "file not found")
+		return
+	}
 
-	for ferr == nil && n > 0 {
-		n, ferr = f.Read(sendbuffer[:])
-		_, err := conn.Write(sendbuffer[:n])
+	var start int64
+	h := sha256.New()
+	if offset > 0 && offset <= st.Size() {
+		prefix, err := hashPrefix(f, offset)
 		if err != nil {
-			conn.Write([]byte("ABORT"))
+			writeError(conn, ErrInternal, "can't read the file")
 			return
 		}
+		if hexSum(prefix) == hash {
+			start = offset
+			h = prefix
+		}
 	}
 
-	// Wait for success
-	n, err = conn.Read(readbuffer[:])
-	if err != nil || string(readbuffer[:n]) != "SUCCESS" {
-		conn.Write([]byte("ABORT"))
+	// Send the file after its size
+	if err := writeMessage(conn, MsgReady, strconv.FormatInt(st.Size(), 10), strconv.FormatInt(start, 10)); err != nil {
+		return
+	}
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return
+	}
+	if _, err := io.CopyN(io.MultiWriter(conn, h), f, st.Size()-start); err != nil {
 		return
 	}
+	writeMessage(conn, MsgChecksum, hexSum(h))
 }
 
-func handleClient(conn net.Conn, filesFolder string) {
-	var buf [1024]byte
-
-	n, err := conn.Read(buf[:])
+//Authorizer returns true if credential, an API key or a transfer token, allows to exchange the files of the job id
+type Authorizer func(credential, id string) bool
 
+//handleClient runs the Send or Receive request of a client, see MessageType.
+//The file must be in the folder of the job id in filesFolder.
+func handleClient(conn net.Conn, filesFolder string, authorized Authorizer) {
+	t, fields, err := readMessage(conn)
+	if err == errBadVersion {
+		writeError(conn, ErrVersion, fmt.Sprintf("version %d of the protocol is required", Version))
+		return
+	}
 	if err != nil {
-		conn.Write([]byte("ABORT"))
+		writeError(conn, ErrBadRequest, err.Error())
 		return
 	}
-	instr := strings.Split(string(buf[0:n]), " ")
 
-	switch instr[0] {
-	case "SEND":
-		if len(instr) != 4 {
-			conn.Write([]byte("ABORT"))
+	switch {
+	case t == MsgSend && len(fields) == 4:
+		size, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil || size < 0 {
+			writeError(conn, ErrBadRequest, "invalid size")
 			return
 		}
-		l, err := strconv.Atoi(instr[1])
-		if err != nil {
-			conn.Write([]byte("ABORT"))
-			return
+		if filePath, ok := resolve(conn, filesFolder, authorized, fields[0], fields[1], fields[2]); ok {
+			handleSender(conn, size, filePath)
 		}
-		handleSender(conn, l, instr[2], instr[3], filesFolder)
-	case "RECEIVE":
-		if len(instr) != 3 {
-			conn.Write([]byte("ABORT"))
+	case t == MsgReceive && len(fields) == 5:
+		offset, err := strconv.ParseInt(fields[3], 10, 64)
+		if err != nil || offset < 0 {
+			writeError(conn, ErrBadRequest, "invalid offset")
 			return
 		}
-		handleReceiver(conn, instr[1], instr[2], filesFolder)
+		if filePath, ok := resolve(conn, filesFolder, authorized, fields[0], fields[1], fields[2]); ok {
+			handleReceiver(conn, filePath, offset, fields[4])
+		}
+	default:
+		writeError(conn, ErrBadRequest, "unknown request")
 	}
 }
 
-func StartListening(filesFolder string, mustStop *bool) {
-	service := ":9005"
-	tcpAddr, err := net.ResolveTCPAddr("tcp4", service)
+//resolve returns the path of the file name of the job id if credential allows to access it,
+//or sends the error to the client
+func resolve(conn net.Conn, filesFolder string, authorized Authorizer, credential, id, name string) (string, bool) {
+	if !authorized(credential, id) {
+		writeError(conn, ErrUnauthorized, "invalid credential")
+		return "", false
+	}
+	filePath, err := utils.SafeJoin(filesFolder, id, name)
 	if err != nil {
-		log.Fatal(err)
+		writeError(conn, ErrBadRequest, err.Error())
+		return "", false
 	}
+	//The files being sent and the checksums can't be accessed
+	if strings.HasSuffix(name, ".part") || strings.HasSuffix(name, ChecksumSuffix) {
+		writeError(conn, ErrBadRequest, "the names of the files can't end with .part or "+ChecksumSuffix)
+		return "", false
+	}
+	return filePath, true
+}
 
-	listener, err := net.ListenTCP("tcp", tcpAddr)
+//StartListening serves the files of the jobs in filesFolder over TLS on port 9005 until mustStop is set.
+//Every instruction must carry a credential accepted by authorized. The connections of the clients idle for more than
+//idleTimeout are closed.
+func StartListening(filesFolder string, config *tls.Config, authorized Authorizer, mustStop *bool) {
+	listener, err := tls.Listen("tcp", ":9005", config)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	tcpConnsTab := new([]*net.Conn)
+	//Open connections, closed when stopping
+	tcpConns := new(sync.Map)
 
 	go func() {
 
 		for {
 			conn, err := listener.Accept()
-			*tcpConnsTab = append(*tcpConnsTab, &conn)
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 			if err == nil {
+				tcpConns.Store(conn, true)
 				go func() {
-					handleClient(conn, filesFolder)
+					handleClient(&timeoutConn{Conn: conn, timeout: idleTimeout}, filesFolder, authorized)
 					conn.Close()
+					tcpConns.Delete(conn)
 				}()
 			}
 		}
@@ -167,9 +284,8 @@ func StartListening(filesFolder string, mustStop *bool) {
 
 	listener.Close()
 
-	for _, c := range *tcpConnsTab {
-		(*c).Close()
-	}
-
-	listener.Close()
+	tcpConns.Range(func(key, value interface{}) bool {
+		key.(net.Conn).Close()
+		return true
+	})
 }
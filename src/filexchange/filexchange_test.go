@@ -2,198 +2,442 @@ package filexchange
 
 import (
 	"bytes"
-	"fmt"
-	"io"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
 	"io/ioutil"
+	"math/big"
 	"net"
 	"os"
 	"path"
 	"strconv"
-	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
-func copy(src, dst string) (int64, error) {
-	sourceFileStat, err := os.Stat(src)
+var (
+	serverOnce   sync.Once
+	serverFolder string
+	clientTLS    *tls.Config
+)
+
+//selfSigned returns a certificate for localhost and the pool trusting it
+func selfSigned() (tls.Certificate, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
 	if err != nil {
-		return 0, err
+		return tls.Certificate{}, nil, err
 	}
 
-	if !sourceFileStat.Mode().IsRegular() {
-		return 0, fmt.Errorf("%s is not a regular file", src)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
 	}
-
-	source, err := os.Open(src)
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
 	if err != nil {
-		return 0, err
+		return tls.Certificate{}, nil, err
 	}
-	defer source.Close()
-
-	destination, err := os.Create(dst)
+	cert, err := x509.ParseCertificate(der)
 	if err != nil {
-		return 0, err
-	}
-	defer destination.Close()
-	buf := make([]byte, 1024)
-	for {
-		n, err := source.Read(buf)
-		if err != nil && err != io.EOF {
-			return 0, err
+		return tls.Certificate{}, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool, nil
+}
+
+//startServer starts the file server shared by the tests, serving a temporary folder.
+//The API key test_api is accepted for every job and the token dummy_token only for dummy_id.
+func startServer(t *testing.T) {
+	serverOnce.Do(func() {
+		cert, pool, err := selfSigned()
+		if err != nil {
+			t.Fatal(err)
 		}
-		if n == 0 {
-			break
+		clientTLS = &tls.Config{RootCAs: pool}
+
+		serverFolder, err = ioutil.TempDir("", "filexchange")
+		if err != nil {
+			t.Fatal(err)
 		}
 
-		if _, err := destination.Write(buf[:n]); err != nil {
-			return 0, err
+		authorized := func(credential, id string) bool {
+			return credential == "test_api" || (credential == "dummy_token" && id == "dummy_id")
 		}
-	}
-	return 0, err
+		go StartListening(serverFolder, &tls.Config{Certificates: []tls.Certificate{cert}}, authorized, new(bool))
+
+		for i := 0; i < 50; i++ {
+			if c, err := tls.Dial("tcp", "localhost:9005", clientTLS); err == nil {
+				c.Close()
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		t.Fatal("file server not started")
+	})
 }
 
 func TestReceive(t *testing.T) {
 	assert := assert.New(t)
+	startServer(t)
 
-	returnStatus := []string{"READY", "ABORT", "ABORT", "READY"}
-	destinationFiles := []string{path.Join("tmp", "dummy_received.txt"), path.Join("tmp", "dummy_received_2.txt"), path.Join("tmp", "dummy_received_3.txt"), path.Join("tmp", "dummy_received_4.txt")}
-	IDs := []string{"dummy_id", "dummy_id_2", "dummy_id", "dummy_id"}
-	srcFiles := []string{"dummy.txt", "dummy.txt", "dummy_2.txt", "dummy3.txt"}
+	content := []byte("dummy content of a file to receive\n")
+	os.MkdirAll(path.Join(serverFolder, "dummy_id"), os.FileMode(0777))
+	assert.NoError(ioutil.WriteFile(path.Join(serverFolder, "dummy_id", "dummy.txt"), content, 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(serverFolder, "dummy_id", "dummy file.txt"), content, 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(serverFolder, "dummy_id", "empty.txt"), nil, 0666))
 
-	os.MkdirAll(path.Join("tmp", "dummy_id"), os.FileMode(0777))
-	defer os.RemoveAll("tmp")
-	_, err := copy(path.Join("..", "..", "testdata", "filexchange_tests", "dummy.txt"), path.Join("tmp", "dummy_id", "dummy.txt"))
-	assert.NoError(err)
-	_, err = copy(path.Join("..", "..", "testdata", "filexchange_tests", "dummy3.txt"), path.Join("tmp", "dummy_id", "dummy3.txt"))
+	dst, err := ioutil.TempDir("", "filexchange")
 	assert.NoError(err)
+	defer os.RemoveAll(dst)
 
-	for i := 0; i < len(returnStatus); i++ {
-		b := new(bool)
-		*b = false
+	credentials := []string{"test_api", "dummy_token", "test_api", "test_api", "dummy_token", "wrong_key", "", "test_api", "test_api", "test_api",
+		"test_api"}
+	IDs := []string{"dummy_id", "dummy_id", "dummy_id_2", "dummy_id", "other_id", "dummy_id", "dummy_id", "..", "other_id", "dummy_id", "dummy_id"}
+	srcFiles := []string{"dummy.txt", "dummy.txt", "dummy.txt", "dummy_2.txt", "dummy.txt", "dummy.txt", "dummy.txt", "dummy_id/dummy.txt",
+		"../dummy_id/dummy.txt", "dummy file.txt", "empty.txt"}
+	expectedCode := []ErrorCode{0, 0, ErrNotFound, ErrNotFound, ErrUnauthorized, ErrUnauthorized, ErrUnauthorized, ErrBadRequest, ErrBadRequest, 0, 0}
+	expectedContent := [][]byte{content, content, nil, nil, nil, nil, nil, nil, nil, content, {}}
 
-		go StartListening("tmp", b)
+	for i := 0; i < len(credentials); i++ {
+		os.Remove(path.Join(dst, srcFiles[i]))
 
-		c, err := net.Dial("tcp", "localhost:9005")
-		assert.NoError(err)
+		c := &Client{Address: "localhost:9005", TLS: clientTLS, Credential: credentials[i]}
+		err := c.Receive(IDs[i], srcFiles[i], dst)
+		assert.Equal(expectedCode[i], errorCode(err), "Bad result in test %d : %v", i, err)
 
-		toSend := []byte("RECEIVE " + IDs[i] + " " + srcFiles[i])
-		_, err = c.Write(toSend)
-		assert.NoError(err)
+		if expectedCode[i] == 0 {
+			received, err := ioutil.ReadFile(path.Join(dst, srcFiles[i]))
+			assert.NoError(err)
+			assert.Equal(true, bytes.Equal(expectedContent[i], received), "Bad file received in test %d", i)
+		} else {
+			_, err := os.Stat(path.Join(dst, srcFiles[i]))
+			assert.Equal(true, os.IsNotExist(err), "File created by a refused transfer in test %d", i)
+		}
+	}
+}
 
-		dst := destinationFiles[i]
-		destination, err := os.Create(dst)
-		assert.NoError(err)
+func TestSend(t *testing.T) {
+	assert := assert.New(t)
+	startServer(t)
 
-		buf := make([]byte, 1024)
-		n, err := c.Read(buf)
-		assert.NoError(err)
-		instr := strings.Split(string(buf[:n]), " ")
-		assert.Equal(2, len(instr))
+	src, err := ioutil.TempDir("", "filexchange")
+	assert.NoError(err)
+	defer os.RemoveAll(src)
+
+	//Larger than the buffers of the protocol
+	content := bytes.Repeat([]byte("dummy content of a file to send\n"), 1000)
+	assert.NoError(ioutil.WriteFile(path.Join(src, "sent.txt"), content, 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(src, "sent file.txt"), content, 0666))
+
+	credentials := []string{"test_api", "dummy_token", "dummy_token", "wrong_key", "test_api", "test_api", "test_api"}
+	IDs := []string{"sent_id", "dummy_id", "other_id", "wrong_id", "..", "../escaped_id", "sent_id"}
+	srcFiles := []string{"sent.txt", "sent.txt", "sent.txt", "sent.txt", "sent.txt", "sent.txt", "sent file.txt"}
+	expectedCode := []ErrorCode{0, 0, ErrUnauthorized, ErrUnauthorized, ErrBadRequest, ErrBadRequest, 0}
+
+	for i := 0; i < len(credentials); i++ {
+		c := &Client{Address: "localhost:9005", TLS: clientTLS, Credential: credentials[i]}
+		err := c.Send(IDs[i], path.Join(src, srcFiles[i]))
+		assert.Equal(expectedCode[i], errorCode(err), "Bad result in test %d : %v", i, err)
+
+		sent, err := ioutil.ReadFile(path.Join(serverFolder, IDs[i], srcFiles[i]))
+		assert.Equal(expectedCode[i] == 0, err == nil, "Bad file stored in test %d", i)
+		if expectedCode[i] == 0 {
+			assert.Equal(true, bytes.Equal(content, sent), "Bad file sent in test %d", i)
+			sum, err := ioutil.ReadFile(path.Join(serverFolder, IDs[i], srcFiles[i]+ChecksumSuffix))
+			assert.NoError(err)
+			assert.Equal(hexSHA256(content)+"\n", string(sum), "Bad checksum stored in test %d", i)
+		}
+	}
+}
 
-		status := instr[0]
-		ln, err := strconv.ParseInt(instr[1], 10, 64)
-		assert.NoError(err)
+//hexSHA256 returns the SHA-256 of b in hexadecimal
+func hexSHA256(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
 
-		buf = make([]byte, 1024)
-		var nRead int64
-		nRead = 0
+func TestChecksum(t *testing.T) {
+	assert := assert.New(t)
+	startServer(t)
+
+	content := []byte("dummy content of a file to check\n")
+	folder := path.Join(serverFolder, "checksum_id")
+	os.MkdirAll(folder, os.FileMode(0777))
+	assert.NoError(ioutil.WriteFile(path.Join(folder, "computed.txt"), content, 0666))
+	assert.NoError(ioutil.WriteFile(path.Join(folder, "stored.txt"), content, 0666))
+	assert.NoError(WriteChecksum(path.Join(folder, "stored.txt"), "stored_sum"))
+
+	//The stored checksum is preferred to the computed one
+	files := []string{"computed.txt", "stored.txt", "missing.txt"}
+	expected := []string{hexSHA256(content), "stored_sum", ""}
+	for i := 0; i < len(files); i++ {
+		sum, err := Checksum(path.Join(folder, files[i]))
+		assert.Equal(expected[i], sum, "Bad checksum in test %d", i)
+		assert.Equal(expected[i] == "", err != nil, "Bad error in test %d : %v", i, err)
+	}
 
-		assert.Equal(returnStatus[i], status)
+	//Files whose checksum doesn't match the one of the client aren't stored
+	conn, err := tls.Dial("tcp", "localhost:9005", clientTLS)
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
+	assert.NoError(writeMessage(conn, MsgSend, "test_api", "checksum_id", "corrupted.txt", strconv.Itoa(len(content))))
+	_, err = expect(conn, MsgReady, 2)
+	assert.NoError(err)
+	assert.NoError(writeMessage(conn, MsgStart, "0"))
+	_, err = conn.Write(content)
+	assert.NoError(err)
+	assert.NoError(writeMessage(conn, MsgChecksum, hexSHA256([]byte("other content"))))
+	_, err = expect(conn, MsgSuccess, 0)
+	assert.Equal(ErrChecksum, errorCode(err), "Bad error : %v", err)
 
-		if status == "READY" {
-			_, err := c.Write([]byte("GO"))
-			assert.NoError(err)
+	for _, f := range []string{"corrupted.txt", "corrupted.txt.part", "corrupted.txt" + ChecksumSuffix} {
+		_, err = os.Stat(path.Join(folder, f))
+		assert.Equal(true, os.IsNotExist(err), "File %s kept", f)
+	}
 
-			for nRead != ln {
-				n, err := c.Read(buf)
-				assert.NoError(err)
-				nRead += int64(n)
-				destination.Write(buf[:n])
-			}
+	//Checksums can't be exchanged
+	c := &Client{Address: "localhost:9005", TLS: clientTLS, Credential: "test_api"}
+	assert.Equal(ErrBadRequest, errorCode(c.Receive("checksum_id", "stored.txt"+ChecksumSuffix, t.TempDir())))
+}
 
-			assert.Equal(ln, nRead)
-			_, err = c.Write([]byte("SUCCESS"))
-			assert.NoError(err)
+func TestResume(t *testing.T) {
+	assert := assert.New(t)
+	startServer(t)
 
-			f1, err1 := ioutil.ReadFile(path.Join("tmp", IDs[i], srcFiles[i]))
-			assert.NoError(err1)
-			f2, err2 := ioutil.ReadFile(destinationFiles[i])
-			assert.NoError(err2)
+	src, err := ioutil.TempDir("", "filexchange")
+	assert.NoError(err)
+	defer os.RemoveAll(src)
+
+	content := bytes.Repeat([]byte("dummy content of a file to resume\n"), 10000)
+	half := len(content) / 2
+	corrupted := append([]byte("corrupted"), content[9:half]...)
+	assert.NoError(ioutil.WriteFile(path.Join(src, "resumed.txt"), content, 0666))
+	os.MkdirAll(path.Join(serverFolder, "resume_id"), os.FileMode(0777))
+
+	//The part of the file already transferred is kept only if it is the beginning of the file
+	parts := [][]byte{content[:half], corrupted, nil}
+	expectedStart := []int64{int64(half), 0, 0}
+
+	for i := 0; i < len(parts); i++ {
+		firstDone, lastDone := int64(-1), int64(-1)
+		progress := func(done, total int64) {
+			if firstDone < 0 {
+				firstDone = done
+			}
+			lastDone = done
+			assert.Equal(int64(len(content)), total, "Bad total in test %d", i)
+		}
+		c := &Client{Address: "localhost:9005", TLS: clientTLS, Credential: "test_api", Progress: progress}
 
-			assert.Equal(true, bytes.Equal(f1, f2))
+		//Upload
+		stored := path.Join(serverFolder, "resume_id", "resumed.txt")
+		os.Remove(stored)
+		if parts[i] != nil {
+			assert.NoError(ioutil.WriteFile(stored+".part", parts[i], 0666))
 		}
-		*b = true
+		assert.NoError(c.Send("resume_id", path.Join(src, "resumed.txt")), "Upload failed in test %d", i)
+		sent, err := ioutil.ReadFile(stored)
+		assert.NoError(err)
+		assert.Equal(true, bytes.Equal(content, sent), "Bad file sent in test %d", i)
+		_, err = os.Stat(stored + ".part")
+		assert.Equal(true, os.IsNotExist(err), "Part kept after the upload in test %d", i)
+		assert.Equal(true, firstDone > expectedStart[i] && firstDone <= expectedStart[i]+32*1024, "Bad start of upload in test %d", i)
+		assert.Equal(int64(len(content)), lastDone, "Bad end of upload in test %d", i)
+
+		//Download
+		firstDone, lastDone = -1, -1
+		received := path.Join(src, "resumed.txt")
+		os.Remove(received)
+		if parts[i] != nil {
+			assert.NoError(ioutil.WriteFile(received+".part", parts[i], 0666))
+		}
+		assert.NoError(c.Receive("resume_id", "resumed.txt", src), "Download failed in test %d", i)
+		got, err := ioutil.ReadFile(received)
+		assert.NoError(err)
+		assert.Equal(true, bytes.Equal(content, got), "Bad file received in test %d", i)
+		_, err = os.Stat(received + ".part")
+		assert.Equal(true, os.IsNotExist(err), "Part kept after the download in test %d", i)
+		assert.Equal(true, firstDone > expectedStart[i] && firstDone <= expectedStart[i]+32*1024, "Bad start of download in test %d", i)
+		assert.Equal(int64(len(content)), lastDone, "Bad end of download in test %d", i)
 	}
-}
 
-func TestSend(t *testing.T) {
-	assert := assert.New(t)
+	//An upload interrupted by the network is resumed
+	conn, err := tls.Dial("tcp", "localhost:9005", clientTLS)
+	if assert.NoError(err) {
+		assert.NoError(writeMessage(conn, MsgSend, "test_api", "resume_id", "interrupted.txt", strconv.Itoa(len(content))))
+		_, err = expect(conn, MsgReady, 2)
+		assert.NoError(err)
+		assert.NoError(writeMessage(conn, MsgStart, "0"))
+		_, err = conn.Write(content[:half])
+		assert.NoError(err)
+		conn.Close()
+	}
+	stored := path.Join(serverFolder, "resume_id", "interrupted.txt")
+	for i := 0; i < 50; i++ {
+		if _, busy := sending.Load(stored); !busy {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	st, err := os.Stat(stored + ".part")
+	if assert.NoError(err) {
+		assert.Equal(int64(half), st.Size(), "Bad part kept after the interruption")
+	}
+	assert.NoError(ioutil.WriteFile(path.Join(src, "interrupted.txt"), content, 0666))
+	firstDone := int64(-1)
+	c := &Client{Address: "localhost:9005", TLS: clientTLS, Credential: "test_api", Progress: func(done, total int64) {
+		if firstDone < 0 {
+			firstDone = done
+		}
+	}}
+	assert.NoError(c.Send("resume_id", path.Join(src, "interrupted.txt")))
+	sent, err := ioutil.ReadFile(stored)
+	assert.NoError(err)
+	assert.Equal(true, bytes.Equal(content, sent), "Bad file sent after the interruption")
+	assert.Equal(true, firstDone > int64(half), "Interrupted upload not resumed")
+
+	//Files being sent can't be accessed
+	assert.NoError(ioutil.WriteFile(path.Join(serverFolder, "resume_id", "partial.txt.part"), content[:half], 0666))
+	c = &Client{Address: "localhost:9005", TLS: clientTLS, Credential: "test_api"}
+	assert.Equal(ErrBadRequest, errorCode(c.Receive("resume_id", "partial.txt.part", src)))
+	assert.Equal(ErrNotFound, errorCode(c.Receive("resume_id", "partial.txt", src)))
+}
 
-	returnStatus := []string{"READY"}
-	destinationFiles := []string{path.Join("tmp", "dummy_id", "dummy.txt")}
-	IDs := []string{"dummy_id"}
-	srcFiles := []string{"dummy.txt"}
+//errorCode returns the code of the error sent by the file server, 0 if there is none and -1 for other errors
+func errorCode(err error) ErrorCode {
+	if err == nil {
+		return 0
+	}
+	if pe, ok := err.(*ProtocolError); ok {
+		return pe.Code
+	}
+	return -1
+}
 
-	os.MkdirAll("tmp", os.FileMode(0777))
-	defer os.RemoveAll("tmp")
+func TestMessages(t *testing.T) {
+	assert := assert.New(t)
 
-	_, err := copy(path.Join("..", "..", "testdata", "filexchange_tests", "dummy.txt"), path.Join("tmp", "dummy.txt"))
-	assert.NoError(err)
+	//Several messages coalesced in a single buffer are read one by one
+	buf := new(bytes.Buffer)
+	assert.NoError(writeMessage(buf, MsgSend, "test_api", "dummy_id", "file with spaces.blend", "12"))
+	assert.NoError(writeMessage(buf, MsgReady))
+	assert.NoError(writeMessage(buf, MsgReceive, "", "dummy_id", "dummy.txt"))
+	buf.WriteString("raw content")
+
+	types := []MessageType{MsgSend, MsgReady, MsgReceive}
+	fields := [][]string{{"test_api", "dummy_id", "file with spaces.blend", "12"}, {}, {"", "dummy_id", "dummy.txt"}}
+	for i := 0; i < len(types); i++ {
+		tp, f, err := readMessage(buf)
+		assert.NoError(err, "Bad message %d", i)
+		assert.Equal(types[i], tp, "Bad type of message %d", i)
+		assert.Equal(fields[i], f, "Bad fields of message %d", i)
+	}
+	assert.Equal("raw content", buf.String())
 
-	for i := 0; i < len(returnStatus); i++ {
-		b := new(bool)
-		*b = false
+	//Errors of the server are returned as ProtocolError
+	buf.Reset()
+	assert.NoError(writeError(buf, ErrNotFound, "file not found"))
+	_, err := expect(buf, MsgReady, 1)
+	assert.Equal(&ProtocolError{Code: ErrNotFound, Message: "file not found"}, err)
 
-		go StartListening("tmp", b)
+	//Unexpected messages, other versions, truncated or too long messages are refused
+	assert.NoError(writeMessage(buf, MsgSuccess))
+	_, err = expect(buf, MsgReady, 0)
+	assert.Error(err)
 
-		c, err := net.Dial("tcp", "localhost:9005")
-		assert.NoError(err)
+	_, _, err = readMessage(bytes.NewReader([]byte{Version + 1, byte(MsgReady), 0, 0, 0, 0}))
+	assert.Equal(errBadVersion, err)
 
-		st, err := os.Stat(path.Join("tmp", srcFiles[i]))
-		assert.NoError(err)
+	_, _, err = readMessage(bytes.NewReader([]byte{Version, byte(MsgReady), 0, 0, 0, 6, 0, 0, 0, 5, 'a', 'b'}))
+	assert.Error(err)
 
-		toSend := []byte("SEND " + strconv.FormatInt(st.Size(), 10) + " " + IDs[i] + " " + srcFiles[i])
-		_, err = c.Write(toSend)
-		assert.NoError(err)
+	_, _, err = readMessage(bytes.NewReader([]byte{Version, byte(MsgReady), 0xff, 0xff, 0xff, 0xff}))
+	assert.Error(err)
 
-		src, err := os.Open(path.Join("tmp", srcFiles[i]))
-		assert.NoError(err)
+	assert.Error(writeMessage(buf, MsgSend, string(make([]byte, maxMessage))))
+}
 
-		buf := make([]byte, 1024)
-		n, err := c.Read(buf)
-		assert.NoError(err)
-		instr := string(buf[:n])
+func TestOldProtocol(t *testing.T) {
+	assert := assert.New(t)
+	startServer(t)
 
-		assert.Equal(returnStatus[i], instr)
+	//Clients of the previous protocol are told the version required
+	conn, err := tls.Dial("tcp", "localhost:9005", clientTLS)
+	if !assert.NoError(err) {
+		return
+	}
+	defer conn.Close()
 
-		buf = make([]byte, 1024)
-		var nRead int64
-		nRead = 0
+	_, err = conn.Write([]byte("RECEIVE test_api dummy_id dummy.txt"))
+	assert.NoError(err)
+	_, err = expect(conn, MsgReady, 1)
+	assert.Equal(ErrVersion, errorCode(err), "Bad error : %v", err)
+}
 
-		if instr == "READY" {
+func TestPlainConnection(t *testing.T) {
+	assert := assert.New(t)
+	startServer(t)
 
-			for nRead != st.Size() {
-				n, err := src.Read(buf)
-				assert.NoError(err)
-				nRead += int64(n)
-				c.Write(buf[:n])
-			}
+	//Clients not speaking TLS get nothing
+	c, err := net.Dial("tcp", "localhost:9005")
+	if !assert.NoError(err) {
+		return
+	}
+	defer c.Close()
 
-			buf := make([]byte, 1024)
-			n, err := c.Read(buf)
-			assert.NoError(err)
-			fin := string(buf[:n])
+	assert.NoError(writeMessage(c, MsgReceive, "test_api", "dummy_id", "dummy.txt"))
+	c.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = expect(c, MsgReady, 1)
+	assert.Error(err, "File served without TLS")
+}
 
-			assert.Equal("SUCCESS", fin)
+func TestStalledClient(t *testing.T) {
+	assert := assert.New(t)
 
-			f1, err1 := ioutil.ReadFile(path.Join("tmp", srcFiles[i]))
-			assert.NoError(err1)
-			f2, err2 := ioutil.ReadFile(destinationFiles[i])
-			assert.NoError(err2)
+	folder, err := ioutil.TempDir("", "filexchange")
+	assert.NoError(err)
+	defer os.RemoveAll(folder)
+	authorized := func(credential, id string) bool { return credential == "test_api" }
+
+	//Clients sending nothing, stopping in the middle of a message or not reading the answer are disconnected
+	stalls := []func(net.Conn){
+		func(c net.Conn) {},
+		func(c net.Conn) { c.Write([]byte{Version, byte(MsgSend), 0, 0}) },
+		func(c net.Conn) { writeMessage(c, MsgSend, "test_api", "dummy_id", "dummy.txt", "10") },
+	}
 
-			assert.Equal(true, bytes.Equal(f1, f2))
+	for i, stall := range stalls {
+		server, client := net.Pipe()
+		done := make(chan bool)
+		go func() {
+			handleClient(&timeoutConn{Conn: server, timeout: 100 * time.Millisecond}, folder, authorized)
+			done <- true
+		}()
+		go stall(client)
+
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			assert.Fail("Stalled client not disconnected", "test %d", i)
 		}
-		*b = true
+		client.Close()
+		server.Close()
 	}
 }
@@ -0,0 +1,165 @@
+package filexchange
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+)
+
+//Version of the protocol, sent in every message
+const Version byte = 3
+
+//maxMessage is the maximal length of the fields of a message, the content of the files being sent after the messages
+const maxMessage = 64 * 1024
+
+//MessageType tells what a message asks or answers
+type MessageType byte
+
+//Messages of the protocol. A transfer starts by Send (credential, id, name, size) or Receive (credential, id, name,
+//offset, hash) from the client, or is refused by an Error (code, message) of the server.
+//A Send is answered by Ready (offset, hash), offset being the length of the part of the file the server already has
+//and hash its SHA-256. The client answers Start (offset) if the part is the beginning of its file, or Start (0), then
+//sends the content of the file from there followed by Checksum (hash), the SHA-256 of the whole file. The server
+//answers Success or Error once the file is stored.
+//A Receive gives the length and the SHA-256 of the part of the file the client already has, the server answers
+//Ready (size, offset) and sends the content of the file from offset, which is 0 if the part isn't the beginning of it,
+//followed by Checksum (hash).
+const (
+	MsgSend MessageType = iota + 1
+	MsgReceive
+	MsgReady
+	MsgSuccess
+	MsgError
+	MsgStart
+	MsgChecksum
+)
+
+//ErrorCode tells why the server refused a request
+type ErrorCode int
+
+//Codes of the Error messages
+const (
+	ErrBadRequest ErrorCode = iota + 1
+	ErrVersion
+	ErrUnauthorized
+	ErrNotFound
+	ErrInternal
+	ErrChecksum
+)
+
+//ProtocolError is the error sent by the server in an Error message
+type ProtocolError struct {
+	Code    ErrorCode
+	Message string
+}
+
+func (e *ProtocolError) Error() string {
+	return fmt.Sprintf("file server error %d : %s", e.Code, e.Message)
+}
+
+//errBadVersion is returned by readMessage for messages of another version of the protocol
+var errBadVersion = errors.New("unsupported version of the protocol")
+
+//writeMessage writes a message of type t made of fields. A message starts by the version, the type and the length of
+//its fields, each of them being prefixed by its length, all lengths being big endian uint32.
+func writeMessage(w io.Writer, t MessageType, fields ...string) error {
+	ln := 0
+	for _, f := range fields {
+		ln += 4 + len(f)
+	}
+	if ln > maxMessage {
+		return fmt.Errorf("message of %d bytes is too long", ln)
+	}
+
+	buf := make([]byte, 6+ln)
+	buf[0] = Version
+	buf[1] = byte(t)
+	binary.BigEndian.PutUint32(buf[2:], uint32(ln))
+	i := 6
+	for _, f := range fields {
+		binary.BigEndian.PutUint32(buf[i:], uint32(len(f)))
+		i += 4 + copy(buf[i+4:], f)
+	}
+
+	_, err := w.Write(buf)
+	return err
+}
+
+//readMessage reads a message written by writeMessage, its type being returned even for another version
+func readMessage(r io.Reader) (MessageType, []string, error) {
+	var header [6]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, nil, err
+	}
+	t := MessageType(header[1])
+	if header[0] != Version {
+		return t, nil, errBadVersion
+	}
+	ln := binary.BigEndian.Uint32(header[2:])
+	if ln > maxMessage {
+		return t, nil, fmt.Errorf("message of %d bytes is too long", ln)
+	}
+
+	buf := make([]byte, ln)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return t, nil, err
+	}
+
+	fields := []string{}
+	for len(buf) > 0 {
+		if len(buf) < 4 {
+			return t, nil, errors.New("truncated field")
+		}
+		fl := binary.BigEndian.Uint32(buf)
+		if uint32(len(buf)-4) < fl {
+			return t, nil, errors.New("truncated field")
+		}
+		fields = append(fields, string(buf[4:4+fl]))
+		buf = buf[4+fl:]
+	}
+	return t, fields, nil
+}
+
+//hashPrefix returns the SHA-256 of the n first bytes of f, to which the rest of the file can be written
+func hashPrefix(f io.ReaderAt, n int64) (hash.Hash, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, n)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+//hexSum returns the sum of h in hexadecimal
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+//writeError sends an Error message with code and message
+func writeError(w io.Writer, code ErrorCode, message string) error {
+	return writeMessage(w, MsgError, strconv.Itoa(int(code)), message)
+}
+
+//expect reads a message and returns its fields if it is of type t and has n fields.
+//Error messages are returned as a *ProtocolError.
+func expect(r io.Reader, t MessageType, n int) ([]string, error) {
+	got, fields, err := readMessage(r)
+	if err != nil {
+		return nil, err
+	}
+	if got == MsgError && len(fields) == 2 {
+		code, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid error code %s", fields[0])
+		}
+		return nil, &ProtocolError{Code: ErrorCode(code), Message: fields[1]}
+	}
+	if got != t || len(fields) != n {
+		return nil, fmt.Errorf("unexpected message %d with %d fields instead of %d with %d", got, len(fields), t, n)
+	}
+	return fields, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto/tls"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 
 	"github.com/LeoMarche/blenderer/src/filexchange"
 	"github.com/LeoMarche/blenderer/src/node"
+	"github.com/LeoMarche/blenderer/src/render"
 	"github.com/LeoMarche/blenderer/src/rendererapi"
 	"github.com/LeoMarche/blenderer/src/rendererdb"
 
@@ -34,7 +36,7 @@ func loadConfig(configPath string) (rendererapi.Configuration, error) {
 }
 
 //This describes the start sequence of the program
-func startSequence(configPath string, nodesT *sync.Map, tasksT *sync.Map) (*sql.DB, rendererapi.Configuration) {
+func startSequence(configPath string, nodesT *sync.Map, tasksT *sync.Map, pausedT *sync.Map) (*sql.DB, rendererapi.Configuration) {
 	c, err := loadConfig(configPath)
 	fmt.Println("	-> Config loaded")
 
@@ -42,6 +44,10 @@ func startSequence(configPath string, nodesT *sync.Map, tasksT *sync.Map) (*sql.
 		log.Fatal(err.Error())
 	}
 
+	if err := c.Validate(); err != nil {
+		log.Fatal(err.Error())
+	}
+
 	dB, err := rendererdb.LoadDatabase(c.DBName)
 
 	if err != nil {
@@ -56,7 +62,7 @@ func startSequence(configPath string, nodesT *sync.Map, tasksT *sync.Map) (*sql.
 		log.Fatal(err.Error())
 	}
 
-	err = rendererdb.LoadTasksFromDB(dB, tasksT)
+	err = rendererdb.LoadTasksFromDB(dB, tasksT, pausedT)
 
 	if err != nil {
 		log.Fatal(err.Error())
@@ -86,6 +92,13 @@ func handleRequests(ws *rendererapi.WorkingSet) {
 	myRouter.HandleFunc("/setDown", ws.SetDown)
 	myRouter.HandleFunc("/postNode", ws.PostNode)
 	myRouter.HandleFunc("/errorNode", ws.ErrorNode)
+	myRouter.HandleFunc("/setPriority", ws.SetPriority)
+	myRouter.HandleFunc("/getFrames", ws.GetFrames)
+	myRouter.HandleFunc("/retryJob", ws.RetryJob)
+	myRouter.HandleFunc("/rerenderFrames", ws.RerenderFrames)
+	myRouter.HandleFunc("/pauseJob", ws.PauseJob)
+	myRouter.HandleFunc("/resumeJob", ws.ResumeJob)
+	myRouter.HandleFunc("/deleteJob", ws.DeleteJob)
 
 	log.Fatal(http.ListenAndServeTLS(":9000", ws.Config.Certname+".cert", ws.Config.Certname+".key", myRouter))
 }
@@ -98,9 +111,10 @@ func run(configPath string) {
 	var nodesT *sync.Map = new(sync.Map)
 	var tasksT *sync.Map = new(sync.Map)
 	var rendersT *sync.Map = new(sync.Map)
+	var pausedT *sync.Map = new(sync.Map)
 
 	//Initializing
-	dB, cg := startSequence(configPath, nodesT, tasksT)
+	dB, cg := startSequence(configPath, nodesT, tasksT, pausedT)
 
 	fmt.Println("### Launching DB routine")
 	transacts := fifo.NewQueue()
@@ -108,20 +122,46 @@ func run(configPath string) {
 	*stopDB = false
 	go rendererdb.DBTransactRoutines(dB, transacts, stopDB)
 
+	fmt.Println("### Scheduling waiting tasks")
+	sched, err := cg.NewScheduler()
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	tasksT.Range(func(k, v interface{}) bool {
+		v.(*sync.Map).Range(func(k2, v2 interface{}) bool {
+			if v2.(*render.Task).State == "waiting" {
+				sched.Add(v2.(*render.Task))
+			}
+			return true
+		})
+		return true
+	})
+
 	ws := rendererapi.WorkingSet{
 		Db:          dB,
 		Config:      cg,
 		Tasks:       tasksT,
 		Renders:     rendersT,
+		Paused:      pausedT,
 		RenderNodes: nodesT,
 		DBTransacts: transacts,
 		StopDB:      stopDB,
+		Scheduler:   sched,
 	}
 
+	fmt.Println("### Launching reaper routine")
+	stopReaper := new(bool)
+	*stopReaper = false
+	go ws.ReaperRoutine(stopReaper)
+
 	fmt.Println("### Starting file server")
+	cert, err := tls.LoadX509KeyPair(ws.Config.Certname+".cert", ws.Config.Certname+".key")
+	if err != nil {
+		log.Fatal(err.Error())
+	}
 	b := new(bool)
 	*b = false
-	go filexchange.StartListening(ws.Config.Folder, b)
+	go filexchange.StartListening(ws.Config.Folder, &tls.Config{Certificates: []tls.Certificate{cert}}, ws.AuthorizeTransfer, b)
 
 	fmt.Println("### Starting web server")
 	handleRequests(&ws)
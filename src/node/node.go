@@ -4,17 +4,30 @@ import (
 	"sync"
 
 	"github.com/LeoMarche/blenderer/src/utils"
+	"github.com/LeoMarche/blenderer/src/version"
 )
 
+//Renderer is a rendering engine installed on a Node
+type Renderer struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
 //Node is the base descriptor of a Node
 type Node struct {
-	Name   string `json:"name"`
-	IP     string `json:"ip"`
-	APIKey string `json:"api_key"`
-	state  string
+	Name      string     `json:"name"`
+	IP        string     `json:"ip"`
+	APIKey    string     `json:"api_key"`
+	Renderers []Renderer `json:"renderers"`
+	state     string
 	sync.Mutex
 }
 
+//Key returns the key of the node among the nodes of the server, its name and IP
+func (n *Node) Key() string {
+	return n.Name + "//" + n.IP
+}
+
 //State returns the state of the Node N
 func (n *Node) State() string {
 
@@ -40,7 +53,7 @@ func (n *Node) Commission() bool {
 
 //SetState can be used to st the state of a Node to an accepted value
 func (n *Node) SetState(state string) bool {
-	acceptedStates := []string{"available", "rendering", "down", "error"}
+	acceptedStates := []string{"available", "rendering", "down", "error", "lost"}
 	if utils.IsIn(state, acceptedStates) >= 0 {
 		n.Lock()
 		n.state = state
@@ -71,3 +84,44 @@ func (n *Node) Up() {
 		n.state = "available"
 	}
 }
+
+//Recover allows to reactivate a node that was lost, it returns true if the node was lost
+func (n *Node) Recover() bool {
+	n.Lock()
+	defer n.Unlock()
+
+	if n.state == "lost" {
+		n.state = "available"
+		return true
+	}
+	return false
+}
+
+//SetRenderers replaces the rendering engines installed on the node
+func (n *Node) SetRenderers(renderers []Renderer) {
+	n.Lock()
+	defer n.Unlock()
+
+	n.Renderers = renderers
+}
+
+//GetRenderers returns a copy of the rendering engines installed on the node
+func (n *Node) GetRenderers() []Renderer {
+	n.Lock()
+	defer n.Unlock()
+
+	return append([]Renderer{}, n.Renderers...)
+}
+
+//Supports returns true if the renderer name is installed on the node with a version satisfying the constraint
+func (n *Node) Supports(name, constraint string) bool {
+	n.Lock()
+	defer n.Unlock()
+
+	for _, r := range n.Renderers {
+		if r.Name == name && version.Match(constraint, r.Version) {
+			return true
+		}
+	}
+	return false
+}
@@ -107,3 +107,62 @@ func TestUp(t *testing.T) {
 	assert.Equal(t0, "rendering", "Uping a rendering node")
 	assert.Equal(t1, "available", "Not uping a down node")
 }
+
+func TestRecover(t *testing.T) {
+
+	assert := assert.New(t)
+
+	n1 := Node{
+		Name:   "test_name",
+		IP:     "test_ip",
+		APIKey: "test_a_k",
+		state:  "down",
+	}
+
+	r0 := n1.Recover()
+	t0 := n1.state
+	n1.SetState("lost")
+	t1 := n1.state
+	r1 := n1.Recover()
+	t2 := n1.state
+
+	assert.Equal(false, r0, "Recovering a down node")
+	assert.Equal("down", t0, "Recovering a down node")
+	assert.Equal("lost", t1, "SetState doesn't accept lost")
+	assert.Equal(true, r1, "Not recovering a lost node")
+	assert.Equal("available", t2, "Not recovering a lost node")
+}
+
+func TestSupports(t *testing.T) {
+
+	assert := assert.New(t)
+
+	n1 := Node{
+		Name:   "test_name",
+		IP:     "test_ip",
+		APIKey: "test_a_k",
+		state:  "available",
+	}
+
+	s0 := n1.Supports("blender", "2.91.0")
+	n1.SetRenderers([]Renderer{{"blender", "2.91.0"}, {"blender", "3.6.0"}})
+	s1 := n1.Supports("blender", "2.91.0")
+	s2 := n1.Supports("blender", "3.6.0")
+	s3 := n1.Supports("blender", "2.91.1")
+	s4 := n1.Supports("cycles", "2.91.0")
+
+	assert.Equal(false, s0, "Supporting a renderer on a node without renderers")
+	assert.Equal(true, s1, "Not supporting an installed renderer")
+	assert.Equal(true, s2, "Not supporting an installed renderer")
+	assert.Equal(false, s3, "Supporting another version of a renderer")
+	assert.Equal(false, s4, "Supporting another renderer")
+	assert.Equal(2, len(n1.GetRenderers()), "Bad renderers returned")
+
+	s5 := n1.Supports("blender", "~3.6")
+	s6 := n1.Supports("blender", ">=3.0 <3.6")
+	s7 := n1.Supports("blender", ">=2.90, <3")
+
+	assert.Equal(true, s5, "Not supporting a renderer satisfying the constraint")
+	assert.Equal(false, s6, "Supporting a renderer not satisfying the constraint")
+	assert.Equal(true, s7, "Not supporting a renderer satisfying the constraint")
+}
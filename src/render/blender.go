@@ -0,0 +1,233 @@
+package render
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/LeoMarche/blenderer/src/utils"
+)
+
+//blenderEngine drives Blender in background mode
+type blenderEngine struct{}
+
+//Command builds the blender command rendering the frame of rt, or its chunk of frames as an animation
+func (blenderEngine) Command(rt *RendererTask) (*exec.Cmd, error) {
+	fmt.Println(rt.Renderer.Executable, rt.Task.Input, rt.Task.Output, rt.Task.Frame, rt.Task.LastFrame())
+	args := []string{"-b", "-noaudio", rt.Task.Input}
+	args = append(args, rt.Task.Settings.blenderArgs(rt.Task.Output, rt.Task.Tile)...)
+	if rt.Task.LastFrame() > rt.Task.Frame {
+		args = append(args, "-s", strconv.Itoa(rt.Task.Frame), "-e", strconv.Itoa(rt.Task.LastFrame()), "-a")
+	} else {
+		args = append(args, "-f", strconv.Itoa(rt.Task.Frame))
+	}
+	return exec.Command(rt.Renderer.Executable, args...), nil
+}
+
+//Progress parses the blender log of rt
+func (blenderEngine) Progress(rt *RendererTask) (Progress, error) {
+	return ParseBlenderLog(rt.Log.String())
+}
+
+//OutputFile returns the path of the image written by blender for the given frame
+func (blenderEngine) OutputFile(rt *RendererTask, frame int) string {
+	return rt.Task.OutputName(frame)
+}
+
+var (
+	blenderFrame     = regexp.MustCompile(`^Fra:(\d+) `)
+	blenderGlobalMem = regexp.MustCompile(`^Fra:\d+ Mem:([\d.]+)([KMG]) \((?:[\d.]+[KMG], )?Peak ([\d.]+)([KMG])\)`)
+	blenderRenderMem = regexp.MustCompile(`^Mem:([\d.]+)([KMG]), Peak:([\d.]+)([KMG])$`)
+	blenderTime      = regexp.MustCompile(`^Time:([\d:.]+)$`)
+	blenderRemaining = regexp.MustCompile(`^Remaining:([\d:.]+)$`)
+	blenderTiles     = regexp.MustCompile(`Rendered (\d+)/(\d+) Tiles`)
+	blenderTile      = regexp.MustCompile(`Path Tracing Tile (\d+)/(\d+)(?:, Sample (\d+)/(\d+))?`)
+	blenderSamples   = regexp.MustCompile(`Sample (\d+)/(\d+)`)
+	blenderEevee     = regexp.MustCompile(`Rendering (\d+) / (\d+) samples`)
+	blenderSaved     = regexp.MustCompile(`^Saved: '(.*)'`)
+)
+
+//ParseBlenderLog parses the output of blender 2.7x to 4.x and returns the progress of the last frame rendered.
+//The state stays "error" until blender starts to render. Lines that can't be parsed are skipped and the first
+//parse error is returned along with the progress parsed from the other lines.
+func ParseBlenderLog(log string) (Progress, error) {
+	p := Progress{State: "error"}
+	finished, quitted := false, false
+	var perr error
+
+	lines := strings.FieldsFunc(log, func(r rune) bool {
+		return r == '\n' || r == '\r'
+	})
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+
+		switch {
+		case blenderFrame.MatchString(line):
+			if err := parseBlenderStatus(line, &p); err != nil && perr == nil {
+				perr = err
+			}
+			if p.Phase == "finished" {
+				finished = true
+			}
+		case blenderSaved.MatchString(line):
+			p.Phase = "saving"
+			p.Saved = blenderSaved.FindStringSubmatch(line)[1]
+			finished = true
+		case line == "Blender quit":
+			quitted = true
+		}
+	}
+
+	if finished && quitted {
+		p.State = "rendered"
+		p.Phase = "finished"
+		p.Fraction = 1
+		p.Mem = 0
+	}
+
+	return p, perr
+}
+
+//parseBlenderStatus updates p with a status line of blender
+func parseBlenderStatus(line string, p *Progress) error {
+	fr, err := strconv.Atoi(blenderFrame.FindStringSubmatch(line)[1])
+	if err != nil {
+		return fmt.Errorf("invalid frame in line %q : %w", line, err)
+	}
+	if fr != p.Frame {
+		*p = Progress{State: p.State, Frame: fr, Saved: p.Saved}
+	}
+
+	fields := strings.Split(line, " | ")
+
+	//Memory of the render if cycles prints it, else global memory of blender
+	if m := blenderGlobalMem.FindStringSubmatch(fields[0]); m != nil {
+		if err := p.setMemory(m[1:]); err != nil {
+			return err
+		}
+	}
+
+	p.Remaining = ""
+	var status []string
+
+	for _, f := range fields[1:] {
+		if m := blenderRenderMem.FindStringSubmatch(f); m != nil {
+			if err := p.setMemory(m[1:]); err != nil {
+				return err
+			}
+		} else if m := blenderTime.FindStringSubmatch(f); m != nil {
+			p.Time = m[1]
+		} else if m := blenderRemaining.FindStringSubmatch(f); m != nil {
+			p.Remaining = m[1]
+		} else {
+			status = append(status, f)
+		}
+	}
+
+	st := strings.Join(status, " | ")
+
+	if m := blenderTiles.FindStringSubmatch(st); m != nil {
+		err = p.setSteps("tiles", m[1], m[2], 0)
+		p.Phase = "rendering"
+		if strings.Contains(st, "Denoised") {
+			p.Phase = "denoising"
+		}
+	} else if m := blenderTile.FindStringSubmatch(st); m != nil {
+		if m[3] != "" {
+			var n int
+			var s, t float64
+			if n, err = strconv.Atoi(m[1]); err != nil {
+				return fmt.Errorf("invalid tile in line %q : %w", line, err)
+			}
+			if s, err = strconv.ParseFloat(m[3], 64); err != nil {
+				return fmt.Errorf("invalid sample in line %q : %w", line, err)
+			}
+			if t, err = strconv.ParseFloat(m[4], 64); err != nil || t == 0 {
+				return fmt.Errorf("invalid number of samples in line %q", line)
+			}
+			err = p.setSteps("tiles", strconv.Itoa(n-1), m[2], s/t)
+		} else {
+			err = p.setSteps("tiles", m[1], m[2], 0)
+		}
+		p.Phase = "rendering"
+	} else if m := blenderSamples.FindStringSubmatch(st); m != nil {
+		err = p.setSteps("samples", m[1], m[2], 0)
+		p.Phase = "rendering"
+	} else if m := blenderEevee.FindStringSubmatch(st); m != nil {
+		err = p.setSteps("samples", m[1], m[2], 0)
+		p.Phase = "rendering"
+	} else if utils.IsIn("Compositing", status) >= 0 {
+		p.Phase = "compositing"
+	} else if utils.IsIn("Finished", status) >= 0 {
+		p.Phase = "finished"
+	} else if strings.Contains(st, "Denois") || strings.Contains(st, "Finishing") {
+		p.Phase = "denoising"
+	} else if p.State != "rendering" {
+		p.Phase = "preparing"
+	}
+
+	if p.Phase != "preparing" {
+		p.State = "rendering"
+	}
+
+	if err != nil {
+		return fmt.Errorf("%w in line %q", err, line)
+	}
+	return nil
+}
+
+//setSteps sets the steps done out of total steps of the given unit, extra being the fraction of the current step done
+func (p *Progress) setSteps(unit, done, total string, extra float64) error {
+	d, err := strconv.Atoi(done)
+	if err != nil {
+		return fmt.Errorf("invalid number of %s done %s", unit, done)
+	}
+	t, err := strconv.Atoi(total)
+	if err != nil || t == 0 {
+		return fmt.Errorf("invalid total number of %s %s", unit, total)
+	}
+
+	p.Unit = unit
+	p.Done = d
+	p.Total = t
+	p.Fraction = (float64(d) + extra) / float64(t)
+	return nil
+}
+
+//setMemory sets the memory and peak memory from their values and K, M or G units, as in [mem, unit, peak, unit]
+func (p *Progress) setMemory(m []string) error {
+	mem, err := ParseMemory(m[0], m[1])
+	if err != nil {
+		return err
+	}
+	peak, err := ParseMemory(m[2], m[3])
+	if err != nil {
+		return err
+	}
+
+	p.Mem = mem
+	p.Peak = peak
+	return nil
+}
+
+//ParseMemory converts a memory value printed with a K, M or G unit to MB
+func ParseMemory(value, unit string) (float64, error) {
+	v, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid memory value %s%s", value, unit)
+	}
+
+	switch unit {
+	case "K":
+		return v / 1024, nil
+	case "M", "":
+		return v, nil
+	case "G":
+		return v * 1024, nil
+	}
+
+	return 0, fmt.Errorf("unknown memory unit %s", unit)
+}
@@ -0,0 +1,116 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+//defaultCommandOutput is the output file template used when a command renderer doesn't define one
+const defaultCommandOutput = "{output}{paddedFrame}.png"
+
+//commandEngine drives any executable through the argument template of its Renderer.
+//The placeholders {input}, {output}, {frame}, {paddedFrame}, {frameStart} and {frameEnd} are replaced in Args and OutputFile.
+//{frame} and {paddedFrame} are the first frame of the task in Args, and each frame of the chunk in OutputFile.
+type commandEngine struct{}
+
+//expand replaces the placeholders of s with the values of the task of rt for the given frame
+func (commandEngine) expand(rt *RendererTask, s string, frame int) string {
+	r := strings.NewReplacer(
+		"{input}", rt.Task.Input,
+		"{output}", rt.Task.Output,
+		"{frame}", strconv.Itoa(frame),
+		"{paddedFrame}", fmt.Sprintf("%05d", frame),
+		"{frameStart}", strconv.Itoa(rt.Task.Frame),
+		"{frameEnd}", strconv.Itoa(rt.Task.LastFrame()),
+	)
+	return r.Replace(s)
+}
+
+//Command builds the command from the argument template of the renderer of rt.
+//A chunk of several frames can only be rendered by a command using {frameEnd}.
+func (e commandEngine) Command(rt *RendererTask) (*exec.Cmd, error) {
+	if len(rt.Renderer.Args) == 0 {
+		return nil, errors.New("no arguments configured for renderer " + rt.Renderer.Name)
+	}
+
+	chunked := false
+	args := make([]string, len(rt.Renderer.Args))
+	for i, a := range rt.Renderer.Args {
+		chunked = chunked || strings.Contains(a, "{frameEnd}")
+		args[i] = e.expand(rt, a, rt.Task.Frame)
+	}
+
+	if rt.Task.LastFrame() > rt.Task.Frame && !chunked {
+		return nil, errors.New("renderer " + rt.Renderer.Name + " can't render chunks of frames, its arguments don't use {frameEnd}")
+	}
+	if rt.Task.Settings.Tiled() {
+		return nil, errors.New("renderer " + rt.Renderer.Name + " can't render tiles")
+	}
+
+	return exec.Command(rt.Renderer.Executable, args...), nil
+}
+
+//Progress returns the state of the command, using the Progress regexp of the renderer to get the fraction rendered.
+//The regexp must either capture a percentage or the number of done and total steps.
+func (e commandEngine) Progress(rt *RendererTask) (Progress, error) {
+	if exited, err := rt.Exited(); exited {
+		if err != nil {
+			return Progress{State: "error"}, nil
+		}
+		for f := rt.Task.Frame; f <= rt.Task.LastFrame(); f++ {
+			if _, err := os.Stat(e.OutputFile(rt, f)); err != nil {
+				return Progress{State: "error"}, nil
+			}
+		}
+		return Progress{State: "rendered", Fraction: 1}, nil
+	}
+
+	if rt.Renderer.Progress == "" {
+		return Progress{State: "rendering"}, nil
+	}
+
+	re, err := regexp.Compile(rt.Renderer.Progress)
+	if err != nil {
+		return Progress{State: "rendering"}, fmt.Errorf("invalid progress regexp of renderer %s : %w", rt.Renderer.Name, err)
+	}
+
+	tab := re.FindAllStringSubmatch(rt.Log.String(), -1)
+	if len(tab) == 0 {
+		return Progress{State: "rendering"}, nil
+	}
+	lastline := tab[len(tab)-1]
+
+	switch len(lastline) {
+	case 2:
+		pc, err := strconv.ParseFloat(lastline[1], 64)
+		if err != nil {
+			return Progress{State: "rendering"}, fmt.Errorf("invalid percentage %q : %w", lastline[0], err)
+		}
+		return Progress{State: "rendering", Fraction: pc / 100}, nil
+	case 3:
+		num, err := strconv.ParseFloat(lastline[1], 64)
+		if err != nil {
+			return Progress{State: "rendering"}, fmt.Errorf("invalid progress %q : %w", lastline[0], err)
+		}
+		denom, err := strconv.ParseFloat(lastline[2], 64)
+		if err != nil || denom == 0 {
+			return Progress{State: "rendering"}, fmt.Errorf("invalid progress %q", lastline[0])
+		}
+		return Progress{State: "rendering", Fraction: num / denom}, nil
+	}
+
+	return Progress{State: "rendering"}, fmt.Errorf("progress regexp of renderer %s must capture 1 or 2 groups", rt.Renderer.Name)
+}
+
+//OutputFile returns the path of the file produced for the given frame by the command of rt
+func (e commandEngine) OutputFile(rt *RendererTask, frame int) string {
+	if rt.Renderer.OutputFile == "" {
+		return e.expand(rt, defaultCommandOutput, frame)
+	}
+	return e.expand(rt, rt.Renderer.OutputFile, frame)
+}
@@ -0,0 +1,45 @@
+package render
+
+import (
+	"os/exec"
+	"sync"
+)
+
+//Engine describes how a node drives a rendering engine
+type Engine interface {
+	//Command builds the command that renders the frames of the task of rt, from Frame to LastFrame
+	Command(rt *RendererTask) (*exec.Cmd, error)
+
+	//Progress parses the state of the render of rt, returning an error along with the progress if part of it couldn't be parsed
+	Progress(rt *RendererTask) (Progress, error)
+
+	//OutputFile returns the path of the file produced for the given frame by the render of rt
+	OutputFile(rt *RendererTask, frame int) string
+}
+
+var (
+	enginesLock sync.RWMutex
+	engines     = make(map[string]Engine)
+)
+
+func init() {
+	RegisterEngine("blender", blenderEngine{})
+	RegisterEngine("command", commandEngine{})
+}
+
+//RegisterEngine makes the engine e available for the renderers named name
+func RegisterEngine(name string, e Engine) {
+	enginesLock.Lock()
+	defer enginesLock.Unlock()
+
+	engines[name] = e
+}
+
+//GetEngine returns the engine registered under name and true, or nil and false if there is none
+func GetEngine(name string) (Engine, bool) {
+	enginesLock.RLock()
+	defer enginesLock.RUnlock()
+
+	e, ok := engines[name]
+	return e, ok
+}
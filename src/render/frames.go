@@ -0,0 +1,109 @@
+package render
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//MaxFrames is the maximal number of frames of a job, each tile of a split frame counting as one
+const MaxFrames = 100000
+
+//ParseFrames returns the frames of a spec made of comma separated frames and ranges, in increasing order and
+//without duplicates. A range A-B renders the frames from A to B, A-BxN every Nth frame of them, as in 1-10,15,20-40x5.
+//An error is returned if the spec lists more than MaxFrames frames, duplicates included.
+func ParseFrames(spec string) ([]int, error) {
+	seen := make(map[int]bool)
+	frames := []int{}
+	total := 0
+
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			return nil, fmt.Errorf("empty item in frames '%s'", spec)
+		}
+
+		start, stop, step, err := parseFrameItem(item)
+		if err != nil {
+			return nil, err
+		}
+
+		n := frameCount(start, stop, step)
+		if n > uint64(MaxFrames-total) {
+			return nil, fmt.Errorf("more than %d frames in '%s'", MaxFrames, spec)
+		}
+		total += int(n)
+
+		for i := 0; i < int(n); i++ {
+			if f := start + i*step; !seen[f] {
+				seen[f] = true
+				frames = append(frames, f)
+			}
+		}
+	}
+
+	sort.Ints(frames)
+	return frames, nil
+}
+
+//frameCount returns the number of frames from start to stop every step, stop being after start. The frames are
+//counted without overflowing, the last one being start+(n-1)*step, and the count saturates at math.MaxUint64.
+func frameCount(start, stop, step int) uint64 {
+	steps := (uint64(stop) - uint64(start)) / uint64(step)
+	if steps == math.MaxUint64 {
+		return steps
+	}
+	return steps + 1
+}
+
+//parseFrameItem parses a frame N, a range A-B or a range with a step A-BxN
+func parseFrameItem(item string) (int, int, int, error) {
+	invalid := fmt.Errorf("invalid frames '%s'", item)
+
+	rng, stepStr, hasStep := cut(item, "x")
+	startStr, stopStr, isRange := cut(rng, "-")
+	if hasStep && !isRange {
+		return 0, 0, 0, invalid
+	}
+
+	start, err := parseFrame(startStr)
+	if err != nil {
+		return 0, 0, 0, invalid
+	}
+	if !isRange {
+		return start, start, 1, nil
+	}
+
+	stop, err := parseFrame(stopStr)
+	if err != nil || stop < start {
+		return 0, 0, 0, invalid
+	}
+
+	step := 1
+	if hasStep {
+		step, err = strconv.Atoi(stepStr)
+		if err != nil || step < 1 {
+			return 0, 0, 0, invalid
+		}
+	}
+	return start, stop, step, nil
+}
+
+//parseFrame parses a positive frame number
+func parseFrame(s string) (int, error) {
+	f, err := strconv.Atoi(strings.TrimSpace(s))
+	if err != nil || f < 0 || strings.HasPrefix(strings.TrimSpace(s), "+") {
+		return 0, fmt.Errorf("invalid frame '%s'", s)
+	}
+	return f, nil
+}
+
+//cut slices s around the first sep, returning false if sep isn't in s
+func cut(s, sep string) (string, string, bool) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):], true
+	}
+	return s, "", false
+}
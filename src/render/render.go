@@ -4,46 +4,85 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
-	"log"
 	"os/exec"
-	"regexp"
-	"strconv"
 	"sync"
+
+	"github.com/LeoMarche/blenderer/src/utils"
+	"github.com/LeoMarche/blenderer/src/version"
 )
 
 //Task is the base descriptor of a Render Task
+//Tasks of higher Priority are rendered first, then the ones Submitted first (in unix nanoseconds).
+//Owner is the API key the task was posted with, it isn't sent to the nodes.
+//A task renders the chunk of frames from Frame to FrameEnd, or only Frame if FrameEnd isn't greater.
+//If the frames of its job are split in tiles (see Settings), it only renders the region Tile of Frame.
+//Attempts counts the failed renders of the task, the last one failing with LastError, and FailedNodes are the keys
+//of the nodes it failed on. It isn't given to them again.
 type Task struct {
-	Project         string `json:"project"`
-	ID              string `json:"id"`
-	Input           string `json:"input"`
-	Output          string `json:"output"`
-	Frame           int    `json:"frame"`
-	State           string `json:"state"`
-	RendererName    string `json:"rendererName"`
-	RendererVersion string `json:"rendererVersion"`
-	StartTime       string `json:"startTime"`
+	Project         string   `json:"project"`
+	ID              string   `json:"id"`
+	Input           string   `json:"input"`
+	Output          string   `json:"output"`
+	Frame           int      `json:"frame"`
+	FrameEnd        int      `json:"frameEnd"`
+	Tile            int      `json:"tile"`
+	State           string   `json:"state"`
+	RendererName    string   `json:"rendererName"`
+	RendererVersion string   `json:"rendererVersion"`
+	StartTime       string   `json:"startTime"`
+	Settings        Settings `json:"settings"`
+	Bundle          string   `json:"bundle"`
+	Priority        int      `json:"priority"`
+	Submitted       int64    `json:"submitted"`
+	Owner           string   `json:"-"`
+	Attempts        int      `json:"attempts"`
+	LastError       string   `json:"lastError"`
+	FailedNodes     []string `json:"-"`
 	sync.Mutex
 }
 
+//TaskKey identifies a task among the ones of its job
+type TaskKey struct {
+	Frame int
+	Tile  int
+}
+
 //VideoTask is the base descriptor for a Video Render Task
+//Its frames are listed by Frames (see ParseFrames), or else go from FrameStart to FrameStop every FrameStep frames.
+//They are grouped by chunks of at most ChunkSize consecutive frames, each one rendered by a single task.
+//If the frames are split in tiles, each tile of each frame is rendered by its own task instead.
 type VideoTask struct {
-	Project         string `json:"project"`
-	ID              string `json:"id"`
-	Input           string `json:"input"`
-	Output          string `json:"output"`
-	FrameStart      int    `json:"frameStart"`
-	FrameStop       int    `json:"frameStop"`
-	State           string `json:"state"`
-	RendererName    string `json:"rendererName"`
-	RendererVersion string `json:"rendererVersion"`
-	StartTime       string `json:"startTime"`
-}
-
-//Renderer is a struct that contains path to blender and version/name of it
+	Project         string   `json:"project"`
+	ID              string   `json:"id"`
+	Input           string   `json:"input"`
+	Output          string   `json:"output"`
+	FrameStart      int      `json:"frameStart"`
+	FrameStop       int      `json:"frameStop"`
+	FrameStep       int      `json:"frameStep"`
+	Frames          string   `json:"frames"`
+	ChunkSize       int      `json:"chunkSize"`
+	State           string   `json:"state"`
+	RendererName    string   `json:"rendererName"`
+	RendererVersion string   `json:"rendererVersion"`
+	StartTime       string   `json:"startTime"`
+	Settings        Settings `json:"settings"`
+	Bundle          string   `json:"bundle"`
+	Priority        int      `json:"priority"`
+	Submitted       int64    `json:"submitted"`
+	Owner           string   `json:"-"`
+}
+
+//Renderer is a struct that contains path to a rendering engine and version/name of it.
+//Engine selects the registered Engine driving it (Name is used if empty), Args, OutputFile
+//and Progress configure the "command" engine.
 type Renderer struct {
-	Executable string `json:"executable"`
-	Version    string `json:"version"`
-	Name       string `json:"name"`
+	Executable string   `json:"executable"`
+	Version    string   `json:"version"`
+	Name       string   `json:"name"`
+	Engine     string   `json:"engine"`
+	Args       []string `json:"args"`
+	OutputFile string   `json:"outputFile"`
+	Progress   string   `json:"progress"`
 }
 
 //RendererTask describes a Task and its associated Renderer
@@ -51,36 +90,134 @@ type RendererTask struct {
 	Task     *Task
 	Renderer *Renderer
 	Log      bytes.Buffer
+	cmd      *exec.Cmd
+	exited   bool
+	exitErr  error
+	exitLock sync.Mutex
+}
+
+//Progress describes the state of a running render.
+//State is "error" until the render starts, then "rendering" and "rendered".
+//Mem and Peak are in MB, Done out of Total steps are counted in Unit ("tiles" or "samples").
+type Progress struct {
+	State     string  `json:"state"`
+	Phase     string  `json:"phase"`
+	Frame     int     `json:"frame"`
+	Time      string  `json:"time"`
+	Remaining string  `json:"remaining"`
+	Mem       float64 `json:"mem"`
+	Peak      float64 `json:"peak"`
+	Done      int     `json:"done"`
+	Total     int     `json:"total"`
+	Unit      string  `json:"unit"`
+	Fraction  float64 `json:"fraction"`
+	Saved     string  `json:"saved"`
+}
+
+//frames returns the frames of vt in increasing order
+func (vt *VideoTask) frames() ([]int, error) {
+	if vt.Frames != "" {
+		return ParseFrames(vt.Frames)
+	}
+
+	if vt.FrameStop < vt.FrameStart {
+		return nil, fmt.Errorf("frameStop %d is before frameStart %d", vt.FrameStop, vt.FrameStart)
+	}
+	step := vt.FrameStep
+	if step < 1 {
+		step = 1
+	}
+	n := frameCount(vt.FrameStart, vt.FrameStop, step)
+	if n > MaxFrames {
+		return nil, fmt.Errorf("more than %d frames from frameStart %d to frameStop %d", MaxFrames, vt.FrameStart, vt.FrameStop)
+	}
+
+	frames := make([]int, n)
+	for i := range frames {
+		frames[i] = vt.FrameStart + i*step
+	}
+	return frames, nil
 }
 
-//GetIndividualTasks returns an array of all the tasks of vt frames by frames
-func (vt *VideoTask) GetIndividualTasks() []*Task {
+//GetIndividualTasks returns an array of all the tasks of vt chunk by chunk, frame by frame if ChunkSize is below 2.
+//A chunk only groups consecutive frames. An error is returned if the frames of vt are invalid or if there are more
+//than MaxFrames of them, each tile counting as a frame.
+func (vt *VideoTask) GetIndividualTasks() ([]*Task, error) {
+	frames, err := vt.frames()
+	if err != nil {
+		return nil, err
+	}
+	columns, rows := vt.Settings.TileGrid()
+	if len(frames) > MaxFrames/columns/rows {
+		return nil, fmt.Errorf("more than %d tiles of frames to render", MaxFrames)
+	}
+
 	var tasks []*Task
-	for i := vt.FrameStart; i <= vt.FrameStop; i++ {
-		itask := &Task{
-			Project:         vt.Project,
-			ID:              vt.ID,
-			Input:           vt.Input,
-			Output:          vt.Output,
-			Frame:           i,
-			State:           vt.State,
-			RendererName:    vt.RendererName,
-			RendererVersion: vt.RendererVersion,
-			StartTime:       vt.StartTime,
+
+	//Tiles of a frame are never grouped
+	if vt.Settings.Tiled() {
+		for _, f := range frames {
+			for tile := 0; tile < vt.Settings.Tiles(); tile++ {
+				tasks = append(tasks, vt.task(f, f, tile))
+			}
 		}
-		tasks = append(tasks, itask)
+		return tasks, nil
+	}
+
+	size := vt.ChunkSize
+	if size < 1 {
+		size = 1
+	}
+
+	for j := 0; j < len(frames); {
+		i := frames[j]
+		end := i
+		for j++; j < len(frames) && frames[j] == end+1 && end-i+1 < size; j++ {
+			end = frames[j]
+		}
+		tasks = append(tasks, vt.task(i, end, 0))
+	}
+	return tasks, nil
+}
+
+//task returns the task of vt rendering the tile of the frames from frame to end
+func (vt *VideoTask) task(frame, end, tile int) *Task {
+	return &Task{
+		Project:         vt.Project,
+		ID:              vt.ID,
+		Input:           vt.Input,
+		Output:          vt.Output,
+		Frame:           frame,
+		FrameEnd:        end,
+		Tile:            tile,
+		State:           vt.State,
+		RendererName:    vt.RendererName,
+		RendererVersion: vt.RendererVersion,
+		StartTime:       vt.StartTime,
+		Settings:        vt.Settings,
+		Bundle:          vt.Bundle,
+		Priority:        vt.Priority,
+		Submitted:       vt.Submitted,
+		Owner:           vt.Owner,
 	}
-	return tasks
 }
 
-//MatchRenderer tries to match a Task with its renderer and returns a pointer to a RendererTask or nil if not found
+//MatchRenderer tries to match a Task with its renderer and returns a pointer to a RendererTask or nil if not found.
+//RendererVersion is a version constraint, the highest version of the renderer satisfying it is chosen.
 func (t *Task) MatchRenderer(rTable []Renderer) *RendererTask {
+	indexes := []int{}
+	versions := []string{}
 	for i := 0; i < len(rTable); i++ {
-		if rTable[i].Name == t.RendererName && rTable[i].Version == t.RendererVersion {
-			return &RendererTask{t, &rTable[i], *new(bytes.Buffer)}
+		if rTable[i].Name == t.RendererName {
+			indexes = append(indexes, i)
+			versions = append(versions, rTable[i].Version)
 		}
 	}
 
+	if b := version.Best(t.RendererVersion, versions); b >= 0 {
+		return &RendererTask{Task: t, Renderer: &rTable[indexes[b]]}
+	}
+
 	return nil
 }
 
@@ -90,83 +227,206 @@ func (t *Task) SetState(state string) {
 	t.Unlock()
 }
 
-//LaunchRender launches the render on the renderer
-func (rt *RendererTask) LaunchRender() (*exec.Cmd, error) {
-	if rt.Renderer.Name == "blender" {
-		fmt.Println(rt.Renderer.Executable, rt.Task.Input, rt.Task.Output, rt.Task.Frame)
-		cmd := exec.Command(rt.Renderer.Executable, "-b", "-noaudio", rt.Task.Input, "-o", rt.Task.Output+"#####", "-F", "PNG", "-f", strconv.Itoa(rt.Task.Frame))
-		cmd.Stdout = &rt.Log
-		a := cmd.Start()
-		return cmd, a
-	}
-	return nil, errors.New("no matching renderer")
-}
-
-//CheckRender check the state of the render and returns state, percentage, memory use of the render
-func (rt *RendererTask) CheckRender() (string, float64, float64) {
-	if rt.Renderer.Name == "blender" {
-
-		rN := 0.0
-		rD := 10.0
-		rM := 0.0
-		var err error
-
-		str := rt.Log.String()
-		state, _, _, mem, _, renderedNum, renderedDenom := CheckBlenderRender(str)
-		if state != "" && renderedNum != "" && renderedDenom != "" {
-			rN, err = strconv.ParseFloat(renderedNum, 64)
-			if err != nil {
-				log.Fatal(err)
-			}
-			rD, err = strconv.ParseFloat(renderedDenom, 64)
-			if err != nil {
-				log.Fatal(err)
-			}
-			rM, err = strconv.ParseFloat(mem, 64)
-			if err != nil {
-				log.Fatal(err)
-			}
-		}
+//Key returns the key of the task among the ones of its job
+func (t *Task) Key() TaskKey {
+	return TaskKey{Frame: t.Frame, Tile: t.Tile}
+}
+
+//GetState returns the state of the task
+func (t *Task) GetState() string {
+	t.Lock()
+	defer t.Unlock()
+	return t.State
+}
+
+//FrameName returns the name of the image of the whole frame, from the Output prefix of the task
+func (t *Task) FrameName(frame int) string {
+	return t.Output + fmt.Sprintf("%05d", frame) + t.Settings.Extension()
+}
 
-		return state, rN / rD, rM
+//OutputName returns the name of the image written by the task for the frame, from its Output prefix.
+//The tile of a split frame is written to its own image.
+func (t *Task) OutputName(frame int) string {
+	return t.Output + fmt.Sprintf("%05d", frame) + t.Settings.tileSuffix(t.Tile) + t.Settings.Extension()
+}
+
+//LastFrame returns the last frame rendered by the task
+func (t *Task) LastFrame() int {
+	if t.FrameEnd > t.Frame {
+		return t.FrameEnd
 	}
+	return t.Frame
+}
 
-	return "", 0, 0
+//Frames returns the number of frames rendered by the task
+func (t *Task) Frames() int {
+	return t.LastFrame() - t.Frame + 1
 }
 
-//CheckBlenderRender checks the status of the render and returns stats if currently rendering
-func CheckBlenderRender(test string) (string, string, string, string, string, string, string) {
-	substRendering := `Time:([\d|:|.]+) \| Remaining:([\d|:|.]+) \| Mem:([\d|:|.]+)M, Peak:([\d|:|.]+)M .* Rendered ([\d]+)/([\d]+) Tiles`
-	substRendered := "\\| Finished"
-	substQuit := "Blender quit"
+//Split cuts the chunk of t after its first done frames, which are left to t in "rendered" state, and returns a
+//waiting task rendering the remaining frames. It returns nil if no frame or every frame of the chunk is done.
+func (t *Task) Split(done int) *Task {
+	t.Lock()
+	defer t.Unlock()
 
-	status := "error"
-	var time, remaining, mem, peak, renderedNum, renderedDenom string
+	if done <= 0 || done >= t.Frames() {
+		return nil
+	}
 
-	rendering := regexp.MustCompile(substRendering)
-	rendered := regexp.MustCompile(substRendered)
-	quitted := regexp.MustCompile(substQuit)
+	rest := t.splitAt(t.Frame + done)
+	rest.State = "waiting"
+	t.State = "rendered"
+	return rest
+}
+
+//Cut cuts the chunk of t before frame and returns a task in the same state rendering the frames from frame to the
+//end of the chunk, t keeping the frames before. It returns nil if frame isn't in the chunk or is its first frame.
+func (t *Task) Cut(frame int) *Task {
+	t.Lock()
+	defer t.Unlock()
+
+	if frame <= t.Frame || frame > t.LastFrame() {
+		return nil
+	}
+	return t.splitAt(frame)
+}
+
+//splitAt gives the frames of t from frame to the end of its chunk to a copy of t and returns it, t must be locked
+func (t *Task) splitAt(frame int) *Task {
+	rest := &Task{
+		Project:         t.Project,
+		ID:              t.ID,
+		Input:           t.Input,
+		Output:          t.Output,
+		Frame:           frame,
+		FrameEnd:        t.LastFrame(),
+		Tile:            t.Tile,
+		State:           t.State,
+		RendererName:    t.RendererName,
+		RendererVersion: t.RendererVersion,
+		StartTime:       t.StartTime,
+		Settings:        t.Settings,
+		Bundle:          t.Bundle,
+		Priority:        t.Priority,
+		Submitted:       t.Submitted,
+		Owner:           t.Owner,
+		Attempts:        t.Attempts,
+		LastError:       t.LastError,
+		FailedNodes:     append([]string{}, t.FailedNodes...),
+	}
+	t.FrameEnd = frame - 1
+	return rest
+}
+
+//Fail records a failed attempt to render the task, the node it failed on being excluded from the next attempts if
+//it isn't empty. The task is put in "failed" state once it failed maxAttempts times, true being returned.
+func (t *Task) Fail(node, reason string, maxAttempts int) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	t.Attempts++
+	t.LastError = reason
+	if node != "" && utils.IsIn(node, t.FailedNodes) == -1 {
+		t.FailedNodes = append(t.FailedNodes, node)
+	}
+	if t.Attempts >= maxAttempts {
+		t.State = "failed"
+		return true
+	}
+	return false
+}
+
+//FailedOn returns true if the task already failed on the node
+func (t *Task) FailedOn(node string) bool {
+	t.Lock()
+	defer t.Unlock()
+	return utils.IsIn(node, t.FailedNodes) >= 0
+}
+
+//Reset puts the task back in "waiting" state to be rendered again, forgetting its failed attempts
+func (t *Task) Reset() {
+	t.Lock()
+	t.State = "waiting"
+	t.Attempts = 0
+	t.LastError = ""
+	t.FailedNodes = nil
+	t.Unlock()
+}
+
+//ForgetFailedNodes allows the nodes the task failed on to render it again, its attempts being kept
+func (t *Task) ForgetFailedNodes() {
+	t.Lock()
+	t.FailedNodes = nil
+	t.Unlock()
+}
 
-	if rendering.MatchString(test) {
+//SetPriority sets the priority of the task
+func (t *Task) SetPriority(priority int) {
+	t.Lock()
+	t.Priority = priority
+	t.Unlock()
+}
 
-		status = "rendering"
+//LaunchRender launches the render on the engine of the renderer
+func (rt *RendererTask) LaunchRender() (*exec.Cmd, error) {
+	e, ok := rt.engine()
+	if !ok {
+		return nil, errors.New("no matching renderer")
+	}
 
-		tab := rendering.FindAllStringSubmatch(test, -1)
-		lastline := tab[len(tab)-1]
-		time = lastline[1]
-		remaining = lastline[2]
-		mem = lastline[3]
-		peak = lastline[4]
-		renderedNum = lastline[5]
-		renderedDenom = lastline[6]
+	cmd, err := e.Command(rt)
+	if err != nil {
+		return nil, err
 	}
+	cmd.Stdout = &rt.Log
+	rt.cmd = cmd
+	a := cmd.Start()
+	return cmd, a
+}
 
-	if rendered.MatchString(test) && quitted.MatchString(test) {
-		status = "rendered"
-		renderedNum = "1"
-		renderedDenom = "1"
-		mem = "0"
+//Wait waits for the launched render to exit and records its exit status
+func (rt *RendererTask) Wait() error {
+	err := rt.cmd.Wait()
+
+	rt.exitLock.Lock()
+	rt.exited = true
+	rt.exitErr = err
+	rt.exitLock.Unlock()
+
+	return err
+}
+
+//Exited returns true and the exit error of the render if it has exited
+func (rt *RendererTask) Exited() (bool, error) {
+	rt.exitLock.Lock()
+	defer rt.exitLock.Unlock()
+
+	return rt.exited, rt.exitErr
+}
+
+//CheckRender check the state of the render and returns its progress.
+//A non nil error means part of the output of the render couldn't be parsed, the progress is still usable.
+func (rt *RendererTask) CheckRender() (Progress, error) {
+	if e, ok := rt.engine(); ok {
+		return e.Progress(rt)
 	}
 
-	return status, time, remaining, mem, peak, renderedNum, renderedDenom
+	return Progress{}, errors.New("no matching renderer")
+}
+
+//OutputFile returns the path of the file produced for the frame of the render, or an empty string if there's no matching engine
+func (rt *RendererTask) OutputFile(frame int) string {
+	if e, ok := rt.engine(); ok {
+		return e.OutputFile(rt, frame)
+	}
+
+	return ""
+}
+
+//engine returns the Engine driving the renderer, named by its Engine field or else by its Name
+func (rt *RendererTask) engine() (Engine, bool) {
+	if rt.Renderer.Engine != "" {
+		return GetEngine(rt.Renderer.Engine)
+	}
+	return GetEngine(rt.Renderer.Name)
 }
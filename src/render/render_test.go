@@ -1,14 +1,25 @@
 package render
 
 import (
+	"encoding/json"
+	"flag"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 )
 
+var update = flag.Bool("update", false, "update the golden files of the tests")
+
 func TestGetIndividualTasks(t *testing.T) {
 
 	assert := assert.New(t)
@@ -18,6 +29,7 @@ func TestGetIndividualTasks(t *testing.T) {
 		Input:           "test_input",
 		Output:          "test_output",
 		Frame:           0,
+		FrameEnd:        0,
 		State:           "waiting",
 		RendererName:    "test_renderer",
 		RendererVersion: "test_version",
@@ -28,12 +40,13 @@ func TestGetIndividualTasks(t *testing.T) {
 		Input:           "test_input",
 		Output:          "test_output",
 		Frame:           1,
+		FrameEnd:        1,
 		State:           "waiting",
 		RendererName:    "test_renderer",
 		RendererVersion: "test_version",
 	}
 
-	expected := []Task{task0, task1}
+	expected := []*Task{&task0, &task1}
 
 	vt := VideoTask{
 		Project:         "test_p",
@@ -47,11 +60,209 @@ func TestGetIndividualTasks(t *testing.T) {
 		RendererVersion: "test_version",
 	}
 
-	t0 := vt.GetIndividualTasks()
+	t0, err := vt.GetIndividualTasks()
+	assert.NoError(err)
 	assert.Equal(len(t0), 2, "Not good number of images")
 
-	frames := []Task{*t0[0], *t0[1]}
-	assert.Equal(frames, expected, "Bad Tasks from VideoTask")
+	assert.Equal(t0, expected, "Bad Tasks from VideoTask")
+
+	//Chunks of 4 frames, the last one being shorter
+	vt.FrameStart = 10
+	vt.FrameStop = 20
+	vt.ChunkSize = 4
+	t1, err := vt.GetIndividualTasks()
+	assert.NoError(err)
+	frames := []int{}
+	ends := []int{}
+	for _, tsk := range t1 {
+		frames = append(frames, tsk.Frame)
+		ends = append(ends, tsk.LastFrame())
+	}
+	assert.Equal([]int{10, 14, 18}, frames, "Bad chunks from VideoTask")
+	assert.Equal([]int{13, 17, 20}, ends, "Bad chunks from VideoTask")
+	assert.Equal(3, t1[2].Frames(), "Bad number of frames in chunk")
+
+	//Every 5th frame, the chunks only grouping consecutive frames
+	vt.FrameStep = 5
+	vt.ChunkSize = 2
+	t2, err := vt.GetIndividualTasks()
+	assert.NoError(err)
+	frames = []int{}
+	ends = []int{}
+	for _, tsk := range t2 {
+		frames = append(frames, tsk.Frame)
+		ends = append(ends, tsk.LastFrame())
+	}
+	assert.Equal([]int{10, 15, 20}, frames, "Bad frames with a step from VideoTask")
+	assert.Equal([]int{10, 15, 20}, ends, "Bad frames with a step from VideoTask")
+
+	//An explicit list of frames is used instead of the range
+	vt.Frames = "1-3,5,7-8"
+	vt.ChunkSize = 2
+	t3, err := vt.GetIndividualTasks()
+	assert.NoError(err)
+	frames = []int{}
+	ends = []int{}
+	for _, tsk := range t3 {
+		frames = append(frames, tsk.Frame)
+		ends = append(ends, tsk.LastFrame())
+	}
+	assert.Equal([]int{1, 3, 5, 7}, frames, "Bad frames from the list of VideoTask")
+	assert.Equal([]int{2, 3, 5, 8}, ends, "Bad frames from the list of VideoTask")
+
+	//Each tile of each frame is rendered by its own task
+	vt.Frames = "4-5"
+	vt.Settings = Settings{TilesX: 3, TilesY: 2}
+	t4, err := vt.GetIndividualTasks()
+	assert.NoError(err)
+	keys := []TaskKey{}
+	for _, tsk := range t4 {
+		keys = append(keys, tsk.Key())
+		assert.Equal(1, tsk.Frames(), "Tiles grouped by chunks")
+	}
+	assert.Equal(12, len(keys), "Bad number of tiles from VideoTask")
+	assert.Equal(TaskKey{Frame: 4, Tile: 0}, keys[0], "Bad first tile from VideoTask")
+	assert.Equal(TaskKey{Frame: 5, Tile: 5}, keys[11], "Bad last tile from VideoTask")
+	vt.Settings = Settings{}
+
+	vt.Frames = "1-3,a"
+	_, err = vt.GetIndividualTasks()
+	assert.Error(err, "Invalid list of frames accepted")
+
+	vt.Frames = ""
+	vt.FrameStart = 30
+	_, err = vt.GetIndividualTasks()
+	assert.Error(err, "frameStop before frameStart accepted")
+
+	//The range ends at the largest frame without overflowing
+	vt.FrameStart = math.MaxInt64 - 1
+	vt.FrameStop = math.MaxInt64
+	vt.FrameStep = 1
+	vt.ChunkSize = 1
+	t5, err := vt.GetIndividualTasks()
+	assert.NoError(err)
+	assert.Equal(2, len(t5), "Bad number of frames at the end of the range")
+
+	//Too many frames or tiles of frames
+	vt.FrameStart = 0
+	vt.FrameStop = 2000000000
+	_, err = vt.GetIndividualTasks()
+	assert.Error(err, "Too many frames accepted")
+
+	vt.FrameStart = math.MinInt64
+	vt.FrameStop = math.MaxInt64
+	_, err = vt.GetIndividualTasks()
+	assert.Error(err, "Too many frames accepted")
+
+	vt.FrameStart = 0
+	vt.FrameStop = MaxFrames / 100
+	vt.Settings = Settings{TilesX: 10, TilesY: 10}
+	_, err = vt.GetIndividualTasks()
+	assert.Error(err, "Too many tiles of frames accepted")
+}
+
+func TestParseFrames(t *testing.T) {
+
+	assert := assert.New(t)
+
+	specs := []string{"1-10,15,20-40x5", "7", "3,1,2,3", " 4 - 6 , 8 ", "0-4x2", "10-20x30",
+		"9223372036854775806-9223372036854775807", "0-9223372036854775807x4611686018427387904", "0-99999",
+		"", "1,,2", "10-1", "5x2", "1-10x0", "a-3", "-3", "1-2-3", "+4",
+		"0-2000000000", "0-9223372036854775807", "0-100000", "0-50000,0-50000"}
+	expected := [][]int{
+		{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 15, 20, 25, 30, 35, 40},
+		{7},
+		{1, 2, 3},
+		{4, 5, 6, 8},
+		{0, 2, 4},
+		{10},
+		{9223372036854775806, 9223372036854775807},
+		{0, 4611686018427387904},
+		nil,
+		nil, nil, nil, nil, nil, nil, nil, nil, nil,
+		nil, nil, nil, nil,
+	}
+	expected[8] = make([]int, MaxFrames)
+	for i := range expected[8] {
+		expected[8][i] = i
+	}
+
+	for i := 0; i < len(specs); i++ {
+		frames, err := ParseFrames(specs[i])
+		assert.Equal(expected[i] == nil, err != nil, "Bad error for frames %q", specs[i])
+		assert.Equal(expected[i], frames, "Bad frames for %q", specs[i])
+	}
+}
+
+func TestSplit(t *testing.T) {
+
+	assert := assert.New(t)
+
+	task0 := &Task{
+		Project:         "test_p",
+		ID:              "test_id",
+		Frame:           10,
+		FrameEnd:        13,
+		State:           "rendering",
+		RendererName:    "test_renderer",
+		RendererVersion: "test_version",
+		Priority:        3,
+	}
+
+	assert.Nil(task0.Split(0), "Split a chunk without done frames")
+	assert.Nil(task0.Split(4), "Split a chunk with every frame done")
+
+	rest := task0.Split(3)
+	assert.Equal(10, task0.Frame, "Bad first frame of the done part")
+	assert.Equal(12, task0.LastFrame(), "Bad last frame of the done part")
+	assert.Equal("rendered", task0.State, "Bad state of the done part")
+	assert.Equal(13, rest.Frame, "Bad first frame of the remaining part")
+	assert.Equal(13, rest.LastFrame(), "Bad last frame of the remaining part")
+	assert.Equal(1, rest.Frames(), "Bad number of frames of the remaining part")
+	assert.Equal("waiting", rest.State, "Bad state of the remaining part")
+	assert.Equal(3, rest.Priority, "Fields not copied to the remaining part")
+
+	//Cut keeps the state of the chunk
+	task2 := &Task{Frame: 1, FrameEnd: 10, State: "rendered"}
+	assert.Nil(task2.Cut(1), "Cut before the first frame of a chunk")
+	assert.Nil(task2.Cut(11), "Cut after the last frame of a chunk")
+	cut := task2.Cut(4)
+	assert.Equal(3, task2.LastFrame(), "Bad last frame of the first part")
+	assert.Equal(4, cut.Frame, "Bad first frame of the cut part")
+	assert.Equal(10, cut.LastFrame(), "Bad last frame of the cut part")
+	assert.Equal("rendered", cut.State, "Bad state of the cut part")
+
+	//Tasks stored before chunks have no FrameEnd
+	task1 := &Task{Frame: 7}
+	assert.Equal(7, task1.LastFrame(), "Bad last frame of a single frame")
+	assert.Equal(1, task1.Frames(), "Bad number of frames of a single frame")
+}
+
+func TestFail(t *testing.T) {
+
+	assert := assert.New(t)
+
+	tsk := &Task{Frame: 1, FrameEnd: 4, State: "rendering"}
+
+	assert.Equal(false, tsk.Fail("a//1.2.3.4", "error on node a", 3), "Task failed before its last attempt")
+	assert.Equal(false, tsk.Fail("", "lease of node b expired", 3), "Task failed before its last attempt")
+	assert.Equal(true, tsk.FailedOn("a//1.2.3.4"), "Node of the failure not excluded")
+	assert.Equal(false, tsk.FailedOn("b//1.2.3.5"), "Lost node excluded")
+
+	//The remaining frames of a chunk keep the failures
+	rest := tsk.Split(2)
+	assert.Equal(2, rest.Attempts, "Attempts not kept by the remaining frames")
+	assert.Equal(true, rest.FailedOn("a//1.2.3.4"), "Failed nodes not kept by the remaining frames")
+
+	assert.Equal(true, rest.Fail("a//1.2.3.4", "error on node a", 3), "Task not failed after its last attempt")
+	assert.Equal("failed", rest.State, "Bad state of a failed task")
+	assert.Equal("error on node a", rest.LastError, "Bad last error")
+	assert.Equal([]string{"a//1.2.3.4"}, rest.FailedNodes, "Node excluded twice")
+
+	rest.Reset()
+	assert.Equal("waiting", rest.State, "Bad state of a reset task")
+	assert.Equal(0, rest.Attempts, "Attempts not reset")
+	assert.Equal(false, rest.FailedOn("a//1.2.3.4"), "Failed nodes not reset")
 }
 
 func TestMatchRenderer(t *testing.T) {
@@ -86,6 +297,26 @@ func TestMatchRenderer(t *testing.T) {
 
 	assert.Equal(&r1, rt.Renderer, "Bad renderer for project")
 	assert.Equal(&task0, rt.Task, "Task not returned in rendererTask")
+
+	//The highest patch of the LTS satisfying the constraint is chosen
+	r = []Renderer{
+		{Executable: "./blender-3.6.2", Name: "blender", Version: "3.6.2"},
+		{Executable: "./blender-3.6.9", Name: "blender", Version: "3.6.9"},
+		{Executable: "./blender-4.1.0", Name: "blender", Version: "4.1.0"},
+		{Executable: "./cycles-3.6.12", Name: "cycles", Version: "3.6.12"},
+	}
+	constraints := []string{"~3.6", ">=3.6 <4.0", ">=3.6", "3.6.2", "~3.6.10", "2.82"}
+	expected := []string{"./blender-3.6.9", "./blender-3.6.9", "./blender-4.1.0", "./blender-3.6.2", "", ""}
+
+	for i := 0; i < len(constraints); i++ {
+		task0.RendererVersion = constraints[i]
+		rt = task0.MatchRenderer(r)
+		if expected[i] == "" {
+			assert.Nil(rt, "Renderer found for constraint %s", constraints[i])
+		} else if assert.NotNil(rt, "No renderer found for constraint %s", constraints[i]) {
+			assert.Equal(expected[i], rt.Renderer.Executable, "Bad renderer for constraint %s", constraints[i])
+		}
+	}
 }
 
 func TestLaunchrender(t *testing.T) {
@@ -191,7 +422,9 @@ func TestCheckRender(t *testing.T) {
 	Fra:1 Mem:46.88M (Peak 46.90M) | Time:00:00.00 | Mem:0.00M, Peak:0.00M | Scene, View Layer | Synchronizing object | Cube
 	Fra:1 Mem:46.88M (Peak 46.90M) | Time:00:00.00 | Mem:0.00M, Peak:0.00M | Scene, View Layer | Initializing`)
 
-	test[0].State, test[0].Frac, test[0].Mem = rt0.CheckRender()
+	p0, err0 := rt0.CheckRender()
+	assert.NoError(err0)
+	test[0] = testCheck{p0.State, p0.Fraction, p0.Mem}
 
 	rt0.Log.WriteString(`Fra:1 Mem:49.99M (Peak 50.25M) | Time:00:00.04 | Remaining:00:00.82 | Mem:2.94M, Peak:3.06M | Scene, View Layer | Rendered 6/510 Tiles
 	Fra:1 Mem:49.99M (Peak 50.25M) | Time:00:00.04 | Remaining:00:00.83 | Mem:2.94M, Peak:3.06M | Scene, View Layer | Rendered 7/510 Tiles
@@ -204,7 +437,9 @@ func TestCheckRender(t *testing.T) {
 	Fra:1 Mem:49.99M (Peak 50.25M) | Time:00:00.05 | Remaining:00:00.78 | Mem:2.94M, Peak:3.06M | Scene, View Layer | Rendered 14/510 Tiles
 	Fra:1 Mem:49.99M (Peak 50.25M) | Time:00:00.05 | Remaining:00:00.78 | Mem:2.94M, Peak:3.06M | Scene, View Layer | Rendered 51/510 Tiles`)
 
-	test[1].State, test[1].Frac, test[1].Mem = rt0.CheckRender()
+	p1, err1 := rt0.CheckRender()
+	assert.NoError(err1)
+	test[1] = testCheck{p1.State, p1.Fraction, p1.Mem}
 
 	rt0.Log.WriteString(`Fra:1 Mem:48.58M (Peak 50.25M) | Time:00:01.50 | Mem:1.53M, Peak:3.06M | Scene, View Layer | Finished
 	Fra:1 Mem:46.97M (Peak 50.25M) | Time:00:01.50 | Sce: Scene Ve:0 Fa:0 La:0
@@ -214,7 +449,339 @@ func TestCheckRender(t *testing.T) {
 	
 	Blender quit`)
 
-	test[2].State, test[2].Frac, test[2].Mem = rt0.CheckRender()
+	p2, err2 := rt0.CheckRender()
+	assert.NoError(err2)
+	test[2] = testCheck{p2.State, p2.Fraction, p2.Mem}
 
 	assert.Equal(ref, test, "Not returned the good types after checkState")
 }
+
+func TestGetEngine(t *testing.T) {
+	assert := assert.New(t)
+
+	names := []string{"blender", "command", "povray"}
+	expectedFound := []bool{true, true, false}
+
+	for i := 0; i < len(names); i++ {
+		_, ok := GetEngine(names[i])
+		assert.Equal(expectedFound[i], ok, "Bad engine lookup in test %d", i)
+	}
+
+	r0 := Renderer{
+		Executable: "povray",
+		Name:       "povray",
+		Version:    "3.7",
+	}
+
+	rt0 := RendererTask{
+		Task:     &Task{Frame: 1},
+		Renderer: &r0,
+	}
+
+	_, err := rt0.LaunchRender()
+	assert.EqualError(err, "no matching renderer")
+
+	r0.Engine = "command"
+	_, ok := rt0.engine()
+	assert.Equal(true, ok, "Engine field not used to find the engine")
+}
+
+func TestCommandEngine(t *testing.T) {
+	assert := assert.New(t)
+
+	os.MkdirAll("tmp", os.ModePerm)
+	defer os.RemoveAll("tmp")
+
+	r0 := Renderer{
+		Executable: "sh",
+		Name:       "script",
+		Version:    "1.0",
+		Engine:     "command",
+		Args:       []string{"-c", "echo 'Progress 3/4' && touch {output}{paddedFrame}.png", "{input}", "{frame}"},
+		Progress:   `Progress (\d+)/(\d+)`,
+	}
+
+	task0 := Task{
+		Project:         "test_p",
+		ID:              "test_id",
+		Input:           filepath.Join("tmp", "scene.pov"),
+		Output:          filepath.Join("tmp", "scene_"),
+		Frame:           12,
+		State:           "rendering",
+		RendererName:    "script",
+		RendererVersion: "1.0",
+	}
+
+	rt0 := RendererTask{
+		Task:     &task0,
+		Renderer: &r0,
+	}
+
+	cmd, err := rt0.LaunchRender()
+	assert.NoError(err)
+	assert.Equal([]string{"sh", "-c", "echo 'Progress 3/4' && touch tmp/scene_00012.png", filepath.Join("tmp", "scene.pov"), "12"}, cmd.Args, "Bad command built from template")
+	assert.Equal(filepath.Join("tmp", "scene_00012.png"), rt0.OutputFile(12), "Bad default output file")
+
+	err = rt0.Wait()
+	assert.NoError(err)
+
+	p, err := rt0.CheckRender()
+	assert.NoError(err)
+	assert.Equal("rendered", p.State, "Bad state after command exited")
+	assert.Equal(1.0, p.Fraction, "Bad fraction after command exited")
+
+	rt1 := RendererTask{
+		Task:     &task0,
+		Renderer: &r0,
+	}
+	rt1.Log.WriteString("Progress 1/4\nProgress 3/4\n")
+
+	p, err = rt1.CheckRender()
+	assert.NoError(err)
+	assert.Equal("rendering", p.State, "Bad state while command is running")
+	assert.Equal(0.75, p.Fraction, "Bad fraction parsed from progress regexp")
+
+	r1 := r0
+	r1.Args = nil
+	rt2 := RendererTask{
+		Task:     &task0,
+		Renderer: &r1,
+	}
+	_, err = rt2.LaunchRender()
+	assert.Error(err, "Launched a command renderer without arguments")
+
+	//A chunk can only be rendered by a command using {frameEnd}
+	task1 := Task{
+		Input:    task0.Input,
+		Output:   task0.Output,
+		Frame:    12,
+		FrameEnd: 14,
+	}
+	rt3 := RendererTask{
+		Task:     &task1,
+		Renderer: &r0,
+	}
+	_, err = commandEngine{}.Command(&rt3)
+	assert.Error(err, "Rendered a chunk with a command rendering a single frame")
+
+	r2 := r0
+	r2.Args = []string{"-c", "for f in $(seq $0 $1); do touch {output}$(printf %05d $f).png; done", "{frameStart}", "{frameEnd}"}
+	rt4 := RendererTask{
+		Task:     &task1,
+		Renderer: &r2,
+	}
+	cmd, err = rt4.LaunchRender()
+	assert.NoError(err)
+	assert.Equal([]string{"sh", "-c", "for f in $(seq $0 $1); do touch " + filepath.Join("tmp", "scene_") + "$(printf %05d $f).png; done", "12", "14"}, cmd.Args, "Bad command built for a chunk")
+	assert.Equal(filepath.Join("tmp", "scene_00014.png"), rt4.OutputFile(14), "Bad output file of a frame of the chunk")
+	assert.NoError(rt4.Wait())
+
+	p, err = rt4.CheckRender()
+	assert.NoError(err)
+	assert.Equal("rendered", p.State, "Bad state after the command rendered the chunk")
+}
+
+func TestBlenderSettings(t *testing.T) {
+	assert := assert.New(t)
+
+	r0 := Renderer{
+		Executable: "blender",
+		Name:       "blender",
+		Version:    "2.91.0",
+	}
+
+	settings := []Settings{
+		{},
+		{
+			Scene:             "Preview",
+			Camera:            "CamA",
+			ResolutionX:       1920,
+			ResolutionY:       1080,
+			ResolutionPercent: 50,
+			Samples:           64,
+			Format:            "EXR",
+			ColorDepth:        "32",
+		},
+	}
+
+	script := "import bpy\nscene = bpy.context.scene\n" +
+		"scene.camera = bpy.data.objects[\"CamA\"]\n" +
+		"scene.render.resolution_x = 1920\n" +
+		"scene.render.resolution_y = 1080\n" +
+		"scene.render.resolution_percentage = 50\n" +
+		"if scene.render.engine == 'CYCLES':\n" +
+		"    scene.cycles.samples = 64\n" +
+		"else:\n" +
+		"    scene.eevee.taa_render_samples = 64\n" +
+		"scene.render.image_settings.color_depth = \"32\"\n"
+
+	tileScript := "import bpy\nscene = bpy.context.scene\n" +
+		"scene.render.use_border = True\n" +
+		"scene.render.use_crop_to_border = True\n" +
+		"scene.render.border_min_x = 0.5\n" +
+		"scene.render.border_max_x = 1\n" +
+		"scene.render.border_min_y = 0\n" +
+		"scene.render.border_max_y = 0.5\n"
+
+	settings = append(settings, Settings{}, Settings{TilesX: 2, TilesY: 2})
+	frameEnd := []int{0, 3, 6, 0}
+	tiles := []int{0, 0, 0, 3}
+
+	expectedArgs := [][]string{
+		{"blender", "-b", "-noaudio", "cube.blend", "-o", "out_#####", "-F", "PNG", "-f", "3"},
+		{"blender", "-b", "-noaudio", "cube.blend", "-S", "Preview", "-o", "out_#####", "-F", "EXR", "--python-expr", script, "-f", "3"},
+		{"blender", "-b", "-noaudio", "cube.blend", "-o", "out_#####", "-F", "PNG", "-s", "3", "-e", "6", "-a"},
+		{"blender", "-b", "-noaudio", "cube.blend", "-o", "out_#####_tile3", "-F", "PNG", "--python-expr", tileScript, "-f", "3"},
+	}
+	expectedOutput := []string{"out_00003.png", "out_00003.exr", "out_00003.png", "out_00003_tile3.png"}
+
+	for i := 0; i < len(settings); i++ {
+		rt := RendererTask{
+			Task: &Task{
+				Input:    "cube.blend",
+				Output:   "out_",
+				Frame:    3,
+				FrameEnd: frameEnd[i],
+				Tile:     tiles[i],
+				Settings: settings[i],
+			},
+			Renderer: &r0,
+		}
+
+		cmd, err := blenderEngine{}.Command(&rt)
+		assert.NoError(err)
+		assert.Equal(expectedArgs[i], cmd.Args, "Bad blender arguments in test %d", i)
+		assert.Equal(expectedOutput[i], rt.OutputFile(3), "Bad output file in test %d", i)
+	}
+
+	invalid := []Settings{
+		{Format: "BMP"},
+		{Format: "JPEG", ColorDepth: "16"},
+		{ColorDepth: "32"},
+		{ResolutionX: -1},
+		{ResolutionPercent: 150},
+		{Samples: -4},
+		{TilesX: -2},
+		{TilesX: 2, Format: "EXR"},
+	}
+
+	for i, s := range invalid {
+		assert.Error(s.Validate(), "Invalid settings accepted in test %d", i)
+	}
+	assert.NoError(settings[1].Validate())
+}
+
+func TestParseBlenderLog(t *testing.T) {
+	assert := assert.New(t)
+
+	logs, err := filepath.Glob(filepath.Join("..", "..", "testdata", "render_tests", "blender_logs", "*.log"))
+	assert.NoError(err)
+	assert.NotEqual(0, len(logs), "No blender logs to test")
+
+	for _, l := range logs {
+		content, err := ioutil.ReadFile(l)
+		assert.NoError(err)
+
+		p, err := ParseBlenderLog(string(content))
+		assert.NoError(err, "Error while parsing %s", filepath.Base(l))
+
+		golden := strings.TrimSuffix(l, ".log") + ".golden"
+		if *update {
+			js, err := json.MarshalIndent(p, "", "    ")
+			assert.NoError(err)
+			err = ioutil.WriteFile(golden, append(js, '\n'), 0644)
+			assert.NoError(err)
+		}
+
+		js, err := ioutil.ReadFile(golden)
+		assert.NoError(err, "Couldn't read golden file of %s", l)
+
+		var expected Progress
+		err = json.Unmarshal(js, &expected)
+		assert.NoError(err)
+		assert.Equal(expected, p, "Bad progress parsed from %s", filepath.Base(l))
+	}
+}
+
+func TestParseBlenderLogErrors(t *testing.T) {
+	assert := assert.New(t)
+
+	logs := []string{
+		"Fra:1 Mem:1.2G (Peak 1.5G) | Time:00:00.40 | Rendering 1 / 64 samples",
+		"Fra:1 Mem:512.00K (Peak 1.00M) | Time:00:00.40 | Mem:1.2G, Peak:2.0G | Scene, ViewLayer | Sample 32/128",
+		"Fra:1 Mem:1.2.3M (Peak 1.5M) | Time:00:00.40 | Rendering 1 / 64 samples\nFra:1 Mem:20.00M (Peak 21.00M) | Time:00:00.80 | Rendering 32 / 64 samples",
+		"Fra:1 Mem:20.00M (Peak 21.00M) | Time:00:00.40 | Rendering 32 / 0 samples",
+	}
+
+	expectedMem := []float64{1228.8, 1228.8, 20, 20}
+	expectedPeak := []float64{1536, 2048, 21, 21}
+	expectedFraction := []float64{1.0 / 64, 0.25, 0.5, 0}
+	expectedError := []bool{false, false, true, true}
+
+	for i := 0; i < len(logs); i++ {
+		p, err := ParseBlenderLog(logs[i])
+
+		assert.Equal(expectedError[i], err != nil, "Bad error returned in test %d : %v", i, err)
+		assert.Equal("rendering", p.State, "Bad state in test %d", i)
+		assert.InDelta(expectedMem[i], p.Mem, 1e-9, "Bad memory in test %d", i)
+		assert.InDelta(expectedPeak[i], p.Peak, 1e-9, "Bad peak memory in test %d", i)
+		assert.Equal(expectedFraction[i], p.Fraction, "Bad fraction in test %d", i)
+	}
+}
+
+func TestStitch(t *testing.T) {
+	assert := assert.New(t)
+
+	os.MkdirAll("tmp", os.ModePerm)
+	defer os.RemoveAll("tmp")
+
+	//Tiles of a 5x3 frame split in 2 columns and 2 rows, the last ones being smaller
+	sizes := [][2]int{{3, 2}, {2, 2}, {3, 1}, {2, 1}}
+	colors := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 255, 128}}
+
+	files := []string{}
+	for i, s := range sizes {
+		img := image.NewNRGBA(image.Rect(0, 0, s[0], s[1]))
+		for x := 0; x < s[0]; x++ {
+			for y := 0; y < s[1]; y++ {
+				img.SetNRGBA(x, y, colors[i])
+			}
+		}
+		f := filepath.Join("tmp", "out_00001_tile"+strconv.Itoa(i)+".png")
+		w, err := os.Create(f)
+		assert.NoError(err)
+		assert.NoError(png.Encode(w, img))
+		w.Close()
+		files = append(files, f)
+	}
+
+	out := filepath.Join("tmp", "out_00001.png")
+	assert.NoError(Stitch(files, 2, out))
+
+	r, err := os.Open(out)
+	assert.NoError(err)
+	defer r.Close()
+	img, err := png.Decode(r)
+	assert.NoError(err)
+
+	assert.Equal(image.Rect(0, 0, 5, 3), img.Bounds(), "Bad size of the stitched frame")
+	points := []image.Point{{0, 0}, {2, 1}, {3, 0}, {4, 1}, {0, 2}, {2, 2}, {3, 2}, {4, 2}}
+	expected := []color.NRGBA{colors[0], colors[0], colors[1], colors[1], colors[2], colors[2], colors[3], colors[3]}
+	for i, p := range points {
+		assert.Equal(expected[i], color.NRGBAModel.Convert(img.At(p.X, p.Y)), "Bad pixel at %v", p)
+	}
+
+	//16 bits tiles stay in 16 bits
+	deep := []image.Image{image.NewNRGBA64(image.Rect(0, 0, 2, 2)), image.NewNRGBA(image.Rect(0, 0, 2, 2))}
+	st, err := StitchImages(deep, 2)
+	assert.NoError(err)
+	assert.Equal(color.NRGBA64Model, st.ColorModel(), "Bad color model of 16 bits tiles")
+
+	//Tiles that don't fit in the grid
+	_, err = StitchImages([]image.Image{image.NewNRGBA(image.Rect(0, 0, 2, 2)), image.NewNRGBA(image.Rect(0, 0, 2, 3))}, 2)
+	assert.Error(err, "Stitched tiles of a row with different heights")
+	_, err = StitchImages(deep, 3)
+	assert.Error(err, "Stitched tiles in a grid they don't fill")
+	assert.Error(Stitch(files[:3], 2, out), "Stitched missing tiles")
+	assert.Error(Stitch([]string{files[0], filepath.Join("tmp", "missing.png")}, 2, out), "Stitched a missing file")
+}
@@ -0,0 +1,197 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/LeoMarche/blenderer/src/utils"
+)
+
+//Settings are the render settings of a job overriding the ones saved in its input file, zero values keep the saved ones.
+//TilesX and TilesY split each frame in a grid of regions rendered by different tasks, 0 or 1 keeping it whole.
+type Settings struct {
+	Scene             string `json:"scene"`
+	Camera            string `json:"camera"`
+	ResolutionX       int    `json:"resolutionX"`
+	ResolutionY       int    `json:"resolutionY"`
+	ResolutionPercent int    `json:"resolutionPercent"`
+	Samples           int    `json:"samples"`
+	Format            string `json:"format"`
+	ColorDepth        string `json:"colorDepth"`
+	TilesX            int    `json:"tilesX"`
+	TilesY            int    `json:"tilesY"`
+}
+
+//formatExtensions are the accepted output formats and the extension of their files
+var formatExtensions = map[string]string{
+	"PNG":  ".png",
+	"EXR":  ".exr",
+	"JPEG": ".jpg",
+	"TIFF": ".tif",
+}
+
+//formatDepths are the color depths accepted by each output format
+var formatDepths = map[string][]string{
+	"PNG":  {"8", "16"},
+	"EXR":  {"16", "32"},
+	"JPEG": {"8"},
+	"TIFF": {"8", "16"},
+}
+
+//OutputFormat returns the output format of the settings, PNG by default
+func (s *Settings) OutputFormat() string {
+	if s.Format == "" {
+		return "PNG"
+	}
+	return s.Format
+}
+
+//Extension returns the extension of the files written with the output format of the settings
+func (s *Settings) Extension() string {
+	return formatExtensions[s.OutputFormat()]
+}
+
+//TileGrid returns the number of columns and rows of tiles each frame is split into
+func (s *Settings) TileGrid() (int, int) {
+	columns, rows := s.TilesX, s.TilesY
+	if columns < 1 {
+		columns = 1
+	}
+	if rows < 1 {
+		rows = 1
+	}
+	return columns, rows
+}
+
+//Tiles returns the number of tiles each frame is split into, 1 if it isn't
+func (s *Settings) Tiles() int {
+	columns, rows := s.TileGrid()
+	return columns * rows
+}
+
+//Tiled returns true if each frame is split into several tiles
+func (s *Settings) Tiled() bool {
+	return s.Tiles() > 1
+}
+
+//Border returns the region rendered by a tile as fractions of the width and height of the frame.
+//The tiles are numbered row by row from the top left corner, the y axis of blender going up.
+func (s *Settings) Border(tile int) (float64, float64, float64, float64) {
+	columns, rows := s.TileGrid()
+	column, row := tile%columns, tile/columns
+
+	minX := float64(column) / float64(columns)
+	maxX := float64(column+1) / float64(columns)
+	minY := float64(rows-row-1) / float64(rows)
+	maxY := float64(rows-row) / float64(rows)
+	return minX, maxX, minY, maxY
+}
+
+//tileSuffix returns the suffix of the images of the tile, empty if the frames aren't split
+func (s *Settings) tileSuffix(tile int) string {
+	if !s.Tiled() {
+		return ""
+	}
+	return "_tile" + strconv.Itoa(tile)
+}
+
+//Validate returns an error if the settings can't be applied
+func (s *Settings) Validate() error {
+	if _, ok := formatExtensions[s.OutputFormat()]; !ok {
+		return fmt.Errorf("unknown output format %s", s.Format)
+	}
+	if s.ColorDepth != "" && utils.IsIn(s.ColorDepth, formatDepths[s.OutputFormat()]) == -1 {
+		return fmt.Errorf("color depth %s isn't available for format %s", s.ColorDepth, s.OutputFormat())
+	}
+	if s.ResolutionX < 0 || s.ResolutionY < 0 {
+		return errors.New("resolution must be positive")
+	}
+	if s.ResolutionPercent < 0 || s.ResolutionPercent > 100 {
+		return errors.New("resolution percentage must be between 1 and 100")
+	}
+	if s.Samples < 0 {
+		return errors.New("samples must be positive")
+	}
+	if s.TilesX < 0 || s.TilesY < 0 {
+		return errors.New("number of tiles must be positive")
+	}
+	if s.Tiled() && s.OutputFormat() != "PNG" {
+		return fmt.Errorf("tiles can't be stitched in format %s, only in PNG", s.OutputFormat())
+	}
+	return nil
+}
+
+//pythonString returns s as a python string literal
+func pythonString(s string) string {
+	return strconv.Quote(s)
+}
+
+//pythonFloat returns f as a python float literal
+func pythonFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+//blenderOverrideScript returns the python script applying the settings blender has no arguments for,
+//or an empty string if there is nothing to apply. The region of the tile is rendered if the frames are split.
+func (s *Settings) blenderOverrideScript(tile int) string {
+	var lines []string
+
+	if s.Camera != "" {
+		lines = append(lines, "scene.camera = bpy.data.objects["+pythonString(s.Camera)+"]")
+	}
+	if s.ResolutionX > 0 {
+		lines = append(lines, "scene.render.resolution_x = "+strconv.Itoa(s.ResolutionX))
+	}
+	if s.ResolutionY > 0 {
+		lines = append(lines, "scene.render.resolution_y = "+strconv.Itoa(s.ResolutionY))
+	}
+	if s.ResolutionPercent > 0 {
+		lines = append(lines, "scene.render.resolution_percentage = "+strconv.Itoa(s.ResolutionPercent))
+	}
+	if s.Samples > 0 {
+		lines = append(lines,
+			"if scene.render.engine == 'CYCLES':",
+			"    scene.cycles.samples = "+strconv.Itoa(s.Samples),
+			"else:",
+			"    scene.eevee.taa_render_samples = "+strconv.Itoa(s.Samples))
+	}
+	if s.ColorDepth != "" {
+		lines = append(lines, "scene.render.image_settings.color_depth = "+pythonString(s.ColorDepth))
+	}
+	if s.Tiled() {
+		minX, maxX, minY, maxY := s.Border(tile)
+		lines = append(lines,
+			"scene.render.use_border = True",
+			"scene.render.use_crop_to_border = True",
+			"scene.render.border_min_x = "+pythonFloat(minX),
+			"scene.render.border_max_x = "+pythonFloat(maxX),
+			"scene.render.border_min_y = "+pythonFloat(minY),
+			"scene.render.border_max_y = "+pythonFloat(maxY))
+	}
+
+	if len(lines) == 0 {
+		return ""
+	}
+
+	return "import bpy\nscene = bpy.context.scene\n" + strings.Join(lines, "\n") + "\n"
+}
+
+//blenderArgs returns the blender arguments applying the settings to the given tile, to be put between the input file
+//and the frames to render
+func (s *Settings) blenderArgs(output string, tile int) []string {
+	var args []string
+
+	if s.Scene != "" {
+		args = append(args, "-S", s.Scene)
+	}
+
+	args = append(args, "-o", output+"#####"+s.tileSuffix(tile), "-F", s.OutputFormat())
+
+	if script := s.blenderOverrideScript(tile); script != "" {
+		args = append(args, "--python-expr", script)
+	}
+
+	return args
+}
@@ -0,0 +1,96 @@
+package render
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"os"
+)
+
+//StitchImages assembles tiles given row by row from the top left corner in a grid of the given number of columns.
+//The tiles of a row must have the same height and the ones of a column the same width.
+func StitchImages(tiles []image.Image, columns int) (image.Image, error) {
+	if columns < 1 || len(tiles) == 0 || len(tiles)%columns != 0 {
+		return nil, fmt.Errorf("%d tiles can't be split in %d columns", len(tiles), columns)
+	}
+	rows := len(tiles) / columns
+
+	//Position of each column and row in the stitched image
+	xs := make([]int, columns+1)
+	ys := make([]int, rows+1)
+	for c := 0; c < columns; c++ {
+		xs[c+1] = xs[c] + tiles[c].Bounds().Dx()
+	}
+	for r := 0; r < rows; r++ {
+		ys[r+1] = ys[r] + tiles[r*columns].Bounds().Dy()
+	}
+
+	//Keep 16 bits per channel if a tile has them
+	bounds := image.Rect(0, 0, xs[columns], ys[rows])
+	var out draw.Image = image.NewNRGBA(bounds)
+	for _, t := range tiles {
+		if m := t.ColorModel(); m == color.NRGBA64Model || m == color.RGBA64Model || m == color.Gray16Model {
+			out = image.NewNRGBA64(bounds)
+			break
+		}
+	}
+
+	for i, t := range tiles {
+		c, r := i%columns, i/columns
+		if t.Bounds().Dx() != xs[c+1]-xs[c] || t.Bounds().Dy() != ys[r+1]-ys[r] {
+			return nil, fmt.Errorf("tile %d is %dx%d, it doesn't fit in column %d and row %d", i, t.Bounds().Dx(), t.Bounds().Dy(), c, r)
+		}
+		draw.Draw(out, image.Rect(xs[c], ys[r], xs[c+1], ys[r+1]), t, t.Bounds().Min, draw.Src)
+	}
+
+	return out, nil
+}
+
+//Stitch assembles the PNG images of the tiles of a frame into the PNG image out, see StitchImages
+func Stitch(tiles []string, columns int, out string) error {
+	if len(tiles) == 0 {
+		return errors.New("no tile to stitch")
+	}
+
+	images := make([]image.Image, len(tiles))
+	for i, t := range tiles {
+		img, err := decodePNG(t)
+		if err != nil {
+			return err
+		}
+		images[i] = img
+	}
+
+	img, err := StitchImages(images, columns)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+//decodePNG reads the PNG image of a file
+func decodePNG(file string) (image.Image, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	img, err := png.Decode(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tile %s : %w", file, err)
+	}
+	return img, nil
+}
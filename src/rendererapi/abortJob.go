@@ -7,10 +7,12 @@ import (
 	"sync"
 
 	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/LeoMarche/blenderer/src/rendererdb"
 )
 
 //AbortJob is handler for aborting jobs
-//The request must be a post with api_key, id
+//The request must be a post with api_key, id. The frames not rendered nor failed yet are put in "abort" state, the
+//nodes rendering the job being told to stop with "ABORT" when they update it, see /updateJob.
 func (ws *WorkingSet) AbortJob(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" || r.URL.Path != "/abortJob" {
 		http.Error(w, "404 not found.", http.StatusNotFound)
@@ -58,9 +60,15 @@ func (ws *WorkingSet) AbortJob(w http.ResponseWriter, r *http.Request) {
 	tmpMap, ok := ws.Tasks.Load(r.FormValue("id"))
 	if ok {
 
-		// Putting all job in state abort
+		// Putting the unfinished frames of the job in state abort, the frames being rendered stay in the renders until
+		// their node is told to stop by /updateJob
 		tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
-			value.(*render.Task).SetState("abort")
+			if abort(value.(*render.Task)) {
+				ws.DBTransacts.Add(&rendererdb.DBTransact{
+					OP:       rendererdb.UPDATETASK,
+					Argument: value.(*render.Task),
+				})
+			}
 			return true
 		})
 		st = "OK"
@@ -68,9 +76,6 @@ func (ws *WorkingSet) AbortJob(w http.ResponseWriter, r *http.Request) {
 		st = "error: can't find job"
 	}
 
-	//Optionnaly remove the jobs that are being rendered
-	ws.Renders.Delete(r.FormValue(("id")))
-
 	w.Header().Set("Content-Type", "application/json")
 	js, err := json.Marshal(ReturnValue{
 		State: st,
@@ -83,3 +88,15 @@ func (ws *WorkingSet) AbortJob(w http.ResponseWriter, r *http.Request) {
 
 	w.Write(js)
 }
+
+//abort puts t in "abort" state unless it is rendered, failed or already aborted and returns true if it did
+func abort(t *render.Task) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.State == "rendered" || t.State == "failed" || t.State == "abort" {
+		return false
+	}
+	t.State = "abort"
+	return true
+}
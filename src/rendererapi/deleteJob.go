@@ -0,0 +1,108 @@
+package rendererapi
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/LeoMarche/blenderer/src/rendererdb"
+	"github.com/LeoMarche/blenderer/src/utils"
+)
+
+//DeleteJob is handler for deleting jobs along with their files
+//The request must be a post with api_key, id and optionally force. The job is removed from the tasks, the renders and
+//the database, its transfer tokens are revoked and its folder is removed from the file server. It is refused while
+//frames of the job are being rendered unless force is true, their nodes being freed and told to stop with "DELETED"
+//when they update it.
+func (ws *WorkingSet) DeleteJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/deleteJob" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+
+	if r.FormValue("api_key") == "" || isIn(r.FormValue("api_key"), ws.Config.UserAPIKeys) == -1 {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	id := r.FormValue("id")
+	if id == "" {
+		sendState(w, http.StatusBadRequest, "Error : Missing Parameter")
+		return
+	}
+	folder, err := utils.SafeJoin(ws.Config.Folder, id, "")
+	if err != nil {
+		sendState(w, http.StatusBadRequest, "Error : "+err.Error())
+		return
+	}
+
+	force, err := strconv.ParseBool(r.FormValue("force"))
+	if r.FormValue("force") != "" && err != nil {
+		sendState(w, http.StatusBadRequest, "Error : invalid parameter 'force'")
+		return
+	}
+
+	tmpMap, ok := ws.Tasks.Load(id)
+	if !ok {
+		sendState(w, http.StatusNotFound, "Error : can't find job")
+		return
+	}
+
+	rendering := false
+	if rendersMap, ok := ws.Renders.Load(id); ok {
+		rendersMap.(*sync.Map).Range(func(key, value interface{}) bool {
+			rendering = true
+			return false
+		})
+	}
+	if rendering && !force {
+		sendState(w, http.StatusConflict, "Error : frames of the job are being rendered")
+		return
+	}
+
+	//The scheduler skips the frames that aren't waiting anymore
+	ws.deleted.Store(id, true)
+	ws.Tasks.Delete(id)
+	ws.Paused.Delete(id)
+	tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+		value.(*render.Task).SetState("deleted")
+		return true
+	})
+
+	if rendersMap, ok := ws.Renders.LoadAndDelete(id); ok {
+		rendersMap.(*sync.Map).Range(func(key, value interface{}) bool {
+			rd := value.(*Render)
+			ws.Scheduler.Release(rd.myTask)
+			rd.myNode.Free()
+			ws.DBTransacts.Add(&rendererdb.DBTransact{
+				OP:       rendererdb.UPDATENODE,
+				Argument: rd.myNode,
+			})
+			return true
+		})
+	}
+
+	ws.DBTransacts.Add(&rendererdb.DBTransact{
+		OP:       rendererdb.DELETEPROJECT,
+		Argument: id,
+	})
+
+	//The nodes and clients of the job can't exchange its files anymore
+	ws.revokeTransfers(id)
+
+	//Only the folders of the jobs found in the tasks are removed
+	if err := os.RemoveAll(folder); err != nil {
+		sendState(w, http.StatusInternalServerError, "Error : couldn't remove the files of the job : "+err.Error())
+		return
+	}
+
+	sendState(w, http.StatusOK, "OK")
+}
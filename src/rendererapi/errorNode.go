@@ -12,6 +12,8 @@ import (
 )
 
 //ErrorNode handler for /errorNode
+//The request must be a post with api_key, name and optionally error, the error that occurred on the node.
+//The renders of the node count as failed attempts and aren't given to it again.
 func (ws *WorkingSet) ErrorNode(w http.ResponseWriter, r *http.Request) {
 
 	if err := r.ParseForm(); err != nil {
@@ -36,7 +38,7 @@ func (ws *WorkingSet) ErrorNode(w http.ResponseWriter, r *http.Request) {
 	} else {
 		// Set Node in error and put back the task in waiting state
 		tmpNode.(*node.Node).SetState("error")
-		ws.DBTransacts.Add(rendererdb.DBTransact{
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
 			OP:       rendererdb.UPDATENODE,
 			Argument: tmpNode.(*node.Node),
 		})
@@ -58,6 +60,11 @@ func (ws *WorkingSet) ErrorNode(w http.ResponseWriter, r *http.Request) {
 			return true
 		})
 
+		reason := "error on node " + r.FormValue("name")
+		if r.FormValue("error") != "" {
+			reason += " : " + r.FormValue("error")
+		}
+
 		// Delete concerned renders from the render list
 		for key, value := range rendersToDelete {
 			m, ok := ws.Renders.Load(key)
@@ -67,13 +74,17 @@ func (ws *WorkingSet) ErrorNode(w http.ResponseWriter, r *http.Request) {
 					m.(*sync.Map).Delete(key2)
 					if ok {
 
-						//Set the state of the renders the node was doing
-						deletedRT.(*Render).myTask.SetState("waiting")
-
-						ws.DBTransacts.Add(&rendererdb.DBTransact{
-							OP:       rendererdb.UPDATETASK,
-							Argument: deletedRT.(*Render).myTask,
-						})
+						//Put back the renders the node was doing in the waiting tasks, the aborted and paused frames
+						//being only released
+						rd := deletedRT.(*Render)
+						rd.myTask.Lock()
+						requeued := rd.myTask.State == "rendering"
+						rd.myTask.Unlock()
+						if requeued {
+							ws.requeue(rd, &failure{node: rd.myNode.Key(), reason: reason})
+						} else {
+							ws.Scheduler.Release(rd.myTask)
+						}
 					}
 				}
 			}
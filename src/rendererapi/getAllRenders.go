@@ -35,22 +35,38 @@ func (ws *WorkingSet) GetAllRenderTasks(w http.ResponseWriter, r *http.Request)
 
 	id := -1
 
-	//Count all waiting, uploading and completed frames
+	//Count all waiting, uploading, paused, failed and completed frames, a chunk counting for all its frames.
+	//The paused frames whose node wasn't told to stop yet are counted with the rendering ones.
 	ws.Tasks.Range(func(k, v interface{}) bool {
+		rendersMap, _ := ws.Renders.Load(k)
 		v.(*sync.Map).Range(func(k2, v2 interface{}) bool {
+			if rendersMap != nil {
+				if rd, ok := rendersMap.(*sync.Map).Load(k2); ok && rd.(*Render).myTask == v2.(*render.Task) {
+					return true
+				}
+			}
 			id = -1
 			id = isIn(v2.(*render.Task).ID, *lid)
 			if id == -1 {
-				newTTS := TaskToSend{v2.(*render.Task).Project, v2.(*render.Task).ID, 0.0, 0, v2.(*render.Task).StartTime}
+				newTTS := TaskToSend{v2.(*render.Task).Project, v2.(*render.Task).ID, 0.0, 0, v2.(*render.Task).StartTime, "", 0}
 				*ret = append(*ret, newTTS)
 				*lid = append(*lid, v2.(*render.Task).ID)
 				id = len(*lid) - 1
 			}
 			if v2.(*render.Task).State == "uploading" || v2.(*render.Task).State == "waiting" {
-				(*ret)[id].Nb++
+				(*ret)[id].Nb += v2.(*render.Task).Frames()
+				if (*ret)[id].Status == "" && !ws.capable(v2.(*render.Task)) {
+					(*ret)[id].Status = NoCapableNode
+				}
+			} else if v2.(*render.Task).State == "paused" {
+				(*ret)[id].Nb += v2.(*render.Task).Frames()
+				(*ret)[id].Status = Paused
 			} else if v2.(*render.Task).State == "rendered" {
-				(*ret)[id].Nb++
-				(*ret)[id].Percent++
+				(*ret)[id].Nb += v2.(*render.Task).Frames()
+				(*ret)[id].Percent += float64(v2.(*render.Task).Frames())
+			} else if v2.(*render.Task).State == "failed" {
+				(*ret)[id].Nb += v2.(*render.Task).Frames()
+				(*ret)[id].Failed += v2.(*render.Task).Frames()
 			}
 			return true
 		})
@@ -64,22 +80,25 @@ func (ws *WorkingSet) GetAllRenderTasks(w http.ResponseWriter, r *http.Request)
 			id = -1
 			id = isIn(rdr.myTask.ID, *lid)
 			if id == -1 {
-				newTTS := TaskToSend{rdr.myTask.Project, rdr.myTask.ID, 0.0, 0, rdr.myTask.StartTime}
+				newTTS := TaskToSend{rdr.myTask.Project, rdr.myTask.ID, 0.0, 0, rdr.myTask.StartTime, "", 0}
 				*ret = append(*ret, newTTS)
 				*lid = append(*lid, rdr.myTask.ID)
 				id = len(*lid) - 1
 			}
-			(*ret)[id].Nb++
+			(*ret)[id].Nb += rdr.myTask.Frames()
 			var s float64
 			s, _ = strconv.ParseFloat(rdr.Percent, 64)
-			(*ret)[id].Percent += s
+			(*ret)[id].Percent += s * float64(rdr.myTask.Frames())
 			return true
 		})
 		return true
 	})
 
+	//Jobs whose frames are all aborted have no frame to count
 	for i := 0; i < len(*ret); i++ {
-		(*ret)[i].Percent = (*ret)[i].Percent / float64((*ret)[i].Nb)
+		if (*ret)[i].Nb > 0 {
+			(*ret)[i].Percent = (*ret)[i].Percent / float64((*ret)[i].Nb)
+		}
 	}
 
 	js, err := json.Marshal(*ret)
@@ -0,0 +1,135 @@
+package rendererapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/filexchange"
+	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/LeoMarche/blenderer/src/utils"
+)
+
+//FrameStatus describes a task of a job, rendering the chunk of frames from Frame to FrameEnd or the Tile of Frame.
+//Attempts is the number of times it failed, the last time with LastError.
+//Outputs are the frames rendered by the task that can be downloaded, a frame stitched from tiles being given with its
+//first tile.
+type FrameStatus struct {
+	Frame     int
+	FrameEnd  int
+	Tile      int
+	State     string
+	Attempts  int
+	LastError string
+	Outputs   []Output
+}
+
+//Output is a file rendered for a job on the file server, with its SHA-256
+type Output struct {
+	Name     string
+	Checksum string
+}
+
+//GetFrames is handler for listing the tasks of a job with their failures
+//The request must be a post with api_key and id
+func (ws *WorkingSet) GetFrames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/getFrames" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+
+	if r.FormValue("api_key") == "" || isIn(r.FormValue("api_key"), ws.Config.UserAPIKeys) == -1 {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if r.FormValue("id") == "" {
+		sendState(w, http.StatusBadRequest, "Error : Missing Parameter")
+		return
+	}
+
+	tmpMap, ok := ws.Tasks.Load(r.FormValue("id"))
+	if !ok {
+		sendState(w, http.StatusNotFound, "Error : can't find job")
+		return
+	}
+
+	frames := []FrameStatus{}
+	tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+		t := value.(*render.Task)
+		t.Lock()
+		frames = append(frames, FrameStatus{t.Frame, t.LastFrame(), t.Tile, t.State, t.Attempts, t.LastError, nil})
+		t.Unlock()
+		frames[len(frames)-1].Outputs = ws.outputs(t)
+		return true
+	})
+
+	sort.Slice(frames, func(i, j int) bool {
+		if frames[i].Frame != frames[j].Frame {
+			return frames[i].Frame < frames[j].Frame
+		}
+		return frames[i].Tile < frames[j].Tile
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	js, err := json.Marshal(frames)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Write(js)
+}
+
+//outputs returns the files rendered by t that are on the file server
+func (ws *WorkingSet) outputs(t *render.Task) []Output {
+	if t.GetState() != "rendered" {
+		return nil
+	}
+
+	names := []string{}
+	if !t.Settings.Tiled() {
+		for f := t.Frame; f <= t.LastFrame(); f++ {
+			names = append(names, t.FrameName(f))
+		}
+	} else if t.Tile == 0 && ws.tilesRendered(t) {
+		//The frame is stitched once all its tiles are rendered, the frame of tiles rendered again being outdated
+		names = append(names, t.FrameName(t.Frame))
+	}
+
+	outputs := []Output{}
+	for _, name := range names {
+		p, err := utils.SafeJoin(ws.Config.Folder, t.ID, name)
+		if err != nil {
+			continue
+		}
+		//The frames not uploaded or stitched yet are skipped
+		sum, err := filexchange.Checksum(p)
+		if err == nil {
+			outputs = append(outputs, Output{Name: name, Checksum: sum})
+		}
+	}
+	return outputs
+}
+
+//tilesRendered returns true if every tile of the frame of t is rendered
+func (ws *WorkingSet) tilesRendered(t *render.Task) bool {
+	tmpMap, ok := ws.Tasks.Load(t.ID)
+	if !ok {
+		return false
+	}
+
+	for i := 0; i < t.Settings.Tiles(); i++ {
+		tile, ok := tmpMap.(*sync.Map).Load(render.TaskKey{Frame: t.Frame, Tile: i})
+		if !ok || tile.(*render.Task).GetState() != "rendered" {
+			return false
+		}
+	}
+	return true
+}
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/LeoMarche/blenderer/src/node"
 	"github.com/LeoMarche/blenderer/src/render"
@@ -13,7 +14,8 @@ import (
 )
 
 //GetJob Handler for /getJob
-//The request must be a post with api_key and name
+//The request must be a post with api_key and name. The task is sent with a token allowing to exchange its files with
+//the file server and the checksum of its input, see JobToSend.
 func (ws *WorkingSet) GetJob(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" || r.URL.Path != "/getJob" {
 		http.Error(w, "404 not found.", http.StatusNotFound)
@@ -51,84 +53,49 @@ func (ws *WorkingSet) GetJob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	candidates := make(map[interface{}][]interface{})
-
-	ws.Tasks.Range(func(key, value interface{}) bool {
-		value.(*sync.Map).Range(func(key2, value2 interface{}) bool {
-			if value2.(*render.Task).State == "waiting" {
-
-				//Add the keys to the candidates
-				if val, ok := candidates[key]; ok {
-					candidates[key] = append(val, key2)
-				} else {
-					candidates[key] = []interface{}{key2}
-				}
-			}
-			return true
+	//A node reaching the master again isn't lost anymore
+	if n.Recover() {
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
+			OP:       rendererdb.UPDATENODE,
+			Argument: n,
 		})
-		return true
-	})
-
-	t := new(render.Task)
-	found := false
-	for taskID, frameList := range candidates {
-
-		//Load the Map containing the different tasks associated with frames
-		tmpMap, ok := ws.Tasks.Load(taskID)
-		if ok {
-
-			//For each frame in candidates frames
-			for _, fr := range frameList {
-
-				//Load the corresponding task
-				tsk, ok := tmpMap.(*sync.Map).Load(fr)
-				if ok {
-					valid := false
-					var rd *Render
-
-					tsk.(*render.Task).Lock()
-
-					//Check if task can be comissionned
-					if tsk.(*render.Task).State == "waiting" {
-						t = tsk.(*render.Task)
-						r := n.Commission()
-						if r {
-							tsk.(*render.Task).State = "rendering"
-							rd = &Render{
-								myTask: tsk.(*render.Task),
-								myNode: n,
-							}
-							ws.DBTransacts.Add(&rendererdb.DBTransact{
-								OP:       rendererdb.UPDATETASK,
-								Argument: rd.myTask,
-							})
-							ws.DBTransacts.Add(&rendererdb.DBTransact{
-								OP:       rendererdb.UPDATENODE,
-								Argument: rd.myNode,
-							})
-							valid = true
-						}
-					}
-					tsk.(*render.Task).Unlock()
+	}
 
-					//If task commissionable, comission it and stop searching
-					if valid {
-						newMap := new(sync.Map)
-						tmpMap, _ := ws.Renders.LoadOrStore(rd.myTask.ID, newMap)
-						tmpMap.(*sync.Map).Store(rd.myTask.Frame, rd)
-						found = true
-						break
-					}
-				}
-			}
+	//Ask the scheduler for the task the node must render, an empty task is sent if there is none
+	t := JobToSend{Task: new(render.Task)}
+	tsk, err := ws.Scheduler.Next(n)
+	if err == nil {
+		t.Task = tsk
+		t.Transfer, err = ws.newTransfer(tsk.ID)
+		if err != nil {
+			ws.Scheduler.Requeue(tsk)
+			n.Free()
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
 		}
-		if found {
-			break
+		t.Checksum = ws.inputChecksum(tsk)
+		rd := &Render{
+			myTask:   tsk,
+			myNode:   n,
+			expires:  time.Now().Add(ws.Config.leaseDuration()),
+			transfer: t.Transfer,
 		}
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
+			OP:       rendererdb.UPDATETASK,
+			Argument: rd.myTask,
+		})
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
+			OP:       rendererdb.UPDATENODE,
+			Argument: rd.myNode,
+		})
+
+		newMap := new(sync.Map)
+		tmpMap, _ := ws.Renders.LoadOrStore(rd.myTask.ID, newMap)
+		tmpMap.(*sync.Map).Store(rd.myTask.Key(), rd)
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	js, err := json.Marshal(*t)
+	js, err := json.Marshal(t)
 
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -0,0 +1,94 @@
+package rendererapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/LeoMarche/blenderer/src/rendererdb"
+)
+
+//PauseJob is handler for pausing jobs
+//The request must be a post with api_key, id and optionally stop. The waiting frames of the job are "paused" and aren't
+//given to the nodes until the job is resumed, frames being rendered or uploaded are paused when requeued or uploaded. If stop is true, the
+//nodes rendering the job are told to stop with "PAUSE" when they update it, the frames they rendered being kept.
+func (ws *WorkingSet) PauseJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/pauseJob" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+
+	if r.FormValue("api_key") == "" || isIn(r.FormValue("api_key"), ws.Config.UserAPIKeys) == -1 {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if r.FormValue("id") == "" {
+		sendState(w, http.StatusBadRequest, "Error : Missing Parameter")
+		return
+	}
+
+	stop, err := strconv.ParseBool(r.FormValue("stop"))
+	if r.FormValue("stop") != "" && err != nil {
+		sendState(w, http.StatusBadRequest, "Error : invalid parameter 'stop'")
+		return
+	}
+
+	tmpMap, ok := ws.Tasks.Load(r.FormValue("id"))
+	if !ok {
+		sendState(w, http.StatusNotFound, "Error : can't find job")
+		return
+	}
+
+	//The frames requeued or uploaded from now on are paused too
+	ws.Paused.Store(r.FormValue("id"), true)
+	ws.DBTransacts.Add(&rendererdb.DBTransact{
+		OP:       rendererdb.PAUSEPROJECT,
+		Argument: &rendererdb.ProjectPause{ID: r.FormValue("id"), Paused: true},
+	})
+
+	//The scheduler skips the frames that aren't waiting anymore
+	tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+		if pause(value.(*render.Task), "waiting") {
+			ws.DBTransacts.Add(&rendererdb.DBTransact{
+				OP:       rendererdb.UPDATETASK,
+				Argument: value.(*render.Task),
+			})
+		}
+		return true
+	})
+
+	//Optionally pause the frames being rendered, their nodes being told to stop by /updateJob
+	if rendersMap, ok := ws.Renders.Load(r.FormValue("id")); ok && stop {
+		rendersMap.(*sync.Map).Range(func(key, value interface{}) bool {
+			if pause(value.(*Render).myTask, "rendering") {
+				ws.DBTransacts.Add(&rendererdb.DBTransact{
+					OP:       rendererdb.UPDATETASK,
+					Argument: value.(*Render).myTask,
+				})
+			}
+			return true
+		})
+	}
+
+	sendState(w, http.StatusOK, "OK")
+}
+
+//pause puts t in "paused" state if it is in the given state and returns true if it did
+func pause(t *render.Task, state string) bool {
+	t.Lock()
+	defer t.Unlock()
+
+	if t.State != state {
+		return false
+	}
+	t.State = "paused"
+	return true
+}
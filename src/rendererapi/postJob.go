@@ -11,12 +11,24 @@ import (
 	"sync"
 	"time"
 
+	"github.com/LeoMarche/blenderer/src/bundle"
 	"github.com/LeoMarche/blenderer/src/render"
 	"github.com/LeoMarche/blenderer/src/rendererdb"
+	"github.com/LeoMarche/blenderer/src/utils"
+	"github.com/LeoMarche/blenderer/src/version"
 )
 
 //PostJob Handler for /postJob
 //The request must be a post with api_key, project, input, output, frameStart, frameStop, rendererName, rendererVersion, startTime
+//and optionally the render settings scene, camera, resolutionX, resolutionY, resolutionPercent, samples, format, colorDepth.
+//The optional frameStep renders every Nth frame from frameStart to frameStop. Instead of the range, frames can list
+//the frames to render, as in 1-10,15,20-40x5.
+//If the project is uploaded as an archive, bundle is its file name and input the path of the main file inside of it.
+//The optional priority (0 by default) orders the jobs, higher priorities being rendered first.
+//rendererVersion is a version constraint such as 2.91.0, ~3.6 or >=3.6 <4.0, or a version matched exactly like latest.
+//The optional chunkSize (1 by default) groups the frames by chunks rendered in a single run of the renderer.
+//The optional tilesX and tilesY split each frame in a grid of tiles rendered by blender on different nodes, the frame
+//being stitched in PNG once all its tiles are rendered.
 func (ws *WorkingSet) PostJob(w http.ResponseWriter, r *http.Request) {
 
 	//Verify requests parameters
@@ -43,16 +55,64 @@ func (ws *WorkingSet) PostJob(w http.ResponseWriter, r *http.Request) {
 	receivedTask.Project = r.FormValue("project")
 	receivedTask.Input = r.FormValue("input")
 	receivedTask.Output = r.FormValue("output")
-	receivedTask.FrameStart, err = strconv.Atoi(r.FormValue("frameStart"))
-	if err != nil {
+	receivedTask.Frames = r.FormValue("frames")
+	if receivedTask.Frames == "" {
+		receivedTask.FrameStart, err = strconv.Atoi(r.FormValue("frameStart"))
+		if err != nil {
+			sendState(w, http.StatusBadRequest, "Error : invalid parameter 'frameStart'")
+			return
+		}
+		receivedTask.FrameStop, err = strconv.Atoi(r.FormValue("frameStop"))
+		if err != nil {
+			sendState(w, http.StatusBadRequest, "Error : invalid parameter 'frameStop'")
+			return
+		}
+	}
+	if r.FormValue("frameStep") != "" {
+		receivedTask.FrameStep, err = strconv.Atoi(r.FormValue("frameStep"))
+		if err != nil || receivedTask.FrameStep < 1 {
+			sendState(w, http.StatusBadRequest, "Error : invalid parameter 'frameStep'")
+			return
+		}
+	}
+	receivedTask.RendererName = r.FormValue("rendererName")
+	receivedTask.RendererVersion = r.FormValue("rendererVersion")
+	if _, err = version.ParseConstraint(receivedTask.RendererVersion); err != nil && !version.Exact(receivedTask.RendererVersion) {
+		sendState(w, http.StatusBadRequest, "Error : invalid parameter 'rendererVersion'")
 		return
 	}
-	receivedTask.FrameStop, err = strconv.Atoi(r.FormValue("frameStop"))
+	receivedTask.Settings, err = parseSettings(r)
 	if err != nil {
+		sendState(w, http.StatusBadRequest, "Error : "+err.Error())
+		return
+	}
+	if receivedTask.Settings.Tiled() && receivedTask.RendererName != "blender" {
+		sendState(w, http.StatusBadRequest, "Error : only blender can render tiles")
+		return
+	}
+	if r.FormValue("chunkSize") != "" {
+		receivedTask.ChunkSize, err = strconv.Atoi(r.FormValue("chunkSize"))
+		if err != nil || receivedTask.ChunkSize < 1 {
+			sendState(w, http.StatusBadRequest, "Error : invalid parameter 'chunkSize'")
+			return
+		}
+	}
+	if r.FormValue("priority") != "" {
+		receivedTask.Priority, err = strconv.Atoi(r.FormValue("priority"))
+		if err != nil {
+			sendState(w, http.StatusBadRequest, "Error : invalid parameter 'priority'")
+			return
+		}
+	}
+	receivedTask.Bundle = r.FormValue("bundle")
+	if err = checkBundle(receivedTask.Bundle, receivedTask.Input); err != nil {
+		sendState(w, http.StatusBadRequest, "Error : "+err.Error())
+		return
+	}
+	if err = checkFiles(receivedTask.Input, receivedTask.Output); err != nil {
+		sendState(w, http.StatusBadRequest, "Error : "+err.Error())
 		return
 	}
-	receivedTask.RendererName = r.FormValue("rendererName")
-	receivedTask.RendererVersion = r.FormValue("rendererVersion")
 
 	t := time.Now().String()
 	sha256 := sha256.Sum256([]byte(t))
@@ -61,13 +121,27 @@ func (ws *WorkingSet) PostJob(w http.ResponseWriter, r *http.Request) {
 
 	receivedTask.State = "uploading"
 	receivedTask.StartTime = r.FormValue("startTime")
+	receivedTask.Submitted = time.Now().UnixNano()
+	receivedTask.Owner = r.FormValue("api_key")
 
 	//Get individual tasks and put it into hashmap
-	it := receivedTask.GetIndividualTasks()
+	it, err := receivedTask.GetIndividualTasks()
+	if err != nil {
+		sendState(w, http.StatusBadRequest, "Error : "+err.Error())
+		return
+	}
+
+	//The token is issued first so that no job is stored without a way to upload its files
+	transfer, err := ws.newTransfer(receivedTask.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
 	newMap := new(sync.Map)
 	tmpMap, _ := ws.Tasks.LoadOrStore(receivedTask.ID, newMap)
 	for _, r := range it {
-		tmpMap.(*sync.Map).Store(r.Frame, r)
+		tmpMap.(*sync.Map).Store(r.Key(), r)
 	}
 
 	//Put tasks into DB
@@ -76,7 +150,7 @@ func (ws *WorkingSet) PostJob(w http.ResponseWriter, r *http.Request) {
 		Argument: it,
 	})
 
-	up := Upload{Project: receivedTask.Project, Token: receivedTask.ID, State: "ready"}
+	up := Upload{Project: receivedTask.Project, Token: receivedTask.ID, State: "ready", Transfer: transfer}
 
 	//Send answer
 	w.Header().Set("Content-Type", "application/json")
@@ -87,3 +161,63 @@ func (ws *WorkingSet) PostJob(w http.ResponseWriter, r *http.Request) {
 	}
 	w.Write(js)
 }
+
+//parseSettings reads the optional render settings of a request and validates them
+func parseSettings(r *http.Request) (render.Settings, error) {
+	s := render.Settings{
+		Scene:      r.FormValue("scene"),
+		Camera:     r.FormValue("camera"),
+		Format:     strings.ToUpper(r.FormValue("format")),
+		ColorDepth: r.FormValue("colorDepth"),
+	}
+
+	ints := []struct {
+		name  string
+		value *int
+	}{
+		{"resolutionX", &s.ResolutionX},
+		{"resolutionY", &s.ResolutionY},
+		{"resolutionPercent", &s.ResolutionPercent},
+		{"samples", &s.Samples},
+		{"tilesX", &s.TilesX},
+		{"tilesY", &s.TilesY},
+	}
+
+	for _, i := range ints {
+		if r.FormValue(i.name) != "" {
+			v, err := strconv.Atoi(r.FormValue(i.name))
+			if err != nil {
+				return s, fmt.Errorf("invalid parameter '%s'", i.name)
+			}
+			*i.value = v
+		}
+	}
+
+	return s, s.Validate()
+}
+
+//checkFiles returns an error if the input or the output of a job could be stored outside of its folder, the output
+//being the prefix of the names of the rendered frames
+func checkFiles(input, output string) error {
+	if utils.CheckPath(input) != nil {
+		return fmt.Errorf("invalid parameter 'input'")
+	}
+	if output != "" && utils.CheckName(output) != nil {
+		return fmt.Errorf("invalid parameter 'output'")
+	}
+	return nil
+}
+
+//checkBundle returns an error if the archive name can't be stored by the file server or if main isn't a path inside of it
+func checkBundle(name, main string) error {
+	if name == "" {
+		return nil
+	}
+	if strings.ContainsAny(name, "/\\ ") || !bundle.IsArchive(name) {
+		return fmt.Errorf("invalid bundle '%s'", name)
+	}
+	if !bundle.IsLocal(main) {
+		return fmt.Errorf("invalid main file '%s' of bundle", main)
+	}
+	return nil
+}
@@ -11,7 +11,8 @@ import (
 )
 
 //PostJob Handler for /postNode
-//The request must be a post with api_key and name
+//The request must be a post with api_key, name and renderers, the JSON list of the names and versions of the
+//renderers installed on the node. The node is only given the tasks of these renderers.
 func (ws *WorkingSet) PostNode(w http.ResponseWriter, r *http.Request) {
 
 	if err := r.ParseForm(); err != nil {
@@ -25,10 +26,19 @@ func (ws *WorkingSet) PostNode(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	var renderers []node.Renderer
+	if r.FormValue("renderers") != "" {
+		if err := json.Unmarshal([]byte(r.FormValue("renderers")), &renderers); err != nil {
+			sendState(w, http.StatusBadRequest, "Error : invalid parameter 'renderers'")
+			return
+		}
+	}
+
 	receivedNode := new(node.Node)
 	receivedNode.Name = r.FormValue("name")
 	receivedNode.IP = strings.Split(getIP(r), ":")[0]
 	receivedNode.APIKey = r.FormValue("api_key")
+	receivedNode.Renderers = renderers
 	receivedNode.SetState("available")
 
 	var rt ReturnValue
@@ -38,6 +48,7 @@ func (ws *WorkingSet) PostNode(w http.ResponseWriter, r *http.Request) {
 	if loaded {
 		rt = ReturnValue{"Exists"}
 		n.(*node.Node).SetState("available")
+		n.(*node.Node).SetRenderers(renderers)
 		ws.DBTransacts.Add(&rendererdb.DBTransact{
 			OP:       rendererdb.UPDATENODE,
 			Argument: n.(*node.Node),
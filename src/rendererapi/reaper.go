@@ -0,0 +1,65 @@
+package rendererapi
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/LeoMarche/blenderer/src/rendererdb"
+)
+
+//ReapExpiredRenders requeues the frames whose lease expired before now and marks their nodes as lost.
+//The expiration counts as a failed attempt, the node being given the frame again if it comes back.
+//It returns the number of renders removed.
+func (ws *WorkingSet) ReapExpiredRenders(now time.Time) int {
+	reaped := 0
+
+	ws.Renders.Range(func(key, value interface{}) bool {
+		value.(*sync.Map).Range(func(key2, value2 interface{}) bool {
+			rd := value2.(*Render)
+
+			rd.myTask.Lock()
+			if !now.After(rd.expires) {
+				rd.myTask.Unlock()
+				return true
+			}
+
+			value.(*sync.Map).Delete(key2)
+			requeued := rd.myTask.State == "rendering"
+			rd.myTask.Unlock()
+
+			fmt.Printf("Lease of node %s on frame %d of %s expired\n", rd.myNode.Name, rd.myTask.Frame, rd.myTask.ID)
+			reaped++
+
+			if requeued {
+				ws.requeue(rd, &failure{reason: "lease of node " + rd.myNode.Name + " expired"})
+			} else {
+				ws.Scheduler.Release(rd.myTask)
+			}
+
+			//The node may have been freed or set in error since
+			if rd.myNode.State() == "rendering" {
+				rd.myNode.SetState("lost")
+				ws.DBTransacts.Add(&rendererdb.DBTransact{
+					OP:       rendererdb.UPDATENODE,
+					Argument: rd.myNode,
+				})
+			}
+			return true
+		})
+		return true
+	})
+
+	return reaped
+}
+
+//ReaperRoutine reaps the expired renders and transfer tokens at the interval of the configuration until stop is set
+func (ws *WorkingSet) ReaperRoutine(stop *bool) {
+	for !(*stop) {
+		time.Sleep(ws.Config.reapInterval())
+		ws.ReapExpiredRenders(time.Now())
+		ws.dropExpiredTransfers(time.Now())
+	}
+
+	fmt.Println("ReaperRoutine received stopping signal, exiting now !")
+}
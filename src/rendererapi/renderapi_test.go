@@ -3,22 +3,29 @@ package rendererapi
 import (
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"github.com/LeoMarche/blenderer/src/filexchange"
 	"github.com/LeoMarche/blenderer/src/node"
 	"github.com/LeoMarche/blenderer/src/render"
 	fifo "github.com/foize/go.fifo"
 
 	"github.com/LeoMarche/blenderer/src/rendererdb"
+	"github.com/LeoMarche/blenderer/src/scheduler"
 
 	_ "github.com/mattn/go-sqlite3"
 	"github.com/stretchr/testify/assert"
@@ -88,6 +95,8 @@ func TestAbortJob(t *testing.T) {
 	expectedNodeState := []string{"rendering", "rendering"}
 	expectedReturnCode := []string{"OK", "error: can't find job"}
 	expectedRenderState := []string{"abort", "rendering"}
+	expectedOtherStates := [][]string{{"rendered", "failed", "abort"}, {"rendered", "failed", "waiting"}}
+	expectedTransacts := []int{2, 0}
 
 	for i := 0; i < len(dataTab); i++ {
 
@@ -130,12 +139,22 @@ func TestAbortJob(t *testing.T) {
 		rendersT := new(sync.Map)
 		newRenderMap := new(sync.Map)
 		tmpRenderMap, _ := rendersT.LoadOrStore(tas.ID, newRenderMap)
-		tmpRenderMap.(*sync.Map).Store(tas.Frame, rd)
+		tmpRenderMap.(*sync.Map).Store(tas.Key(), rd)
 
 		tasksT := new(sync.Map)
 		newMap := new(sync.Map)
 		tmpMap, _ := tasksT.LoadOrStore(tas.ID, newMap)
-		tmpMap.(*sync.Map).Store(tas.Frame, tas)
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+
+		//The finished frames are kept
+		others := []*render.Task{
+			{Project: "cube", ID: "test_api", Frame: 128, State: "rendered", RendererName: "blender"},
+			{Project: "cube", ID: "test_api", Frame: 129, State: "failed", RendererName: "blender"},
+			{Project: "cube", ID: "test_api", Frame: 130, State: "waiting", RendererName: "blender"},
+		}
+		for _, o := range others {
+			tmpMap.(*sync.Map).Store(o.Key(), o)
+		}
 
 		DBT := fifo.NewQueue()
 
@@ -144,7 +163,9 @@ func TestAbortJob(t *testing.T) {
 			RenderNodes: nodesT,
 			Renders:     rendersT,
 			Tasks:       tasksT,
+			Paused:      new(sync.Map),
 			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
 		}
 
 		tas.SetState("rendering")
@@ -172,13 +193,203 @@ func TestAbortJob(t *testing.T) {
 		assert.Equal(expectedNodeState[i], testNode.(*node.Node).State(), "Bad state assigned to node in test %d", i)
 		assert.Equal(expectedReturnCode[i], dt.State, "Bad state assigned to task in test %d", i)
 		assert.Equal(expectedRenderState[i], tas.State, "Invalid task state after aborting in test %d, i")
+		for j, o := range others {
+			assert.Equal(expectedOtherStates[i][j], o.State, "Bad state of frame %d after aborting in test %d", o.Frame, i)
+		}
+		assert.Equal(expectedTransacts[i], DBT.Len(), "Bad number of database transactions in test %d", i)
+
+		//The render stays until its node is told to stop
+		_, ok = tmpRenderMap.(*sync.Map).Load(tas.Key())
+		assert.Equal(true, ok, "Render removed before its node was told to stop in test %d", i)
+	}
+}
+
+//TestAbortJobRoundTrip aborts a job being rendered through the API, the node being told to stop and freed and the
+//state of the job being stored in the database
+func TestAbortJobRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	folder, err := ioutil.TempDir("", "blenderer")
+	assert.NoError(err)
+	defer os.RemoveAll(folder)
+
+	db, err := rendererdb.LoadDatabase(filepath.Join(folder, "abort.sql"))
+	if !assert.NoError(err) {
+		return
+	}
+	defer db.Close()
+
+	nd := &node.Node{
+		Name:      "localhost",
+		IP:        "127.0.0.1",
+		APIKey:    "test_api",
+		Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
+	}
+	nd.SetState("available")
+	assert.NoError(rendererdb.InsertNodeInDB(db, nd))
+
+	tasks := []*render.Task{
+		{Project: "cube", ID: "test_api", Input: "cube.blend", Output: "cube", Frame: 1, State: "waiting", RendererName: "blender", RendererVersion: "2.91.0"},
+		{Project: "cube", ID: "test_api", Input: "cube.blend", Output: "cube", Frame: 2, State: "waiting", RendererName: "blender", RendererVersion: "2.91.0"},
+	}
+	assert.NoError(rendererdb.InsertProjectsInDB(db, tasks))
+
+	nodesT := new(sync.Map)
+	nodesT.Store(nd.Key(), nd)
+	tasksT := new(sync.Map)
+	tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+	for _, tas := range tasks {
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+	}
+
+	ws := WorkingSet{
+		Db:          db,
+		Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+		RenderNodes: nodesT,
+		Renders:     new(sync.Map),
+		Tasks:       tasksT,
+		Paused:      new(sync.Map),
+		DBTransacts: fifo.NewQueue(),
+		Scheduler:   scheduler.NewFIFO(),
+	}
+	for _, tas := range tasks {
+		ws.Scheduler.Add(tas)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/getJob", ws.GetJob)
+	mux.HandleFunc("/updateJob", ws.UpdateJob)
+	mux.HandleFunc("/abortJob", ws.AbortJob)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(path string, data url.Values, target interface{}) {
+		resp, err := http.PostForm(server.URL+path, data)
+		if assert.NoError(err, "Couldn't post to %s", path) {
+			defer resp.Body.Close()
+			assert.NoError(json.NewDecoder(resp.Body).Decode(target), "Bad response of %s", path)
+		}
+	}
+	update := url.Values{"api_key": {"test_api"}, "name": {"localhost"}, "id": {"test_api"}, "frame": {"1"},
+		"state": {"rendering"}, "percent": {"0.5"}, "mem": {"2.0"}}
+
+	//The node gets the first frame and renders it
+	job := new(render.Task)
+	post("/getJob", url.Values{"api_key": {"test_api"}, "name": {"localhost"}}, job)
+	assert.Equal(1, job.Frame, "Bad frame given to the node")
+	rv := new(ReturnValue)
+	post("/updateJob", update, rv)
+	assert.Equal("OK", rv.State, "Bad state of an update before the abort")
+
+	//The job is aborted, the node is told to stop at its next update and freed
+	post("/abortJob", url.Values{"api_key": {"test_api"}, "id": {"test_api"}}, rv)
+	assert.Equal("OK", rv.State, "Job not aborted")
+	assert.Equal("rendering", nd.State(), "Node freed before being told to stop")
+	post("/updateJob", update, rv)
+	assert.Equal("ABORT", rv.State, "Node not told to stop")
+	assert.Equal("available", nd.State(), "Node not freed")
+	post("/updateJob", update, rv)
+	assert.Equal("Error : No matching Renders", rv.State, "Render kept after the node was told to stop")
+
+	//The other frames of the job aren't given to the nodes anymore
+	job = new(render.Task)
+	post("/getJob", url.Values{"api_key": {"test_api"}, "name": {"localhost"}}, job)
+	assert.Equal("", job.ID, "Frame of an aborted job given to the node")
+
+	//The abort is stored in the database
+	for tr := ws.DBTransacts.Next(); tr != nil; tr = ws.DBTransacts.Next() {
+		assert.NoError(tr.(*rendererdb.DBTransact).Apply(db))
+	}
+	loaded := new(sync.Map)
+	assert.NoError(rendererdb.LoadTasksFromDB(db, loaded, new(sync.Map)))
+	tmpMap, ok := loaded.Load("test_api")
+	if assert.Equal(true, ok, "Aborted job not stored") {
+		for _, tas := range tasks {
+			stored, ok := tmpMap.(*sync.Map).Load(tas.Key())
+			if assert.Equal(true, ok, "Frame %d not stored", tas.Frame) {
+				assert.Equal("abort", stored.(*render.Task).State, "Abort of frame %d not stored", tas.Frame)
+			}
+		}
+	}
+	loadedNodes := new(sync.Map)
+	assert.NoError(rendererdb.LoadNodeFromDB(db, loadedNodes))
+	if stored, ok := loadedNodes.Load(nd.Key()); assert.Equal(true, ok, "Node not stored") {
+		assert.Equal("available", stored.(*node.Node).State(), "Freed node not stored")
+	}
+}
+
+func TestAbortJobErrorNode(t *testing.T) {
+	assert := assert.New(t)
+
+	nd := &node.Node{
+		Name:      "localhost",
+		IP:        "127.0.0.1",
+		APIKey:    "test_api",
+		Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
 	}
+	nd.SetState("available")
+
+	tas := &render.Task{Project: "cube", ID: "test_api", Input: "cube.blend", Output: "cube", Frame: 1, State: "waiting", RendererName: "blender", RendererVersion: "2.91.0"}
+
+	nodesT := new(sync.Map)
+	nodesT.Store(nd.Key(), nd)
+	tasksT := new(sync.Map)
+	tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+	tmpMap.(*sync.Map).Store(tas.Key(), tas)
+
+	ws := WorkingSet{
+		Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+		RenderNodes: nodesT,
+		Renders:     new(sync.Map),
+		Tasks:       tasksT,
+		Paused:      new(sync.Map),
+		DBTransacts: fifo.NewQueue(),
+		Scheduler:   scheduler.NewFIFO(),
+	}
+	ws.Scheduler.Add(tas)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/getJob", ws.GetJob)
+	mux.HandleFunc("/abortJob", ws.AbortJob)
+	mux.HandleFunc("/errorNode", ws.ErrorNode)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(path string, data url.Values, target interface{}) {
+		resp, err := http.PostForm(server.URL+path, data)
+		if assert.NoError(err, "Couldn't post to %s", path) {
+			defer resp.Body.Close()
+			assert.NoError(json.NewDecoder(resp.Body).Decode(target), "Bad response of %s", path)
+		}
+	}
+
+	//The job is aborted while the node renders it, then the node fails before being told to stop
+	job := new(render.Task)
+	post("/getJob", url.Values{"api_key": {"test_api"}, "name": {"localhost"}}, job)
+	assert.Equal(1, job.Frame, "Bad frame given to the node")
+	rv := new(ReturnValue)
+	post("/abortJob", url.Values{"api_key": {"test_api"}, "id": {"test_api"}}, rv)
+	assert.Equal("OK", rv.State, "Job not aborted")
+	post("/errorNode", url.Values{"api_key": {"test_api"}, "name": {"localhost"}, "error": {"segmentation fault"}}, rv)
+	assert.Equal("Done", rv.State, "Node not put in error")
+
+	//The aborted frame isn't rendered again nor counted as failed
+	assert.Equal("abort", tas.GetState(), "Aborted frame requeued")
+	assert.Equal(0, tas.Attempts, "Attempt counted for the aborted frame")
+	rendersMap, _ := ws.Renders.Load("test_api")
+	_, ok := rendersMap.(*sync.Map).Load(tas.Key())
+	assert.Equal(false, ok, "Render of the node in error kept")
+
+	other := &node.Node{Name: "other", IP: "127.0.0.2", Renderers: nd.Renderers}
+	other.SetState("available")
+	_, err := ws.Scheduler.Next(other)
+	assert.Equal(scheduler.ErrNoTask, err, "Aborted frame scheduled again")
 }
 
 func TestErrorNode(t *testing.T) {
 	assert := assert.New(t)
 
-	dataTab := []url.Values{{}, {}}
+	dataTab := []url.Values{{}, {}, {}}
 	//Creating request and recorder
 	dataTab[0].Set("api_key", "test_api")
 	dataTab[0].Set("name", "localhost")
@@ -186,10 +397,18 @@ func TestErrorNode(t *testing.T) {
 	dataTab[1].Set("api_key", "test_api")
 	dataTab[1].Set("name", "localhost2")
 
-	expectedNodeState := []string{"error", "rendering"}
-	expectedReturnCode := []string{"Done", "Couldn't find matching node"}
-	expectedRenderState := []string{"waiting", "rendering"}
-	expectedRenderDeleted := []bool{true, false}
+	dataTab[2].Set("api_key", "test_api")
+	dataTab[2].Set("name", "localhost")
+	dataTab[2].Set("error", "segmentation fault")
+
+	maxAttempts := []int{0, 0, 1}
+	expectedNodeState := []string{"error", "rendering", "error"}
+	expectedReturnCode := []string{"Done", "Couldn't find matching node", "Done"}
+	expectedRenderState := []string{"waiting", "rendering", "failed"}
+	expectedRenderDeleted := []bool{true, false, true}
+	expectedAttempts := []int{1, 0, 1}
+	expectedLastError := []string{"error on node localhost", "", "error on node localhost : segmentation fault"}
+	expectedFailedNodes := [][]string{{"localhost//127.0.0.1"}, nil, {"localhost//127.0.0.1"}}
 
 	for i := 0; i < len(dataTab); i++ {
 
@@ -199,6 +418,7 @@ func TestErrorNode(t *testing.T) {
 			DBName:      "",
 			Certname:    "",
 			UserAPIKeys: []string{"test_api"},
+			MaxAttempts: maxAttempts[i],
 		}
 
 		nd := &node.Node{
@@ -232,12 +452,12 @@ func TestErrorNode(t *testing.T) {
 		rendersT := new(sync.Map)
 		newRenderMap := new(sync.Map)
 		tmpRenderMap, _ := rendersT.LoadOrStore(tas.ID, newRenderMap)
-		tmpRenderMap.(*sync.Map).Store(tas.Frame, rd)
+		tmpRenderMap.(*sync.Map).Store(tas.Key(), rd)
 
 		tasksT := new(sync.Map)
 		newMap := new(sync.Map)
 		tmpMap, _ := tasksT.LoadOrStore(tas.ID, newMap)
-		tmpMap.(*sync.Map).Store(tas.Frame, tas)
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
 
 		DBT := fifo.NewQueue()
 
@@ -246,7 +466,9 @@ func TestErrorNode(t *testing.T) {
 			RenderNodes: nodesT,
 			Renders:     rendersT,
 			Tasks:       tasksT,
+			Paused:      new(sync.Map),
 			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
 		}
 
 		//Creating request
@@ -271,22 +493,86 @@ func TestErrorNode(t *testing.T) {
 		assert.Equal(expectedNodeState[i], testNode.(*node.Node).State(), "Bad state assigned to node in test %d", i)
 		assert.Equal(expectedReturnCode[i], dt.State, "Bad state assigned to task in test %d", i)
 		assert.Equal(expectedRenderState[i], tas.State, "Bad state assigner to task when putting node in error in test %d", i)
+		assert.Equal(expectedAttempts[i], tas.Attempts, "Bad number of attempts in test %d", i)
+		assert.Equal(expectedLastError[i], tas.LastError, "Bad last error in test %d", i)
+		assert.Equal(expectedFailedNodes[i], tas.FailedNodes, "Bad failed nodes in test %d", i)
 		tmpRd, ok := rendersT.Load(tas.ID)
 		if expectedRenderDeleted[i] {
 			if ok {
-				_, ok2 := tmpRd.(*sync.Map).Load(tas.Frame)
+				_, ok2 := tmpRd.(*sync.Map).Load(tas.Key())
 				assert.Equal(false, ok2, "Render should have been deleted but is not in test %d", i)
 			}
 		} else {
 			assert.Equal(true, ok, "Render has been deleted and it shouldn't have in test %d", i)
 			if ok {
-				_, ok2 := tmpRd.(*sync.Map).Load(tas.Frame)
+				_, ok2 := tmpRd.(*sync.Map).Load(tas.Key())
 				assert.Equal(true, ok2, "Render has been deleted and it shouldn't have in test %d", i)
 			}
 		}
 	}
 }
 
+//TestFailOnOnlyNode renders a frame failing on the only node of the farm, the node being given the frame again until
+//it failed too many times
+func TestFailOnOnlyNode(t *testing.T) {
+	assert := assert.New(t)
+
+	nd := &node.Node{
+		Name:      "localhost",
+		IP:        "127.0.0.1",
+		APIKey:    "test_api",
+		Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
+	}
+	nd.SetState("available")
+	tas := &render.Task{ID: "test_api", Frame: 1, State: "waiting", RendererName: "blender", RendererVersion: "2.91.0"}
+
+	nodesT := new(sync.Map)
+	nodesT.Store(nd.Key(), nd)
+	tasksT := new(sync.Map)
+	tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+	tmpMap.(*sync.Map).Store(tas.Key(), tas)
+
+	ws := WorkingSet{
+		Config:      Configuration{UserAPIKeys: []string{"test_api"}, MaxAttempts: 3},
+		RenderNodes: nodesT,
+		Renders:     new(sync.Map),
+		Tasks:       tasksT,
+		Paused:      new(sync.Map),
+		DBTransacts: fifo.NewQueue(),
+		Scheduler:   scheduler.NewFIFO(),
+	}
+	ws.Scheduler.Add(tas)
+
+	post := func(path string, handler http.HandlerFunc, data url.Values, target interface{}) {
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1"+path, strings.NewReader(data.Encode()))
+		r.RemoteAddr = "127.0.0.1:1001"
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		json.NewDecoder(w.Result().Body).Decode(target)
+	}
+	getJob := url.Values{"api_key": {"test_api"}, "name": {"localhost"}}
+	update := url.Values{"api_key": {"test_api"}, "id": {"test_api"}, "name": {"localhost"}, "frame": {"1"},
+		"state": {"error"}, "percent": {"0.0"}, "mem": {"0.0"}}
+
+	expectedState := []string{"waiting", "waiting", "failed"}
+	for i := 0; i < len(expectedState); i++ {
+		job := new(render.Task)
+		post("/getJob", ws.GetJob, getJob, job)
+		assert.Equal(1, job.Frame, "Frame not given to the only node in attempt %d", i)
+		rv := new(ReturnValue)
+		post("/updateJob", ws.UpdateJob, update, rv)
+		assert.Equal("FAILED", rv.State, "Bad state returned in attempt %d", i)
+		assert.Equal(expectedState[i], tas.State, "Bad state of the frame in attempt %d", i)
+		assert.Equal(i+1, tas.Attempts, "Bad number of attempts in attempt %d", i)
+	}
+
+	job := new(render.Task)
+	post("/getJob", ws.GetJob, getJob, job)
+	assert.Equal("", job.ID, "Failed frame given to the node")
+}
+
 func TestGetAllRenders(t *testing.T) {
 	assert := assert.New(t)
 
@@ -301,6 +587,26 @@ func TestGetAllRenders(t *testing.T) {
 		Percent:   5,
 		Nb:        1,
 		StartTime: "",
+	}, {
+		Project:   "cube",
+		ID:        "test_api2",
+		Percent:   0,
+		Nb:        1,
+		StartTime: "",
+		Status:    NoCapableNode,
+	}, {
+		Project:   "cube",
+		ID:        "test_api3",
+		Percent:   25,
+		Nb:        2,
+		StartTime: "",
+		Status:    Paused,
+	}, {
+		Project:   "cube",
+		ID:        "test_api4",
+		Percent:   0,
+		Nb:        0,
+		StartTime: "",
 	}}
 	expectedReturn1 := []TaskToSend{{}}
 
@@ -318,9 +624,10 @@ func TestGetAllRenders(t *testing.T) {
 		}
 
 		nd := &node.Node{
-			Name:   "localhost",
-			IP:     "127.0.0.1",
-			APIKey: "test_api",
+			Name:      "localhost",
+			IP:        "127.0.0.1",
+			APIKey:    "test_api",
+			Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
 		}
 		nd.SetState("down")
 
@@ -335,12 +642,64 @@ func TestGetAllRenders(t *testing.T) {
 			RendererVersion: "2.91.0",
 		}
 
+		//No node can render this one
+		tas2 := &render.Task{
+			Project:         "cube",
+			ID:              "test_api2",
+			Input:           "cube.blend",
+			Output:          "cube.blend",
+			Frame:           1,
+			State:           "waiting",
+			RendererName:    "blender",
+			RendererVersion: "3.6.0",
+		}
+
+		//Paused job, the node of the first frame not being told to stop yet
+		tas3 := &render.Task{
+			Project:         "cube",
+			ID:              "test_api3",
+			Input:           "cube.blend",
+			Output:          "cube.blend",
+			Frame:           1,
+			State:           "paused",
+			RendererName:    "blender",
+			RendererVersion: "2.91.0",
+		}
+		tas4 := &render.Task{
+			Project:         "cube",
+			ID:              "test_api3",
+			Input:           "cube.blend",
+			Output:          "cube.blend",
+			Frame:           2,
+			State:           "paused",
+			RendererName:    "blender",
+			RendererVersion: "2.91.0",
+		}
+
+		//Aborted job
+		tas5 := &render.Task{
+			Project:         "cube",
+			ID:              "test_api4",
+			Input:           "cube.blend",
+			Output:          "cube.blend",
+			Frame:           1,
+			State:           "abort",
+			RendererName:    "blender",
+			RendererVersion: "2.91.0",
+		}
+
 		rd := &Render{
 			myTask:  tas,
 			myNode:  nd,
 			Percent: "5.0",
 			Mem:     "150",
 		}
+		rd3 := &Render{
+			myTask:  tas3,
+			myNode:  nd,
+			Percent: "50.0",
+			Mem:     "150",
+		}
 
 		nodesT := new(sync.Map)
 		nodesT.Store(nd.Name+"//"+nd.IP, nd)
@@ -348,12 +707,21 @@ func TestGetAllRenders(t *testing.T) {
 		rendersT := new(sync.Map)
 		newRendersMap := new(sync.Map)
 		tmpRendersMap, _ := rendersT.LoadOrStore(tas.ID, newRendersMap)
-		tmpRendersMap.(*sync.Map).Store(tas.Frame, rd)
+		tmpRendersMap.(*sync.Map).Store(tas.Key(), rd)
+		tmpRendersMap, _ = rendersT.LoadOrStore(tas3.ID, new(sync.Map))
+		tmpRendersMap.(*sync.Map).Store(tas3.Key(), rd3)
 
 		tasksT := new(sync.Map)
 		newMap := new(sync.Map)
 		tmpMap, _ := tasksT.LoadOrStore(tas.ID, newMap)
-		tmpMap.(*sync.Map).Store(tas.Frame, tas)
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+		tmpMap, _ = tasksT.LoadOrStore(tas2.ID, new(sync.Map))
+		tmpMap.(*sync.Map).Store(tas2.Key(), tas2)
+		tmpMap, _ = tasksT.LoadOrStore(tas3.ID, new(sync.Map))
+		tmpMap.(*sync.Map).Store(tas3.Key(), tas3)
+		tmpMap.(*sync.Map).Store(tas4.Key(), tas4)
+		tmpMap, _ = tasksT.LoadOrStore(tas5.ID, new(sync.Map))
+		tmpMap.(*sync.Map).Store(tas5.Key(), tas5)
 
 		DBT := fifo.NewQueue()
 
@@ -362,7 +730,9 @@ func TestGetAllRenders(t *testing.T) {
 			RenderNodes: nodesT,
 			Renders:     rendersT,
 			Tasks:       tasksT,
+			Paused:      new(sync.Map),
 			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
 		}
 
 		//Creating request
@@ -383,7 +753,7 @@ func TestGetAllRenders(t *testing.T) {
 		//Asserts
 		assert.Equal(expectedCode[i], resp.StatusCode, "Bad HTML response code, %d instead of %d", resp.StatusCode, expectedCode[i])
 		if expectedCode[i] == 200 {
-			assert.Equal(expectedReturn[i], *dt, "Bad return in test %d", i)
+			assert.ElementsMatch(expectedReturn[i], *dt, "Bad return in test %d", i)
 		}
 	}
 }
@@ -392,7 +762,7 @@ func TestGetJob(t *testing.T) {
 
 	assert := assert.New(t)
 
-	dataTab := []url.Values{{}}
+	dataTab := []url.Values{{}, {}, {}}
 
 	os.MkdirAll("../../testdata/rendererapi_tests/getJob", os.ModePerm)
 	copy("../../testdata/rendererapi_tests/testGetJob.sql", "../../testdata/rendererapi_tests/getJob/testGetJob.sql")
@@ -401,8 +771,21 @@ func TestGetJob(t *testing.T) {
 	dataTab[0].Set("api_key", "test_api")
 	dataTab[0].Set("name", "localhost")
 
-	expectedNodeState := []string{"rendering"}
-	expectedFrameState := []string{"rendering"}
+	dataTab[1].Set("api_key", "test_api")
+	dataTab[1].Set("name", "localhost")
+
+	dataTab[2].Set("api_key", "test_api")
+	dataTab[2].Set("name", "localhost")
+
+	initialNodeState := []string{"available", "lost", "available"}
+	nodeRenderers := [][]node.Renderer{
+		{{Name: "blender", Version: "2.91.0"}},
+		{{Name: "blender", Version: "3.6.0"}, {Name: "blender", Version: "2.91.0"}},
+		{{Name: "blender", Version: "3.6.0"}},
+	}
+	expectedID := []string{"test_api", "test_api", ""}
+	expectedNodeState := []string{"rendering", "rendering", "available"}
+	expectedFrameState := []string{"rendering", "rendering", "waiting"}
 
 	for i := 0; i < len(dataTab); i++ {
 
@@ -417,11 +800,12 @@ func TestGetJob(t *testing.T) {
 		db, _ := rendererdb.LoadDatabase(cg.DBName)
 
 		nd := &node.Node{
-			Name:   "localhost",
-			IP:     "127.0.0.1",
-			APIKey: "test_api",
+			Name:      "localhost",
+			IP:        "127.0.0.1",
+			APIKey:    "test_api",
+			Renderers: nodeRenderers[i],
 		}
-		nd.SetState("available")
+		nd.SetState(initialNodeState[i])
 
 		tas := &render.Task{
 			Project:         "cube",
@@ -442,7 +826,7 @@ func TestGetJob(t *testing.T) {
 		tasksT := new(sync.Map)
 		newMap := new(sync.Map)
 		tmpMap, _ := tasksT.LoadOrStore(tas.ID, newMap)
-		tmpMap.(*sync.Map).Store(tas.Frame, tas)
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
 
 		DBT := fifo.NewQueue()
 
@@ -452,8 +836,11 @@ func TestGetJob(t *testing.T) {
 			RenderNodes: nodesT,
 			Renders:     rendersT,
 			Tasks:       tasksT,
+			Paused:      new(sync.Map),
 			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
 		}
+		ws.Scheduler.Add(tas)
 
 		//Creating request
 		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/getJob", strings.NewReader(dataTab[i].Encode()))
@@ -468,14 +855,30 @@ func TestGetJob(t *testing.T) {
 		resp := w.Result()
 		body, _ := ioutil.ReadAll(resp.Body)
 		dt := new(render.Task)
-		json.Unmarshal(body, dt)
+		sent := JobToSend{Task: dt}
+		json.Unmarshal(body, &sent)
 
 		//Asserts
 		assert.Equal("application/json", resp.Header.Get("Content-Type"), "Bad header in test %d", i)
-		assert.Equal(tas.ID, dt.ID, "Bad task returned")
-		assert.Equal(tas.Frame, dt.Frame, "Bad task returned")
-		assert.Equal(expectedNodeState[i], nd.State(), "Bad state assigned to node")
-		assert.Equal(expectedFrameState[i], tas.State, "Bad state assigned to task")
+		assert.Equal(expectedID[i], dt.ID, "Bad task returned in test %d", i)
+		assert.Equal(expectedNodeState[i], nd.State(), "Bad state assigned to node in test %d", i)
+		assert.Equal(expectedFrameState[i], tas.State, "Bad state assigned to task in test %d", i)
+
+		tmpMap, ok := rendersT.Load(tas.ID)
+		if expectedID[i] == "" {
+			assert.Equal(false, ok, "Render stored for a node not supporting the task in test %d", i)
+			continue
+		}
+		assert.Equal(tas.Frame, dt.Frame, "Bad task returned in test %d", i)
+		assert.Equal(true, ws.AuthorizeTransfer(sent.Transfer, tas.ID), "Transfer token not given in test %d", i)
+		assert.Equal(true, ok, "Render not stored in test %d", i)
+		if ok {
+			rd, ok := tmpMap.(*sync.Map).Load(tas.Key())
+			assert.Equal(true, ok, "Render not stored in test %d", i)
+			if ok {
+				assert.Equal(true, rd.(*Render).expires.After(time.Now()), "Lease not given in test %d", i)
+			}
+		}
 	}
 
 	os.RemoveAll("../../testdata/rendererapi_tests/getJob")
@@ -484,23 +887,119 @@ func TestGetJob(t *testing.T) {
 func TestPostJob(t *testing.T) {
 	assert := assert.New(t)
 
-	dataTab := []url.Values{{}}
+	dataTab := []url.Values{{}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}}
 
 	//Creating request and recorder
 	//The request must be a post with api_key, project, input, output, frameStart, frameStop, rendererName, rendererVersion, startTime
-	dataTab[0].Set("api_key", "test_api")
-	dataTab[0].Set("name", "localhost")
-	dataTab[0].Set("project", "cube")
-	dataTab[0].Set("input", "cube.blend")
-	dataTab[0].Set("output", "cube.blend")
-	dataTab[0].Set("frameStart", "127")
-	dataTab[0].Set("frameStop", "128")
-	dataTab[0].Set("rendererName", "blender")
-	dataTab[0].Set("rendererVersion", "2.91.0")
-	dataTab[0].Set("startTime", "123")
-
-	expectedReturnCode := []int{200}
-	expectedUploadState := []string{"ready"}
+	for i := 0; i < len(dataTab); i++ {
+		dataTab[i].Set("api_key", "test_api")
+		dataTab[i].Set("name", "localhost")
+		dataTab[i].Set("project", "cube")
+		dataTab[i].Set("input", "cube.blend")
+		dataTab[i].Set("output", "cube.blend")
+		dataTab[i].Set("frameStart", "127")
+		dataTab[i].Set("frameStop", "128")
+		dataTab[i].Set("rendererName", "blender")
+		dataTab[i].Set("rendererVersion", "2.91.0")
+		dataTab[i].Set("startTime", "123")
+	}
+
+	dataTab[1].Set("scene", "Preview")
+	dataTab[1].Set("camera", "CamA")
+	dataTab[1].Set("resolutionX", "1920")
+	dataTab[1].Set("resolutionY", "1080")
+	dataTab[1].Set("resolutionPercent", "50")
+	dataTab[1].Set("samples", "64")
+	dataTab[1].Set("format", "exr")
+	dataTab[1].Set("colorDepth", "32")
+
+	dataTab[2].Set("samples", "many")
+
+	dataTab[3].Set("format", "JPEG")
+	dataTab[3].Set("colorDepth", "16")
+
+	dataTab[4].Set("input", "scenes/cube.blend")
+	dataTab[4].Set("bundle", "cube.zip")
+
+	dataTab[5].Set("bundle", "../cube.zip")
+
+	dataTab[6].Set("input", "../cube.blend")
+	dataTab[6].Set("bundle", "cube.tar.gz")
+
+	dataTab[7].Set("priority", "5")
+
+	dataTab[8].Set("priority", "high")
+
+	dataTab[9].Set("rendererVersion", ">=3.6 <4.0")
+
+	dataTab[10].Set("rendererVersion", ">=latest")
+
+	dataTab[11].Set("chunkSize", "5")
+
+	dataTab[12].Set("chunkSize", "0")
+
+	dataTab[13].Set("frameStart", "first")
+
+	dataTab[14].Del("frameStop")
+
+	dataTab[15].Set("frameStop", "120")
+
+	dataTab[16].Set("frameStop", "131")
+	dataTab[16].Set("frameStep", "2")
+
+	dataTab[17].Set("frameStep", "0")
+
+	dataTab[18].Del("frameStart")
+	dataTab[18].Del("frameStop")
+	dataTab[18].Set("frames", "127,130-140x5")
+
+	dataTab[19].Set("frames", "127-a")
+
+	dataTab[20].Set("tilesX", "2")
+	dataTab[20].Set("tilesY", "2")
+
+	dataTab[21].Set("tilesX", "2")
+	dataTab[21].Set("format", "EXR")
+
+	dataTab[22].Set("tilesY", "3")
+	dataTab[22].Set("rendererName", "povray")
+
+	dataTab[23].Set("input", "../cube.blend")
+
+	dataTab[24].Set("output", "../../cube")
+
+	dataTab[25].Set("rendererVersion", "latest")
+
+	dataTab[26].Set("frameStop", "2000000000")
+
+	dataTab[27].Set("frames", "9223372036854775806-9223372036854775807,0-2000000000")
+
+	expectedReturnCode := []int{200, 200, 400, 400, 200, 400, 400, 200, 400, 200, 400, 200, 400, 400, 400, 400, 200, 400, 200, 400, 200, 400, 400, 400, 400, 200, 400, 400}
+	expectedUploadState := []string{"ready", "ready", "Error : invalid parameter 'samples'", "Error : color depth 16 isn't available for format JPEG",
+		"ready", "Error : invalid bundle '../cube.zip'", "Error : invalid main file '../cube.blend' of bundle",
+		"ready", "Error : invalid parameter 'priority'", "ready", "Error : invalid parameter 'rendererVersion'",
+		"ready", "Error : invalid parameter 'chunkSize'", "Error : invalid parameter 'frameStart'",
+		"Error : invalid parameter 'frameStop'", "Error : frameStop 120 is before frameStart 127", "ready",
+		"Error : invalid parameter 'frameStep'", "ready", "Error : invalid frames '127-a'", "ready",
+		"Error : tiles can't be stitched in format EXR, only in PNG", "Error : only blender can render tiles",
+		"Error : invalid parameter 'input'", "Error : invalid parameter 'output'", "ready",
+		"Error : more than 100000 frames from frameStart 127 to frameStop 2000000000",
+		"Error : more than 100000 frames in '9223372036854775806-9223372036854775807,0-2000000000'"}
+	expectedBundle := []string{"", "", "", "", "cube.zip", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", "", ""}
+	expectedPriority := []int{0, 0, 0, 0, 0, 0, 0, 5, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+	expectedLastFrame := []int{127, 127, 0, 0, 127, 0, 0, 127, 0, 127, 0, 128, 0, 0, 0, 0, 127, 0, 127, 0, 127, 0, 0, 0, 0, 127, 0, 0}
+	expectedFrames := [][]int{{127, 128}, {127, 128}, nil, nil, {127, 128}, nil, nil, {127, 128}, nil, {127, 128}, nil,
+		{127}, nil, nil, nil, nil, {127, 129, 131}, nil, {127, 130, 135, 140}, nil, {127, 127, 127, 127, 128, 128, 128, 128}, nil, nil, nil, nil, {127, 128}, nil, nil}
+	expectedSettings := []render.Settings{{}, {
+		Scene:             "Preview",
+		Camera:            "CamA",
+		ResolutionX:       1920,
+		ResolutionY:       1080,
+		ResolutionPercent: 50,
+		Samples:           64,
+		Format:            "EXR",
+		ColorDepth:        "32",
+	}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {}, {TilesX: 2, TilesY: 2}, {}, {}, {}, {}, {}, {}, {}}
 
 	for i := 0; i < len(dataTab); i++ {
 
@@ -535,7 +1034,9 @@ func TestPostJob(t *testing.T) {
 			RenderNodes: nodesT,
 			Renders:     rendersT,
 			Tasks:       tasksT,
+			Paused:      new(sync.Map),
 			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
 		}
 
 		//Creating request
@@ -555,8 +1056,28 @@ func TestPostJob(t *testing.T) {
 
 		//Asserts
 		assert.Equal(expectedReturnCode[i], resp.StatusCode, "Bad status code in test %d : %d instead of %d", i, resp.StatusCode, expectedReturnCode[i])
+		assert.Equal(expectedUploadState[i], dt.State, "Bad state of upload in test %d", i)
 		if expectedReturnCode[i] == 200 {
-			assert.Equal(expectedUploadState[i], dt.State, "Bad state of upload in test %d", i)
+			tmpMap, ok := tasksT.Load(dt.Token)
+			assert.Equal(true, ok, "Job not stored in test %d", i)
+			assert.Equal(true, ws.AuthorizeTransfer(dt.Transfer, dt.Token), "Transfer token not given in test %d", i)
+			if ok {
+				tas, ok := tmpMap.(*sync.Map).Load(render.TaskKey{Frame: 127})
+				assert.Equal(true, ok, "Frame not stored in test %d", i)
+				assert.Equal(expectedSettings[i], tas.(*render.Task).Settings, "Bad settings stored in test %d", i)
+				assert.Equal(expectedBundle[i], tas.(*render.Task).Bundle, "Bad bundle stored in test %d", i)
+				assert.Equal(expectedPriority[i], tas.(*render.Task).Priority, "Bad priority stored in test %d", i)
+				assert.NotEqual(int64(0), tas.(*render.Task).Submitted, "Submission time not stored in test %d", i)
+				assert.Equal("test_api", tas.(*render.Task).Owner, "Bad owner stored in test %d", i)
+				assert.Equal(expectedLastFrame[i], tas.(*render.Task).LastFrame(), "Bad chunk stored in test %d", i)
+
+				frames := []int{}
+				tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+					frames = append(frames, key.(render.TaskKey).Frame)
+					return true
+				})
+				assert.ElementsMatch(expectedFrames[i], frames, "Bad frames stored in test %d", i)
+			}
 		}
 	}
 }
@@ -564,18 +1085,24 @@ func TestPostJob(t *testing.T) {
 func TestPostNode(t *testing.T) {
 	assert := assert.New(t)
 
-	dataTab := []url.Values{{}, {}}
+	dataTab := []url.Values{{}, {}, {}}
 	//Creating request and recorder
 	dataTab[0].Set("api_key", "test_api")
 	dataTab[0].Set("name", "localhost")
+	dataTab[0].Set("renderers", `[{"name":"blender","version":"2.91.0"}]`)
 
 	dataTab[1].Set("api_key", "test_api")
 	dataTab[1].Set("name", "localhost2")
 
-	expectedNodeState := []string{"available", "available"}
-	expectedReturnCode := []string{"Exists", "Added"}
-	nodeName := []string{"localhost", "localhost2"}
-	expectedDBOP := []int{rendererdb.UPDATENODE, rendererdb.INSERTNODE}
+	dataTab[2].Set("api_key", "test_api")
+	dataTab[2].Set("name", "localhost")
+	dataTab[2].Set("renderers", "blender")
+
+	expectedNodeState := []string{"available", "available", "down"}
+	expectedReturnCode := []string{"Exists", "Added", "Error : invalid parameter 'renderers'"}
+	expectedRenderers := [][]node.Renderer{{{Name: "blender", Version: "2.91.0"}}, {}, {}}
+	nodeName := []string{"localhost", "localhost2", "localhost"}
+	expectedDBOP := []int{rendererdb.UPDATENODE, rendererdb.INSERTNODE, -1}
 
 	for i := 0; i < len(dataTab); i++ {
 
@@ -613,7 +1140,7 @@ func TestPostNode(t *testing.T) {
 		tasksT := new(sync.Map)
 		newMap := new(sync.Map)
 		tmpMap, _ := tasksT.LoadOrStore(tas.ID, newMap)
-		tmpMap.(*sync.Map).Store(tas.Frame, tas)
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
 
 		DBT := fifo.NewQueue()
 
@@ -622,7 +1149,9 @@ func TestPostNode(t *testing.T) {
 			RenderNodes: nodesT,
 			Renders:     rendersT,
 			Tasks:       tasksT,
+			Paused:      new(sync.Map),
 			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
 		}
 
 		//Creating request
@@ -646,10 +1175,15 @@ func TestPostNode(t *testing.T) {
 		assert.Equal("application/json", resp.Header.Get("Content-Type"), "Bad header in test %d", i)
 		assert.Equal(expectedNodeState[i], testNode.(*node.Node).State(), "Bad state assigned to node in test %d", i)
 		assert.Equal(expectedReturnCode[i], dt.State, "Bad state assigned to task in test %d", i)
+		assert.ElementsMatch(expectedRenderers[i], testNode.(*node.Node).GetRenderers(), "Bad renderers assigned to node in test %d", i)
 		currentTrans := ws.DBTransacts.Next()
+		if expectedDBOP[i] == -1 {
+			assert.Nil(currentTrans, "DBTransaction created by an invalid request in test %d", i)
+			continue
+		}
 		assert.NotEqual(nil, currentTrans, "Couldn't retrieve the DBTransaction associated withe postNode, test n°%d", i)
-		assert.Equal(expectedDBOP[i], currentTrans.(rendererdb.DBTransact).OP, "The DBTransaction created by test %d isn't correct", i)
-		assert.Equal(testNode.(*node.Node), currentTrans.(rendererdb.DBTransact).Argument, "Bad argument passed to the DBtransaction in test %d", i)
+		assert.Equal(expectedDBOP[i], currentTrans.(*rendererdb.DBTransact).OP, "The DBTransaction created by test %d isn't correct", i)
+		assert.Equal(testNode.(*node.Node), currentTrans.(*rendererdb.DBTransact).Argument, "Bad argument passed to the DBtransaction in test %d", i)
 	}
 }
 
@@ -699,7 +1233,9 @@ func TestSetAvailable(t *testing.T) {
 			RenderNodes: nodesT,
 			Renders:     rendersT,
 			Tasks:       tasksT,
+			Paused:      new(sync.Map),
 			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
 		}
 
 		//Creating request
@@ -731,7 +1267,7 @@ func TestUpdateJob(t *testing.T) {
 
 	assert := assert.New(t)
 
-	dataTab := []url.Values{{}, {}, {}, {}, {}}
+	dataTab := []url.Values{{}, {}, {}, {}, {}, {}, {}, {}}
 
 	os.MkdirAll("../../testdata/rendererapi_tests/updateJob", os.ModePerm)
 	copy("../../testdata/rendererapi_tests/testUpdateJob.sql", "../../testdata/rendererapi_tests/updateJob/testUpdateJob.sql")
@@ -776,16 +1312,50 @@ func TestUpdateJob(t *testing.T) {
 	dataTab[4].Set("mem", "0.0")
 	dataTab[4].Set("name", "localhost")
 
-	expectedMem := []string{"2.0", "0.0", "0.0", "0.0", "0.0"}
-	expectedPercent := []string{"1.0", "0.0", "100.0", "0.0", "0.0"}
+	dataTab[5].Set("api_key", "test_api")
+	dataTab[5].Set("id", "test_api")
+	dataTab[5].Set("frame", "127")
+	dataTab[5].Set("state", "rendering")
+	dataTab[5].Set("percent", "0.5")
+	dataTab[5].Set("mem", "20.0")
+	dataTab[5].Set("warning", "invalid memory value 1.2.3M")
+	dataTab[5].Set("name", "localhost")
+
+	//The render failed on the node
+	dataTab[6].Set("api_key", "test_api")
+	dataTab[6].Set("id", "test_api")
+	dataTab[6].Set("frame", "127")
+	dataTab[6].Set("state", "error")
+	dataTab[6].Set("percent", "0.0")
+	dataTab[6].Set("mem", "0.0")
+	dataTab[6].Set("warning", "the render didn't start")
+	dataTab[6].Set("name", "localhost")
+
+	dataTab[7].Set("api_key", "test_api")
+	dataTab[7].Set("id", "test_api")
+	dataTab[7].Set("frame", "127")
+	dataTab[7].Set("state", "done")
+	dataTab[7].Set("percent", "100.0")
+	dataTab[7].Set("mem", "0.0")
+	dataTab[7].Set("name", "localhost")
+
+	expectedMem := []string{"2.0", "0.0", "0.0", "0.0", "0.0", "20.0", "0.0", "0.0"}
+	expectedPercent := []string{"1.0", "0.0", "100.0", "0.0", "0.0", "0.5", "0.0", "0.0"}
 	expectedReturn := []ReturnValue{
 		{State: "OK"},
 		{State: "Error : No matching Renders"},
 		{State: "OK"},
 		{State: "Error : No matching Renders"},
-		{State: "Error : Missing Parameter"}}
-	expectedNodeState := []string{"rendering", "rendering", "available", "rendering", "rendering"}
-	expectedState := []string{"rendering", "rendering", "rendered", "rendering", "rendering"}
+		{State: "Error : Missing Parameter"},
+		{State: "OK"},
+		{State: "FAILED"},
+		{State: "Error : invalid parameter 'state'"}}
+	expectedNodeState := []string{"rendering", "rendering", "available", "rendering", "rendering", "rendering", "available", "rendering"}
+	expectedState := []string{"rendering", "rendering", "rendered", "rendering", "rendering", "rendering", "waiting", "rendering"}
+	expectedWarning := []string{"", "", "", "", "", "invalid memory value 1.2.3M", "", ""}
+	expectedRenewed := []bool{true, false, true, false, false, true, false, false}
+	expectedAttempts := []int{0, 0, 0, 0, 0, 0, 1, 0}
+	expectedError := []string{"", "", "", "", "", "", "render failed on node localhost : the render didn't start", ""}
 
 	for i := 0; i < len(dataTab); i++ {
 
@@ -830,12 +1400,12 @@ func TestUpdateJob(t *testing.T) {
 		rendersT := new(sync.Map)
 		newMap := new(sync.Map)
 		tmpMap, _ := rendersT.LoadOrStore(tas.ID, newMap)
-		tmpMap.(*sync.Map).Store(tas.Frame, rd)
+		tmpMap.(*sync.Map).Store(tas.Key(), rd)
 
 		tasksT := new(sync.Map)
 		newMap2 := new(sync.Map)
 		tmpMap2, _ := tasksT.LoadOrStore(tas.ID, newMap2)
-		tmpMap2.(*sync.Map).Store(tas.Frame, tas)
+		tmpMap2.(*sync.Map).Store(tas.Key(), tas)
 
 		DBT := fifo.NewQueue()
 		ws := WorkingSet{
@@ -844,7 +1414,9 @@ func TestUpdateJob(t *testing.T) {
 			RenderNodes: nodesT,
 			Renders:     rendersT,
 			Tasks:       tasksT,
+			Paused:      new(sync.Map),
 			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
 		}
 
 		//Creating request
@@ -869,7 +1441,1350 @@ func TestUpdateJob(t *testing.T) {
 		assert.Equal(expectedReturn[i], *dt, "Bad result returned in test %d", i)
 		assert.Equal(expectedState[i], rd.myTask.State)
 		assert.Equal(expectedNodeState[i], rd.myNode.State())
+		assert.Equal(expectedWarning[i], rd.Warning, "Bad warning stored in test %d", i)
+		assert.Equal(expectedRenewed[i], !rd.expires.IsZero(), "Bad renewal of the lease in test %d", i)
+		assert.Equal(expectedAttempts[i], rd.myTask.Attempts, "Bad number of attempts in test %d", i)
+		assert.Equal(expectedError[i], rd.myTask.LastError, "Bad error stored in test %d", i)
+		_, rendering := tmpMap.(*sync.Map).Load(tas.Key())
+		assert.Equal(expectedState[i] == "rendering", rendering, "Bad renders in test %d", i)
 	}
 
 	os.RemoveAll("../../testdata/rendererapi_tests/updateJob")
 }
+
+func TestReapExpiredRenders(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+
+	nodes := []*node.Node{
+		{Name: "expired", IP: "127.0.0.1", APIKey: "test_api"},
+		{Name: "alive", IP: "127.0.0.1", APIKey: "test_api"},
+		{Name: "aborted", IP: "127.0.0.1", APIKey: "test_api"},
+	}
+	initialNodeState := []string{"rendering", "rendering", "available"}
+	initialTaskState := []string{"rendering", "rendering", "abort"}
+	expires := []time.Time{now.Add(-time.Second), now.Add(time.Minute), now.Add(-time.Minute)}
+
+	expectedNodeState := []string{"lost", "rendering", "available"}
+	expectedTaskState := []string{"waiting", "rendering", "abort"}
+	expectedRender := []bool{false, true, false}
+	expectedAttempts := []int{1, 0, 0}
+
+	rendersT := new(sync.Map)
+	tasksT := new(sync.Map)
+	nodesT := new(sync.Map)
+	renders := make([]*Render, len(nodes))
+
+	for i := 0; i < len(nodes); i++ {
+		nodes[i].SetState(initialNodeState[i])
+		nodesT.Store(nodes[i].Name+"//"+nodes[i].IP, nodes[i])
+
+		tas := &render.Task{
+			Project:         "cube",
+			ID:              "test_api",
+			Input:           "cube.blend",
+			Output:          "cube.blend",
+			Frame:           i,
+			State:           initialTaskState[i],
+			RendererName:    "blender",
+			RendererVersion: "2.91.0",
+		}
+		tmpMap, _ := tasksT.LoadOrStore(tas.ID, new(sync.Map))
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+
+		renders[i] = &Render{
+			myTask:  tas,
+			myNode:  nodes[i],
+			Percent: "0.5",
+			Mem:     "10.0",
+			expires: expires[i],
+		}
+		tmpMap, _ = rendersT.LoadOrStore(tas.ID, new(sync.Map))
+		tmpMap.(*sync.Map).Store(tas.Key(), renders[i])
+	}
+
+	DBT := fifo.NewQueue()
+	ws := WorkingSet{
+		Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+		RenderNodes: nodesT,
+		Renders:     rendersT,
+		Tasks:       tasksT,
+		Paused:      new(sync.Map),
+		DBTransacts: DBT,
+		Scheduler:   scheduler.NewFIFO(),
+	}
+
+	assert.Equal(2, ws.ReapExpiredRenders(now), "Bad number of renders reaped")
+
+	for i := 0; i < len(nodes); i++ {
+		tmpMap, _ := rendersT.Load("test_api")
+		_, ok := tmpMap.(*sync.Map).Load(render.TaskKey{Frame: i})
+		assert.Equal(expectedRender[i], ok, "Bad presence of render %d", i)
+		assert.Equal(expectedNodeState[i], nodes[i].State(), "Bad node state in test %d", i)
+		assert.Equal(expectedTaskState[i], renders[i].myTask.State, "Bad task state in test %d", i)
+		assert.Equal(expectedAttempts[i], renders[i].myTask.Attempts, "Bad number of attempts in test %d", i)
+		assert.Empty(renders[i].myTask.FailedNodes, "Lost node excluded in test %d", i)
+	}
+
+	//The requeued task and the lost node are updated in database
+	assert.Equal(2, DBT.Len(), "Bad number of database transactions")
+	assert.Equal(rendererdb.UPDATETASK, DBT.Next().(*rendererdb.DBTransact).OP)
+	assert.Equal(rendererdb.UPDATENODE, DBT.Next().(*rendererdb.DBTransact).OP)
+}
+
+func TestAuthorizeTransfer(t *testing.T) {
+	assert := assert.New(t)
+
+	now := time.Now()
+	ws := WorkingSet{
+		Config: Configuration{UserAPIKeys: []string{"test_api"}, TransferSeconds: 60},
+	}
+
+	token, err := ws.newTransfer("job")
+	assert.NoError(err)
+	ws.transfers.Store("expired", &transfer{id: "job", expires: now.Add(-time.Second)})
+
+	credentials := []string{"test_api", "test_api", token, token, "expired", "wrong_key", ""}
+	IDs := []string{"job", "other_job", "job", "other_job", "job", "job", "job"}
+	expected := []bool{true, true, true, false, false, false, false}
+
+	for i := 0; i < len(credentials); i++ {
+		assert.Equal(expected[i], ws.AuthorizeTransfer(credentials[i], IDs[i]), "Bad authorization in test %d", i)
+	}
+
+	//Only the expired token is forgotten
+	ws.dropExpiredTransfers(now)
+	_, ok := ws.transfers.Load("expired")
+	assert.Equal(false, ok, "Expired token kept")
+	assert.Equal(true, ws.AuthorizeTransfer(token, "job"), "Valid token dropped")
+
+	ws.dropExpiredTransfers(now.Add(2 * time.Minute))
+	assert.Equal(false, ws.AuthorizeTransfer(token, "job"), "Token kept after its expiration")
+}
+
+func TestGetJobOrder(t *testing.T) {
+	assert := assert.New(t)
+
+	//Each test is a list of waiting tasks described by ID, frame, priority and submission time
+	type waiting struct {
+		id        string
+		frame     int
+		priority  int
+		submitted int64
+	}
+
+	tests := [][]waiting{
+		{{"a", 1, 0, 100}, {"a", 2, 0, 100}, {"b", 5, 0, 50}, {"b", 3, 0, 50}, {"c", 7, 10, 200}, {"d", 1, -1, 10}, {"e", 4, 0, 50}},
+		{{"a", 3, 0, 10}, {"a", 1, 0, 10}, {"a", 2, 0, 10}},
+		{{"a", 1, 1, 30}, {"b", 1, 2, 20}, {"c", 1, 3, 10}},
+		{},
+	}
+
+	expectedOrder := [][]string{
+		{"c/7", "b/3", "b/5", "e/4", "a/1", "a/2", "d/1"},
+		{"a/1", "a/2", "a/3"},
+		{"c/1", "b/1", "a/1"},
+		{},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		nd := &node.Node{
+			Name:      "localhost",
+			IP:        "127.0.0.1",
+			APIKey:    "test_api",
+			Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
+		}
+
+		nodesT := new(sync.Map)
+		nodesT.Store(nd.Name+"//"+nd.IP, nd)
+
+		sched := scheduler.NewFIFO()
+		tasksT := new(sync.Map)
+		for _, wt := range tests[i] {
+			tas := &render.Task{
+				Project:         "cube",
+				ID:              wt.id,
+				Frame:           wt.frame,
+				State:           "waiting",
+				RendererName:    "blender",
+				RendererVersion: "2.91.0",
+				Priority:        wt.priority,
+				Submitted:       wt.submitted,
+			}
+			tmpMap, _ := tasksT.LoadOrStore(wt.id, new(sync.Map))
+			tmpMap.(*sync.Map).Store(tas.Key(), tas)
+			sched.Add(tas)
+		}
+
+		ws := WorkingSet{
+			Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+			RenderNodes: nodesT,
+			Renders:     new(sync.Map),
+			Tasks:       tasksT,
+			Paused:      new(sync.Map),
+			DBTransacts: fifo.NewQueue(),
+			Scheduler:   sched,
+		}
+
+		order := []string{}
+		for j := 0; j <= len(tests[i]); j++ {
+			nd.SetState("available")
+
+			data := url.Values{"api_key": {"test_api"}, "name": {"localhost"}}
+			r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/getJob", strings.NewReader(data.Encode()))
+			r.RemoteAddr = "127.0.0.1:1001"
+			r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			ws.GetJob(w, r)
+			dt := new(render.Task)
+			json.NewDecoder(w.Result().Body).Decode(dt)
+
+			if dt.ID != "" {
+				order = append(order, dt.ID+"/"+strconv.Itoa(dt.Frame))
+			}
+		}
+
+		assert.Equal(expectedOrder[i], order, "Bad scheduling order in test %d", i)
+	}
+}
+
+//recordingScheduler records the frames added to its scheduler
+type recordingScheduler struct {
+	scheduler.Scheduler
+	added []int
+}
+
+func (s *recordingScheduler) Add(t *render.Task) {
+	s.added = append(s.added, t.Frame)
+	s.Scheduler.Add(t)
+}
+
+func TestSetPriority(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTab := []url.Values{
+		{"api_key": {"test_api"}, "id": {"test_api"}, "priority": {"3"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}, "priority": {"urgent"}},
+		{"api_key": {"test_api"}, "id": {"unknown"}, "priority": {"3"}},
+		{"api_key": {"test_api"}, "priority": {"3"}},
+		{"api_key": {"wrong_api"}, "id": {"test_api"}, "priority": {"3"}},
+	}
+
+	expectedCode := []int{200, 400, 404, 400, 404}
+	expectedState := []string{"OK", "Error : invalid parameter 'priority'", "Error : can't find job", "Error : Missing Parameter", ""}
+	expectedPriority := []int{3, 0, 0, 0, 0}
+	expectedTransacts := []int{2, 0, 0, 0, 0}
+	expectedAdded := [][]int{{1}, nil, nil, nil, nil}
+
+	for i := 0; i < len(dataTab); i++ {
+		tasksT := new(sync.Map)
+		tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+		tasks := []*render.Task{{ID: "test_api", Frame: 1, State: "waiting"}, {ID: "test_api", Frame: 2, State: "rendered"}}
+		for _, tas := range tasks {
+			tmpMap.(*sync.Map).Store(tas.Key(), tas)
+		}
+
+		DBT := fifo.NewQueue()
+		sched := &recordingScheduler{Scheduler: scheduler.NewFIFO()}
+		ws := WorkingSet{
+			Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+			RenderNodes: new(sync.Map),
+			Renders:     new(sync.Map),
+			Tasks:       tasksT,
+			Paused:      new(sync.Map),
+			DBTransacts: DBT,
+			Scheduler:   sched,
+		}
+
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/setPriority", strings.NewReader(dataTab[i].Encode()))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		ws.SetPriority(w, r)
+		resp := w.Result()
+		dt := new(ReturnValue)
+		json.NewDecoder(resp.Body).Decode(dt)
+
+		assert.Equal(expectedCode[i], resp.StatusCode, "Bad status code in test %d", i)
+		assert.Equal(expectedState[i], dt.State, "Bad state returned in test %d", i)
+		for _, tas := range tasks {
+			assert.Equal(expectedPriority[i], tas.Priority, "Bad priority of frame %d in test %d", tas.Frame, i)
+		}
+		assert.Equal(expectedTransacts[i], DBT.Len(), "Bad number of database transactions in test %d", i)
+		assert.Equal(expectedAdded[i], sched.added, "Bad frames rescheduled in test %d", i)
+	}
+}
+
+func TestGetJobFairShare(t *testing.T) {
+	assert := assert.New(t)
+
+	//Each test is a list of waiting tasks described by ID, owner, project, frame, priority and submission time
+	type waiting struct {
+		id        string
+		owner     string
+		project   string
+		frame     int
+		priority  int
+		submitted int64
+	}
+
+	big := []waiting{
+		{"a", "alice", "film", 1, 0, 10}, {"a", "alice", "film", 2, 0, 10}, {"a", "alice", "film", 3, 0, 10}, {"a", "alice", "film", 4, 0, 10},
+		{"b", "bob", "ad", 1, 0, 20}, {"b", "bob", "ad", 2, 0, 20},
+	}
+	projects := []waiting{
+		{"a", "alice", "film", 1, 0, 10}, {"a", "alice", "film", 2, 0, 10}, {"a", "alice", "film", 3, 0, 10},
+		{"t", "alice", "teaser", 1, 0, 20}, {"t", "alice", "teaser", 2, 0, 20},
+		{"u", "alice", "teaser", 1, 5, 30},
+	}
+
+	tests := [][]waiting{big, big, big, projects, projects}
+	kind := []string{"fifo", "fairshare", "fairshare", "fairshare", "fairshare"}
+	ownerWeights := []map[string]float64{nil, nil, {"alice": 2}, nil, nil}
+	projectWeights := []map[string]float64{nil, nil, nil, nil, {"film": 3}}
+	nodes := []int{4, 4, 5, 5, 5}
+
+	expectedOrder := [][]string{
+		{"a/1", "a/2", "a/3", "a/4"},
+		{"a/1", "b/1", "a/2", "b/2"},
+		{"a/1", "b/1", "a/2", "b/2", "a/3"},
+		{"u/1", "a/1", "a/2", "t/1", "a/3"},
+		{"u/1", "a/1", "a/2", "a/3", "t/1"},
+	}
+
+	for i := 0; i < len(tests); i++ {
+		nodesT := new(sync.Map)
+		for j := 0; j < nodes[i]; j++ {
+			nd := &node.Node{
+				Name:      "node" + strconv.Itoa(j),
+				IP:        "127.0.0.1",
+				APIKey:    "test_api",
+				Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
+			}
+			nd.SetState("available")
+			nodesT.Store(nd.Name+"//"+nd.IP, nd)
+		}
+
+		cg := Configuration{
+			UserAPIKeys:    []string{"test_api"},
+			Scheduler:      kind[i],
+			OwnerWeights:   ownerWeights[i],
+			ProjectWeights: projectWeights[i],
+		}
+		assert.NoError(cg.Validate())
+		sched, _ := cg.NewScheduler()
+
+		tasksT := new(sync.Map)
+		for _, wt := range tests[i] {
+			tas := &render.Task{
+				Project:         wt.project,
+				ID:              wt.id,
+				Frame:           wt.frame,
+				State:           "waiting",
+				RendererName:    "blender",
+				RendererVersion: "2.91.0",
+				Priority:        wt.priority,
+				Submitted:       wt.submitted,
+				Owner:           wt.owner,
+			}
+			tmpMap, _ := tasksT.LoadOrStore(wt.id, new(sync.Map))
+			tmpMap.(*sync.Map).Store(tas.Key(), tas)
+			sched.Add(tas)
+		}
+
+		ws := WorkingSet{
+			Config:      cg,
+			RenderNodes: nodesT,
+			Renders:     new(sync.Map),
+			Tasks:       tasksT,
+			Paused:      new(sync.Map),
+			DBTransacts: fifo.NewQueue(),
+			Scheduler:   sched,
+		}
+
+		//Every node keeps rendering the frame it was given
+		order := []string{}
+		for j := 0; j < nodes[i]; j++ {
+			data := url.Values{"api_key": {"test_api"}, "name": {"node" + strconv.Itoa(j)}}
+			r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/getJob", strings.NewReader(data.Encode()))
+			r.RemoteAddr = "127.0.0.1:1001"
+			r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+			w := httptest.NewRecorder()
+			ws.GetJob(w, r)
+			dt := new(render.Task)
+			json.NewDecoder(w.Result().Body).Decode(dt)
+
+			order = append(order, dt.ID+"/"+strconv.Itoa(dt.Frame))
+		}
+
+		assert.Equal(expectedOrder[i], order, "Bad scheduling order in test %d", i)
+	}
+
+	invalid := []Configuration{
+		{Scheduler: "lottery"},
+		{Scheduler: "fairshare", OwnerWeights: map[string]float64{"alice": 0}},
+		{Scheduler: "fairshare", ProjectWeights: map[string]float64{"film": -1}},
+	}
+	for i, c := range invalid {
+		assert.Error(c.Validate(), "Invalid configuration accepted in test %d", i)
+	}
+}
+
+func TestRequeueChunk(t *testing.T) {
+
+	assert := assert.New(t)
+
+	updates := []url.Values{{}, {}, {}}
+	for i := range updates {
+		updates[i].Set("api_key", "test_api")
+		updates[i].Set("id", "test_api")
+		updates[i].Set("frame", "10")
+		updates[i].Set("percent", "0.5")
+		updates[i].Set("mem", "2.0")
+		updates[i].Set("name", "localhost")
+	}
+	updates[0].Set("state", "rendering")
+	updates[0].Set("done", "many")
+	updates[1].Set("state", "rendering")
+	updates[1].Set("done", "2")
+	updates[2].Set("state", "requeue")
+	updates[2].Set("done", "1")
+
+	expectedReturn := []string{"Error : invalid parameter 'done'", "OK", "REQUEUED"}
+	expectedDone := []int{0, 2, 2}
+
+	nd := &node.Node{
+		Name:      "localhost",
+		IP:        "127.0.0.1",
+		APIKey:    "test_api",
+		Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
+	}
+	nd.SetState("rendering")
+
+	tas := &render.Task{
+		Project:         "cube",
+		ID:              "test_api",
+		Input:           "cube.blend",
+		Output:          "cube.blend",
+		Frame:           10,
+		FrameEnd:        13,
+		State:           "rendering",
+		RendererName:    "blender",
+		RendererVersion: "2.91.0",
+	}
+
+	rd := &Render{
+		myTask:  tas,
+		myNode:  nd,
+		Percent: "0.0",
+		Mem:     "0.0",
+	}
+
+	nodesT := new(sync.Map)
+	nodesT.Store(nd.Name+"//"+nd.IP, nd)
+
+	rendersT := new(sync.Map)
+	tmpMap, _ := rendersT.LoadOrStore(tas.ID, new(sync.Map))
+	tmpMap.(*sync.Map).Store(tas.Key(), rd)
+
+	tasksT := new(sync.Map)
+	tmpMap, _ = tasksT.LoadOrStore(tas.ID, new(sync.Map))
+	tmpMap.(*sync.Map).Store(tas.Key(), tas)
+
+	ws := WorkingSet{
+		Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+		RenderNodes: nodesT,
+		Renders:     rendersT,
+		Tasks:       tasksT,
+		Paused:      new(sync.Map),
+		DBTransacts: fifo.NewQueue(),
+		Scheduler:   scheduler.NewFIFO(),
+	}
+
+	for i := 0; i < len(updates); i++ {
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/updateJob", strings.NewReader(updates[i].Encode()))
+		r.RemoteAddr = "127.0.0.1:1001"
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		ws.UpdateJob(w, r)
+		dt := new(ReturnValue)
+		json.NewDecoder(w.Result().Body).Decode(dt)
+
+		assert.Equal(expectedReturn[i], dt.State, "Bad result returned in test %d", i)
+		assert.Equal(expectedDone[i], rd.done, "Bad number of frames done in test %d", i)
+	}
+
+	//The done frames stay rendered, the others are waiting in a new task
+	assert.Equal("rendered", tas.State, "Bad state of the rendered part of the chunk")
+	assert.Equal(11, tas.LastFrame(), "Bad last frame of the rendered part of the chunk")
+
+	tmpMap, _ = tasksT.Load(tas.ID)
+	tmpRest, ok := tmpMap.(*sync.Map).Load(render.TaskKey{Frame: 12})
+	assert.Equal(true, ok, "Remaining frames of the chunk not stored")
+	if ok {
+		rest := tmpRest.(*render.Task)
+		assert.Equal("waiting", rest.State, "Bad state of the remaining frames of the chunk")
+		assert.Equal(13, rest.LastFrame(), "Bad last frame of the remaining frames of the chunk")
+
+		next, err := ws.Scheduler.Next(nd)
+		assert.NoError(err)
+		assert.Equal(rest, next, "Remaining frames of the chunk not given to the nodes")
+	}
+
+	expectedOP := []int{rendererdb.UPDATETASK, rendererdb.INSERTPROJECT, rendererdb.UPDATENODE}
+	for i, op := range expectedOP {
+		tr := ws.DBTransacts.Next()
+		if assert.NotNil(tr, "Missing DBTransaction %d", i) {
+			assert.Equal(op, tr.(*rendererdb.DBTransact).OP, "Bad DBTransaction %d", i)
+		}
+	}
+}
+
+func TestStitchTiles(t *testing.T) {
+
+	assert := assert.New(t)
+
+	folder, err := ioutil.TempDir("", "blenderer")
+	assert.NoError(err)
+	defer os.RemoveAll(folder)
+
+	nd := &node.Node{
+		Name:      "localhost",
+		IP:        "127.0.0.1",
+		APIKey:    "test_api",
+		Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
+	}
+	nd.SetState("rendering")
+
+	nodesT := new(sync.Map)
+	nodesT.Store(nd.Name+"//"+nd.IP, nd)
+	rendersT := new(sync.Map)
+	tasksT := new(sync.Map)
+
+	//The two tiles of frame 1, uploaded by the nodes to the folder of the job
+	os.MkdirAll(filepath.Join(folder, "test_api"), os.ModePerm)
+	tiles := []*render.Task{}
+	for i := 0; i < 2; i++ {
+		tas := &render.Task{
+			Project:         "cube",
+			ID:              "test_api",
+			Input:           "cube.blend",
+			Output:          "cube.blend",
+			Frame:           1,
+			Tile:            i,
+			State:           "rendering",
+			RendererName:    "blender",
+			RendererVersion: "2.91.0",
+			Settings:        render.Settings{TilesX: 2},
+		}
+		tiles = append(tiles, tas)
+
+		tmpMap, _ := tasksT.LoadOrStore(tas.ID, new(sync.Map))
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+		tmpMap, _ = rendersT.LoadOrStore(tas.ID, new(sync.Map))
+		tmpMap.(*sync.Map).Store(tas.Key(), &Render{myTask: tas, myNode: nd, Percent: "0.0", Mem: "0.0"})
+
+		f, err := os.Create(filepath.Join(folder, tas.ID, tas.OutputName(1)))
+		assert.NoError(err)
+		assert.NoError(png.Encode(f, image.NewNRGBA(image.Rect(0, 0, 3, 2))))
+		f.Close()
+	}
+
+	ws := WorkingSet{
+		Config:      Configuration{Folder: folder, UserAPIKeys: []string{"test_api"}},
+		RenderNodes: nodesT,
+		Renders:     rendersT,
+		Tasks:       tasksT,
+		Paused:      new(sync.Map),
+		DBTransacts: fifo.NewQueue(),
+		Scheduler:   scheduler.NewFIFO(),
+	}
+
+	updates := []url.Values{{}, {}, {}}
+	for i := range updates {
+		updates[i].Set("api_key", "test_api")
+		updates[i].Set("id", "test_api")
+		updates[i].Set("frame", "1")
+		updates[i].Set("state", "rendered")
+		updates[i].Set("percent", "1")
+		updates[i].Set("mem", "0")
+		updates[i].Set("name", "localhost")
+	}
+	updates[0].Set("tile", "first")
+	updates[1].Set("tile", "1")
+	updates[2].Set("tile", "0")
+
+	expectedReturn := []string{"Error : invalid parameter 'tile'", "OK", "OK"}
+	frame := filepath.Join(folder, "test_api", tiles[0].FrameName(1))
+
+	for i := 0; i < len(updates); i++ {
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/updateJob", strings.NewReader(updates[i].Encode()))
+		r.RemoteAddr = "127.0.0.1:1001"
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		ws.UpdateJob(w, r)
+		dt := new(ReturnValue)
+		json.NewDecoder(w.Result().Body).Decode(dt)
+
+		assert.Equal(expectedReturn[i], dt.State, "Bad result returned in test %d", i)
+	}
+
+	//The frame is stitched once both tiles are rendered
+	assert.Eventually(func() bool {
+		ws.stitchLock.Lock()
+		defer ws.stitchLock.Unlock()
+		_, err := os.Stat(frame)
+		return err == nil
+	}, time.Second, 10*time.Millisecond, "Frame not stitched")
+
+	ws.stitchLock.Lock()
+	defer ws.stitchLock.Unlock()
+	f, err := os.Open(frame)
+	if assert.NoError(err) {
+		defer f.Close()
+		img, err := png.Decode(f)
+		assert.NoError(err)
+		if err == nil {
+			assert.Equal(image.Rect(0, 0, 6, 2), img.Bounds(), "Bad size of the stitched frame")
+		}
+	}
+
+	//The checksum of the stitched frame is stored alongside it
+	sum, err := ioutil.ReadFile(frame + filexchange.ChecksumSuffix)
+	assert.NoError(err)
+	expectedSum, _ := filexchange.HashFile(frame)
+	assert.Equal(expectedSum+"\n", string(sum), "Bad checksum of the stitched frame")
+}
+
+func TestInputChecksum(t *testing.T) {
+	assert := assert.New(t)
+
+	folder := t.TempDir()
+	os.MkdirAll(filepath.Join(folder, "test_api"), os.ModePerm)
+	assert.NoError(ioutil.WriteFile(filepath.Join(folder, "test_api", "cube.blend"), []byte("blend"), 0666))
+	assert.NoError(filexchange.WriteChecksum(filepath.Join(folder, "test_api", "cube.blend"), "blend_sum"))
+	assert.NoError(ioutil.WriteFile(filepath.Join(folder, "test_api", "cube.zip"), []byte("zip"), 0666))
+	zipSum, _ := filexchange.HashFile(filepath.Join(folder, "test_api", "cube.zip"))
+
+	ws := WorkingSet{Config: Configuration{Folder: folder}}
+
+	//The checksum of the bundle is given for the jobs posted as a bundle
+	tasks := []*render.Task{
+		{ID: "test_api", Input: "cube.blend"},
+		{ID: "test_api", Input: "scenes/cube.blend", Bundle: "cube.zip"},
+		{ID: "test_api", Input: "missing.blend"},
+		{ID: "..", Input: "cube.blend"},
+	}
+	expected := []string{"blend_sum", zipSum, "", ""}
+
+	for i := 0; i < len(tasks); i++ {
+		assert.Equal(expected[i], ws.inputChecksum(tasks[i]), "Bad checksum in test %d", i)
+	}
+}
+
+func TestGetFrames(t *testing.T) {
+
+	assert := assert.New(t)
+
+	//Frames 10 and 11 are uploaded, 10 with its checksum stored
+	folder := t.TempDir()
+	os.MkdirAll(filepath.Join(folder, "test_api"), os.ModePerm)
+	assert.NoError(ioutil.WriteFile(filepath.Join(folder, "test_api", "cube_00010.png"), []byte("frame 10"), 0666))
+	assert.NoError(filexchange.WriteChecksum(filepath.Join(folder, "test_api", "cube_00010.png"), "stored_sum"))
+	assert.NoError(ioutil.WriteFile(filepath.Join(folder, "test_api", "cube_00011.png"), []byte("frame 11"), 0666))
+	sum11, _ := filexchange.HashFile(filepath.Join(folder, "test_api", "cube_00011.png"))
+
+	//Frames 20 and 30 are stitched from 2 tiles, a tile of frame 20 being rendered again
+	for _, name := range []string{"cube_00020.png", "cube_00030.png"} {
+		assert.NoError(ioutil.WriteFile(filepath.Join(folder, "test_api", name), []byte(name), 0666))
+	}
+	sum30, _ := filexchange.HashFile(filepath.Join(folder, "test_api", "cube_00030.png"))
+
+	tasksT := new(sync.Map)
+	tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+	for i, st := range []string{"rendered", "failed", "waiting"} {
+		tas := &render.Task{
+			ID:       "test_api",
+			Output:   "cube_",
+			Frame:    10 - 4*i,
+			FrameEnd: 13 - 4*i,
+			State:    st,
+		}
+		if st == "failed" {
+			tas.Fail("localhost//127.0.0.1", "error on node localhost", 1)
+		}
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+	}
+	for i, st := range []string{"rendered", "waiting", "rendered", "rendered"} {
+		tas := &render.Task{
+			ID:       "test_api",
+			Output:   "cube_",
+			Frame:    20 + 10*(i/2),
+			Tile:     i % 2,
+			State:    st,
+			Settings: render.Settings{TilesX: 2, TilesY: 1},
+		}
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+	}
+
+	ws := WorkingSet{
+		Config: Configuration{UserAPIKeys: []string{"test_api"}, Folder: folder},
+		Tasks:  tasksT,
+	}
+
+	dataTab := []url.Values{{}, {}, {}}
+	for i := range dataTab {
+		dataTab[i].Set("api_key", "test_api")
+	}
+	dataTab[0].Set("id", "test_api")
+	dataTab[1].Set("id", "missing")
+
+	expectedCode := []int{200, 404, 400}
+	expectedState := []string{"", "Error : can't find job", "Error : Missing Parameter"}
+	expectedFrames := []FrameStatus{
+		{2, 5, 0, "waiting", 0, "", nil},
+		{6, 9, 0, "failed", 1, "error on node localhost", nil},
+		{10, 13, 0, "rendered", 0, "", []Output{{"cube_00010.png", "stored_sum"}, {"cube_00011.png", sum11}}},
+		{20, 20, 0, "rendered", 0, "", []Output{}},
+		{20, 20, 1, "waiting", 0, "", nil},
+		{30, 30, 0, "rendered", 0, "", []Output{{"cube_00030.png", sum30}}},
+		{30, 30, 1, "rendered", 0, "", []Output{}},
+	}
+
+	for i := 0; i < len(dataTab); i++ {
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/getFrames", strings.NewReader(dataTab[i].Encode()))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		ws.GetFrames(w, r)
+		resp := w.Result()
+		body, _ := ioutil.ReadAll(resp.Body)
+
+		assert.Equal(expectedCode[i], resp.StatusCode, "Bad status code in test %d", i)
+		if expectedCode[i] == 200 {
+			frames := []FrameStatus{}
+			assert.NoError(json.Unmarshal(body, &frames))
+			assert.Equal(expectedFrames, frames, "Bad frames in test %d", i)
+		} else {
+			dt := new(ReturnValue)
+			json.Unmarshal(body, dt)
+			assert.Equal(expectedState[i], dt.State, "Bad state in test %d", i)
+		}
+	}
+}
+
+func TestRetryJob(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTab := []url.Values{
+		{"api_key": {"test_api"}, "id": {"test_api"}},
+		{"api_key": {"test_api"}, "id": {"unknown"}},
+		{"api_key": {"test_api"}},
+		{"api_key": {"wrong_api"}, "id": {"test_api"}},
+	}
+
+	expectedCode := []int{200, 404, 400, 404}
+	expectedState := []string{"OK", "Error : can't find job", "Error : Missing Parameter", ""}
+	expectedStates := [][]string{
+		{"rendered", "waiting", "waiting"},
+		{"rendered", "failed", "waiting"},
+		{"rendered", "failed", "waiting"},
+		{"rendered", "failed", "waiting"},
+	}
+	expectedTransacts := []int{1, 0, 0, 0}
+
+	for i := 0; i < len(dataTab); i++ {
+		tasksT := new(sync.Map)
+		tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+		tasks := []*render.Task{
+			{ID: "test_api", Frame: 1, State: "rendered", RendererName: "blender"},
+			{ID: "test_api", Frame: 2, State: "rendering", RendererName: "blender"},
+			{ID: "test_api", Frame: 3, State: "waiting", RendererName: "blender"},
+		}
+		tasks[1].Fail("localhost//127.0.0.1", "error on node localhost", 1)
+		for _, tas := range tasks {
+			tmpMap.(*sync.Map).Store(tas.Key(), tas)
+		}
+
+		DBT := fifo.NewQueue()
+		ws := WorkingSet{
+			Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+			RenderNodes: new(sync.Map),
+			Renders:     new(sync.Map),
+			Tasks:       tasksT,
+			Paused:      new(sync.Map),
+			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
+		}
+
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/retryJob", strings.NewReader(dataTab[i].Encode()))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		ws.RetryJob(w, r)
+		resp := w.Result()
+		dt := new(ReturnValue)
+		json.NewDecoder(resp.Body).Decode(dt)
+
+		assert.Equal(expectedCode[i], resp.StatusCode, "Bad status code in test %d", i)
+		assert.Equal(expectedState[i], dt.State, "Bad state returned in test %d", i)
+		for j, tas := range tasks {
+			assert.Equal(expectedStates[i][j], tas.State, "Bad state of frame %d in test %d", tas.Frame, i)
+		}
+		assert.Equal(expectedTransacts[i], DBT.Len(), "Bad number of database transactions in test %d", i)
+
+		//The retried frame is given to the nodes again, even to the one it failed on
+		if expectedCode[i] == 200 {
+			assert.Equal(0, tasks[1].Attempts, "Attempts not reset in test %d", i)
+			assert.Equal("", tasks[1].LastError, "Last error not reset in test %d", i)
+			nd := &node.Node{Name: "localhost", IP: "127.0.0.1"}
+			nd.SetState("available")
+			nd.SetRenderers([]node.Renderer{{Name: "blender", Version: "2.91.0"}})
+			tsk, err := ws.Scheduler.Next(nd)
+			assert.NoError(err, "Retried frame not scheduled in test %d", i)
+			assert.Equal(tasks[1], tsk, "Bad frame scheduled in test %d", i)
+		}
+	}
+}
+
+func TestRerenderFrames(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTab := []url.Values{
+		{"api_key": {"test_api"}, "id": {"test_api"}, "frames": {"3-4,7,12-13"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}, "frames": {"1-10"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}, "frames": {"4-2"}},
+		{"api_key": {"test_api"}, "id": {"unknown"}, "frames": {"1"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}},
+		{"api_key": {"wrong_api"}, "id": {"test_api"}, "frames": {"1"}},
+	}
+
+	expectedCode := []int{200, 200, 400, 404, 400, 404}
+	expectedState := []string{"OK", "OK", "Error : invalid frames '4-2'", "Error : can't find job", "Error : Missing Parameter", ""}
+	initial := []FrameStatus{
+		{1, 10, 0, "rendered", 0, "", nil},
+		{11, 12, 0, "failed", 1, "error on node localhost", nil},
+		{13, 14, 0, "waiting", 0, "", nil},
+	}
+	expectedFrames := [][]FrameStatus{
+		{
+			{1, 2, 0, "rendered", 0, "", nil},
+			{3, 4, 0, "waiting", 0, "", nil},
+			{5, 6, 0, "rendered", 0, "", nil},
+			{7, 7, 0, "waiting", 0, "", nil},
+			{8, 10, 0, "rendered", 0, "", nil},
+			{11, 11, 0, "failed", 1, "error on node localhost", nil},
+			{12, 12, 0, "waiting", 0, "", nil},
+			{13, 14, 0, "waiting", 0, "", nil},
+		},
+		{
+			{1, 10, 0, "waiting", 0, "", nil},
+			initial[1],
+			initial[2],
+		},
+		initial, initial, initial, initial,
+	}
+	expectedTransacts := []int{3, 1, 0, 0, 0, 0}
+	expectedScheduled := [][]int{{3, 7, 12}, {1}, nil, nil, nil, nil}
+
+	for i := 0; i < len(dataTab); i++ {
+		tasksT := new(sync.Map)
+		tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+		for _, f := range initial {
+			tas := &render.Task{ID: "test_api", Frame: f.Frame, FrameEnd: f.FrameEnd, State: "rendering", RendererName: "blender"}
+			if f.State == "failed" {
+				tas.Fail("localhost//127.0.0.1", f.LastError, 1)
+			} else {
+				tas.State = f.State
+			}
+			tmpMap.(*sync.Map).Store(tas.Key(), tas)
+		}
+
+		DBT := fifo.NewQueue()
+		ws := WorkingSet{
+			Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+			RenderNodes: new(sync.Map),
+			Renders:     new(sync.Map),
+			Tasks:       tasksT,
+			Paused:      new(sync.Map),
+			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
+		}
+
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/rerenderFrames", strings.NewReader(dataTab[i].Encode()))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		ws.RerenderFrames(w, r)
+		resp := w.Result()
+		dt := new(ReturnValue)
+		json.NewDecoder(resp.Body).Decode(dt)
+
+		assert.Equal(expectedCode[i], resp.StatusCode, "Bad status code in test %d", i)
+		assert.Equal(expectedState[i], dt.State, "Bad state returned in test %d", i)
+		assert.Equal(expectedTransacts[i], DBT.Len(), "Bad number of database transactions in test %d", i)
+
+		frames := []FrameStatus{}
+		tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+			tas := value.(*render.Task)
+			frames = append(frames, FrameStatus{tas.Frame, tas.LastFrame(), tas.Tile, tas.State, tas.Attempts, tas.LastError, nil})
+			return true
+		})
+		sort.Slice(frames, func(a, b int) bool { return frames[a].Frame < frames[b].Frame })
+		assert.Equal(expectedFrames[i], frames, "Bad frames in test %d", i)
+
+		//Only the frames rendered again are scheduled, the waiting ones were already
+		var scheduled []int
+		nd := &node.Node{Name: "localhost", IP: "127.0.0.1"}
+		nd.SetRenderers([]node.Renderer{{Name: "blender", Version: "2.91.0"}})
+		for {
+			nd.SetState("available")
+			tsk, err := ws.Scheduler.Next(nd)
+			if err != nil {
+				break
+			}
+			scheduled = append(scheduled, tsk.Frame)
+		}
+		assert.Equal(expectedScheduled[i], scheduled, "Bad frames scheduled in test %d", i)
+	}
+}
+
+func TestRetryPausedJob(t *testing.T) {
+	assert := assert.New(t)
+
+	tasksT := new(sync.Map)
+	tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+	tasks := []*render.Task{
+		{ID: "test_api", Frame: 1, State: "rendered", RendererName: "blender"},
+		{ID: "test_api", Frame: 2, State: "rendering", RendererName: "blender"},
+		{ID: "test_api", Frame: 3, State: "paused", RendererName: "blender"},
+	}
+	tasks[1].Fail("localhost//127.0.0.1", "error on node localhost", 1)
+	for _, tas := range tasks {
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+	}
+	pausedT := new(sync.Map)
+	pausedT.Store("test_api", true)
+
+	ws := WorkingSet{
+		Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+		RenderNodes: new(sync.Map),
+		Renders:     new(sync.Map),
+		Tasks:       tasksT,
+		Paused:      pausedT,
+		DBTransacts: fifo.NewQueue(),
+		Scheduler:   scheduler.NewFIFO(),
+	}
+
+	//The failed and rerendered frames of the paused job stay paused
+	data := []url.Values{
+		{"api_key": {"test_api"}, "id": {"test_api"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}, "frames": {"1"}},
+	}
+	handlers := []func(http.ResponseWriter, *http.Request){ws.RetryJob, ws.RerenderFrames}
+	paths := []string{"retryJob", "rerenderFrames"}
+	for i := range data {
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/"+paths[i], strings.NewReader(data[i].Encode()))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		handlers[i](w, r)
+		assert.Equal(200, w.Result().StatusCode, "Bad status code of /%s", paths[i])
+	}
+
+	for _, tas := range tasks {
+		assert.Equal("paused", tas.State, "Frame %d not paused", tas.Frame)
+	}
+	nd := &node.Node{Name: "localhost", IP: "127.0.0.1"}
+	nd.SetState("available")
+	nd.SetRenderers([]node.Renderer{{Name: "blender", Version: "2.91.0"}})
+	_, err := ws.Scheduler.Next(nd)
+	assert.Equal(scheduler.ErrNoTask, err, "Frame of the paused job scheduled")
+}
+
+func TestPauseJob(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTab := []url.Values{
+		{"api_key": {"test_api"}, "id": {"test_api"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}, "stop": {"true"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}, "stop": {"now"}},
+		{"api_key": {"test_api"}, "id": {"unknown"}},
+		{"api_key": {"test_api"}},
+		{"api_key": {"wrong_api"}, "id": {"test_api"}},
+	}
+
+	expectedCode := []int{200, 200, 400, 404, 400, 404}
+	expectedState := []string{"OK", "OK", "Error : invalid parameter 'stop'", "Error : can't find job", "Error : Missing Parameter", ""}
+	expectedStates := [][]string{
+		{"paused", "rendering", "rendered"},
+		{"paused", "paused", "rendered"},
+		{"waiting", "rendering", "rendered"},
+		{"waiting", "rendering", "rendered"},
+		{"waiting", "rendering", "rendered"},
+		{"waiting", "rendering", "rendered"},
+	}
+	expectedTransacts := []int{2, 3, 0, 0, 0, 0}
+
+	for i := 0; i < len(dataTab); i++ {
+		tasks := []*render.Task{
+			{ID: "test_api", Frame: 1, State: "waiting", RendererName: "blender"},
+			{ID: "test_api", Frame: 2, State: "rendering", RendererName: "blender"},
+			{ID: "test_api", Frame: 3, State: "rendered", RendererName: "blender"},
+		}
+		tasksT := new(sync.Map)
+		tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+		for _, tas := range tasks {
+			tmpMap.(*sync.Map).Store(tas.Key(), tas)
+		}
+		rendersT := new(sync.Map)
+		tmpMap, _ = rendersT.LoadOrStore("test_api", new(sync.Map))
+		tmpMap.(*sync.Map).Store(tasks[1].Key(), &Render{myTask: tasks[1], myNode: &node.Node{Name: "localhost", IP: "127.0.0.1"}})
+
+		DBT := fifo.NewQueue()
+		ws := WorkingSet{
+			Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+			RenderNodes: new(sync.Map),
+			Renders:     rendersT,
+			Tasks:       tasksT,
+			Paused:      new(sync.Map),
+			DBTransacts: DBT,
+			Scheduler:   scheduler.NewFIFO(),
+		}
+		ws.Scheduler.Add(tasks[0])
+
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/pauseJob", strings.NewReader(dataTab[i].Encode()))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		ws.PauseJob(w, r)
+		resp := w.Result()
+		dt := new(ReturnValue)
+		json.NewDecoder(resp.Body).Decode(dt)
+
+		assert.Equal(expectedCode[i], resp.StatusCode, "Bad status code in test %d", i)
+		assert.Equal(expectedState[i], dt.State, "Bad state returned in test %d", i)
+		for j, tas := range tasks {
+			assert.Equal(expectedStates[i][j], tas.State, "Bad state of frame %d in test %d", tas.Frame, i)
+		}
+		assert.Equal(expectedTransacts[i], DBT.Len(), "Bad number of database transactions in test %d", i)
+
+		//The waiting frames of a paused job aren't given to the nodes
+		nd := &node.Node{Name: "other", IP: "127.0.0.2"}
+		nd.SetState("available")
+		nd.SetRenderers([]node.Renderer{{Name: "blender", Version: "2.91.0"}})
+		_, err := ws.Scheduler.Next(nd)
+		assert.Equal(expectedCode[i] == 200, err == scheduler.ErrNoTask, "Bad scheduling in test %d", i)
+	}
+}
+
+func TestPauseAndResumeJob(t *testing.T) {
+	assert := assert.New(t)
+
+	nd := &node.Node{
+		Name:      "localhost",
+		IP:        "127.0.0.1",
+		APIKey:    "test_api",
+		Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
+	}
+	nd.SetState("rendering")
+
+	tasks := []*render.Task{
+		{ID: "test_api", Frame: 1, FrameEnd: 4, State: "rendering", RendererName: "blender"},
+		{ID: "test_api", Frame: 5, FrameEnd: 8, State: "waiting", RendererName: "blender"},
+	}
+	rd := &Render{myTask: tasks[0], myNode: nd, Percent: "0.0", Mem: "0.0"}
+
+	nodesT := new(sync.Map)
+	nodesT.Store(nd.Key(), nd)
+	tasksT := new(sync.Map)
+	tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+	for _, tas := range tasks {
+		tmpMap.(*sync.Map).Store(tas.Key(), tas)
+	}
+	rendersT := new(sync.Map)
+	rendersMap, _ := rendersT.LoadOrStore("test_api", new(sync.Map))
+	rendersMap.(*sync.Map).Store(tasks[0].Key(), rd)
+
+	ws := WorkingSet{
+		Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+		RenderNodes: nodesT,
+		Renders:     rendersT,
+		Tasks:       tasksT,
+		Paused:      new(sync.Map),
+		DBTransacts: fifo.NewQueue(),
+		Scheduler:   scheduler.NewFIFO(),
+	}
+	ws.Scheduler.Add(tasks[1])
+
+	post := func(path string, handler http.HandlerFunc, data url.Values) string {
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1"+path, strings.NewReader(data.Encode()))
+		r.RemoteAddr = "127.0.0.1:1001"
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		handler(w, r)
+		dt := new(ReturnValue)
+		json.NewDecoder(w.Result().Body).Decode(dt)
+		return dt.State
+	}
+	job := url.Values{"api_key": {"test_api"}, "id": {"test_api"}}
+	update := url.Values{"api_key": {"test_api"}, "id": {"test_api"}, "name": {"localhost"}, "frame": {"1"},
+		"state": {"rendering"}, "percent": {"0.5"}, "mem": {"2.0"}, "done": {"2"}}
+
+	//A job paused and resumed before its node updates it goes on rendering
+	assert.Equal("OK", post("/pauseJob", ws.PauseJob, url.Values{"api_key": {"test_api"}, "id": {"test_api"}, "stop": {"true"}}))
+	assert.Equal("OK", post("/resumeJob", ws.ResumeJob, job))
+	assert.Equal("rendering", tasks[0].State, "Frame being rendered not resumed")
+	assert.Equal("waiting", tasks[1].State, "Waiting frames not resumed")
+	assert.Equal("OK", post("/updateJob", ws.UpdateJob, update))
+
+	//The node is told to stop, the frames it rendered are kept
+	assert.Equal("OK", post("/pauseJob", ws.PauseJob, url.Values{"api_key": {"test_api"}, "id": {"test_api"}, "stop": {"true"}}))
+	assert.Equal("PAUSE", post("/updateJob", ws.UpdateJob, update))
+	assert.Equal("available", nd.State(), "Node of the paused frame not freed")
+	assert.Equal("rendered", tasks[0].State, "Bad state of the frames rendered before the pause")
+	assert.Equal(2, tasks[0].LastFrame(), "Bad last frame rendered before the pause")
+	rest, ok := tmpMap.(*sync.Map).Load(render.TaskKey{Frame: 3})
+	if assert.Equal(true, ok, "Frames not rendered before the pause not stored") {
+		assert.Equal("paused", rest.(*render.Task).State, "Bad state of the frames not rendered before the pause")
+	}
+	_, err := ws.Scheduler.Next(nd)
+	assert.Equal(scheduler.ErrNoTask, err, "Frame of a paused job given to a node")
+
+	//The paused frames are given to the nodes once the job is resumed
+	assert.Equal("OK", post("/resumeJob", ws.ResumeJob, job))
+	for _, frame := range []int{3, 5} {
+		nd.SetState("available")
+		next, err := ws.Scheduler.Next(nd)
+		if assert.NoError(err, "Frame %d not resumed", frame) {
+			assert.Equal(frame, next.Frame, "Bad frame resumed")
+		}
+	}
+}
+
+//TestPauseRenderingJob pauses a job whose only chunk is being rendered, the frames requeued by its node staying paused
+//after a restart
+func TestPauseRenderingJob(t *testing.T) {
+	assert := assert.New(t)
+
+	folder, err := ioutil.TempDir("", "blenderer")
+	assert.NoError(err)
+	defer os.RemoveAll(folder)
+
+	db, err := rendererdb.LoadDatabase(filepath.Join(folder, "pause.sql"))
+	if !assert.NoError(err) {
+		return
+	}
+	defer db.Close()
+
+	nd := &node.Node{
+		Name:      "localhost",
+		IP:        "127.0.0.1",
+		APIKey:    "test_api",
+		Renderers: []node.Renderer{{Name: "blender", Version: "2.91.0"}},
+	}
+	nd.SetState("available")
+
+	tsk := &render.Task{Project: "cube", ID: "test_api", Input: "cube.blend", Output: "cube", Frame: 1, FrameEnd: 5, State: "waiting",
+		RendererName: "blender", RendererVersion: "2.91.0"}
+	assert.NoError(rendererdb.InsertProjectsInDB(db, []*render.Task{tsk}))
+
+	nodesT := new(sync.Map)
+	nodesT.Store(nd.Key(), nd)
+	tasksT := new(sync.Map)
+	tmpMap, _ := tasksT.LoadOrStore("test_api", new(sync.Map))
+	tmpMap.(*sync.Map).Store(tsk.Key(), tsk)
+
+	ws := WorkingSet{
+		Db:          db,
+		Config:      Configuration{UserAPIKeys: []string{"test_api"}},
+		RenderNodes: nodesT,
+		Renders:     new(sync.Map),
+		Tasks:       tasksT,
+		Paused:      new(sync.Map),
+		DBTransacts: fifo.NewQueue(),
+		Scheduler:   scheduler.NewFIFO(),
+	}
+	ws.Scheduler.Add(tsk)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/getJob", ws.GetJob)
+	mux.HandleFunc("/updateJob", ws.UpdateJob)
+	mux.HandleFunc("/pauseJob", ws.PauseJob)
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	post := func(path string, data url.Values, target interface{}) {
+		resp, err := http.PostForm(server.URL+path, data)
+		if assert.NoError(err, "Couldn't post to %s", path) {
+			defer resp.Body.Close()
+			assert.NoError(json.NewDecoder(resp.Body).Decode(target), "Bad response of %s", path)
+		}
+	}
+
+	//The job is paused while its only chunk is being rendered, no frame is waiting
+	job := new(render.Task)
+	post("/getJob", url.Values{"api_key": {"test_api"}, "name": {"localhost"}}, job)
+	assert.Equal(1, job.Frame, "Bad frame given to the node")
+	rv := new(ReturnValue)
+	post("/pauseJob", url.Values{"api_key": {"test_api"}, "id": {"test_api"}}, rv)
+	assert.Equal("OK", rv.State, "Job not paused")
+
+	//The frames not rendered by the node are paused when it requeues the chunk
+	post("/updateJob", url.Values{"api_key": {"test_api"}, "name": {"localhost"}, "id": {"test_api"}, "frame": {"1"},
+		"state": {"requeue"}, "percent": {"0.4"}, "mem": {"2.0"}, "done": {"2"}}, rv)
+	assert.Equal("REQUEUED", rv.State, "Chunk not requeued")
+	rest, ok := tmpMap.(*sync.Map).Load(render.TaskKey{Frame: 3})
+	if assert.Equal(true, ok, "Frames not rendered before the requeue not stored") {
+		assert.Equal("paused", rest.(*render.Task).State, "Frames not rendered before the requeue not paused")
+	}
+	job = new(render.Task)
+	post("/getJob", url.Values{"api_key": {"test_api"}, "name": {"localhost"}}, job)
+	assert.Equal("", job.ID, "Frame of a paused job given to the node")
+
+	//The job stays paused when the server restarts
+	for tr := ws.DBTransacts.Next(); tr != nil; tr = ws.DBTransacts.Next() {
+		assert.NoError(tr.(*rendererdb.DBTransact).Apply(db))
+	}
+	loaded := new(sync.Map)
+	paused := new(sync.Map)
+	assert.NoError(rendererdb.LoadTasksFromDB(db, loaded, paused))
+	_, ok = paused.Load("test_api")
+	assert.Equal(true, ok, "Pause of the job not stored")
+	if loadedMap, ok := loaded.Load("test_api"); assert.Equal(true, ok, "Paused job not stored") {
+		stored, ok := loadedMap.(*sync.Map).Load(render.TaskKey{Frame: 3})
+		if assert.Equal(true, ok, "Requeued frames not stored") {
+			assert.Equal("paused", stored.(*render.Task).State, "Requeued frames not paused after the restart")
+		}
+	}
+}
+
+func TestDeleteJob(t *testing.T) {
+	assert := assert.New(t)
+
+	dataTab := []url.Values{
+		{"api_key": {"test_api"}, "id": {"test_api"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}, "force": {"true"}},
+		{"api_key": {"test_api"}, "id": {"other"}},
+		{"api_key": {"test_api"}, "id": {"test_api"}, "force": {"always"}},
+		{"api_key": {"test_api"}, "id": {"unknown"}},
+		{"api_key": {"test_api"}},
+		{"api_key": {"wrong_api"}, "id": {"test_api"}},
+	}
+
+	expectedCode := []int{409, 200, 200, 400, 404, 400, 404}
+	expectedState := []string{"Error : frames of the job are being rendered", "OK", "OK", "Error : invalid parameter 'force'", "Error : can't find job", "Error : Missing Parameter", ""}
+	expectedNodeState := []string{"rendering", "available", "rendering", "rendering", "rendering", "rendering", "rendering"}
+	expectedUpdate := []string{"OK", "DELETED", "OK", "OK", "OK", "OK", "OK"}
+
+	for i := 0; i < len(dataTab); i++ {
+		folder, err := ioutil.TempDir("", "blenderer")
+		assert.NoError(err)
+
+		db, err := rendererdb.LoadDatabase(filepath.Join(folder, "delete.sql"))
+		if !assert.NoError(err) {
+			continue
+		}
+
+		nd := &node.Node{Name: "localhost", IP: "127.0.0.1", APIKey: "test_api"}
+		nd.SetState("rendering")
+
+		tasks := []*render.Task{
+			{ID: "test_api", Frame: 1, State: "rendering", RendererName: "blender"},
+			{ID: "test_api", Frame: 2, State: "waiting", RendererName: "blender"},
+			{ID: "other", Frame: 1, State: "rendered", RendererName: "blender"},
+		}
+		assert.NoError(rendererdb.InsertProjectsInDB(db, tasks))
+
+		tasksT := new(sync.Map)
+		for _, tas := range tasks {
+			tmpMap, _ := tasksT.LoadOrStore(tas.ID, new(sync.Map))
+			tmpMap.(*sync.Map).Store(tas.Key(), tas)
+			assert.NoError(os.MkdirAll(filepath.Join(folder, tas.ID), os.ModePerm))
+		}
+		nodesT := new(sync.Map)
+		nodesT.Store(nd.Key(), nd)
+		rendersT := new(sync.Map)
+		rendersMap, _ := rendersT.LoadOrStore("test_api", new(sync.Map))
+		rendersMap.(*sync.Map).Store(tasks[0].Key(), &Render{myTask: tasks[0], myNode: nd})
+
+		ws := WorkingSet{
+			Db:          db,
+			Config:      Configuration{Folder: folder, UserAPIKeys: []string{"test_api"}},
+			RenderNodes: nodesT,
+			Renders:     rendersT,
+			Tasks:       tasksT,
+			Paused:      new(sync.Map),
+			DBTransacts: fifo.NewQueue(),
+			Scheduler:   scheduler.NewFIFO(),
+		}
+		ws.Scheduler.Add(tasks[1])
+		tokens := make(map[string]string)
+		for _, id := range []string{"test_api", "other"} {
+			tokens[id], err = ws.newTransfer(id)
+			assert.NoError(err)
+		}
+
+		r, _ := http.NewRequest(http.MethodPost, "https://127.0.0.1/deleteJob", strings.NewReader(dataTab[i].Encode()))
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		ws.DeleteJob(w, r)
+		resp := w.Result()
+		dt := new(ReturnValue)
+		json.NewDecoder(resp.Body).Decode(dt)
+
+		assert.Equal(expectedCode[i], resp.StatusCode, "Bad status code in test %d", i)
+		assert.Equal(expectedState[i], dt.State, "Bad state returned in test %d", i)
+		assert.Equal(expectedNodeState[i], nd.State(), "Bad state of the node in test %d", i)
+
+		for tr := ws.DBTransacts.Next(); tr != nil; tr = ws.DBTransacts.Next() {
+			assert.NoError(tr.(*rendererdb.DBTransact).Apply(db), "Bad database transaction in test %d", i)
+		}
+		loaded := new(sync.Map)
+		assert.NoError(rendererdb.LoadTasksFromDB(db, loaded, new(sync.Map)))
+
+		//Only the deleted job is removed from memory, the database and the disk
+		deleted := ""
+		if expectedCode[i] == 200 {
+			deleted = dataTab[i].Get("id")
+		}
+		for _, id := range []string{"test_api", "other"} {
+			_, inTasks := tasksT.Load(id)
+			_, inDB := loaded.Load(id)
+			_, err := os.Stat(filepath.Join(folder, id))
+			assert.Equal(id != deleted, inTasks, "Bad tasks of job %s in test %d", id, i)
+			assert.Equal(id != deleted, inDB, "Bad rows of job %s in test %d", id, i)
+			assert.Equal(id != deleted, err == nil, "Bad folder of job %s in test %d", id, i)
+			assert.Equal(id != deleted, ws.AuthorizeTransfer(tokens[id], id), "Bad transfer token of job %s in test %d", id, i)
+		}
+		_, inRenders := rendersT.Load("test_api")
+		assert.Equal(deleted != "test_api", inRenders, "Bad renders in test %d", i)
+
+		//The waiting frames of a deleted job aren't given to the nodes anymore
+		other := &node.Node{Name: "other", IP: "127.0.0.2"}
+		other.SetState("available")
+		other.SetRenderers([]node.Renderer{{Name: "blender", Version: "2.91.0"}})
+		_, err = ws.Scheduler.Next(other)
+		assert.Equal(deleted == "test_api", err == scheduler.ErrNoTask, "Bad scheduling in test %d", i)
+
+		//The node rendering a deleted job is told to stop when it updates it
+		update := url.Values{"api_key": {"test_api"}, "id": {"test_api"}, "name": {"localhost"}, "frame": {"1"},
+			"state": {"rendering"}, "percent": {"0.5"}, "mem": {"2.0"}}
+		r, _ = http.NewRequest(http.MethodPost, "https://127.0.0.1/updateJob", strings.NewReader(update.Encode()))
+		r.RemoteAddr = "127.0.0.1:1001"
+		r.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+		w = httptest.NewRecorder()
+		ws.UpdateJob(w, r)
+		json.NewDecoder(w.Result().Body).Decode(dt)
+		assert.Equal(expectedUpdate[i], dt.State, "Bad state of the update in test %d", i)
+
+		db.Close()
+		os.RemoveAll(folder)
+	}
+}
@@ -2,24 +2,36 @@ package rendererapi
 
 import (
 	"database/sql"
+	"encoding/json"
+	"fmt"
 	"net/http"
 	"sync"
+	"time"
 
+	"github.com/LeoMarche/blenderer/src/filexchange"
 	"github.com/LeoMarche/blenderer/src/node"
 	"github.com/LeoMarche/blenderer/src/render"
 	"github.com/LeoMarche/blenderer/src/rendererdb"
+	"github.com/LeoMarche/blenderer/src/scheduler"
+	"github.com/LeoMarche/blenderer/src/utils"
 	fifo "github.com/foize/go.fifo"
 )
 
 //WorkingSet contains variables for main to work
 type WorkingSet struct {
-	Db          *sql.DB
-	Tasks       *sync.Map //Index for this map is ID. It contains map with task, indexes are Frame
-	RenderNodes *sync.Map //Index for this map is Name+"//"+IP
-	Renders     *sync.Map //Index for this map is ID. It contains maps with Render, indexes are Frame
-	Config      Configuration
-	DBTransacts *fifo.Queue
-	StopDB      *bool
+	Db           *sql.DB
+	Tasks        *sync.Map //Index for this map is ID. It contains map with task, indexes are render.TaskKey
+	RenderNodes  *sync.Map //Index for this map is Name+"//"+IP
+	Renders      *sync.Map //Index for this map is ID. It contains maps with Render, indexes are render.TaskKey
+	Paused       *sync.Map //Index for this map is ID, it contains the paused jobs, see /pauseJob
+	Config       Configuration
+	DBTransacts  *fifo.Queue
+	StopDB       *bool
+	Scheduler    scheduler.Scheduler //Gives the waiting tasks to the nodes, they must be added to it once uploaded
+	stitchLock   sync.Mutex          //Prevents the tiles of a frame from being stitched twice at once
+	deleted      sync.Map            //IDs of the deleted jobs, the nodes still rendering them being told so by /updateJob
+	transfers    sync.Map            //Tokens allowing to exchange the files of a job with the file server, see newTransfer
+	transferLock sync.Mutex          //Prevents a token from being renewed while the tokens of its job are revoked
 }
 
 type ReturnValue struct {
@@ -32,6 +44,24 @@ type TaskToSend struct {
 	Percent   float64
 	Nb        int
 	StartTime string
+	Status    string //"paused" if the job is paused, else "no capable node" if no registered node can render its waiting frames
+	Failed    int    //Number of frames that failed too many times to be rendered again, see /getFrames
+}
+
+//NoCapableNode is the status of the jobs whose renderer isn't installed on any registered node
+const NoCapableNode = "no capable node"
+
+//Paused is the status of the jobs paused by /pauseJob
+const Paused = "paused"
+
+//capable returns true if a registered node supports the renderer of t
+func (ws *WorkingSet) capable(t *render.Task) bool {
+	found := false
+	ws.RenderNodes.Range(func(k, v interface{}) bool {
+		found = v.(*node.Node).Supports(t.RendererName, t.RendererVersion)
+		return !found
+	})
+	return found
 }
 
 func getIP(r *http.Request) string {
@@ -42,6 +72,22 @@ func getIP(r *http.Request) string {
 	return r.RemoteAddr
 }
 
+//sendState answers a request with a ReturnValue holding st and the given status code
+func sendState(w http.ResponseWriter, code int, st string) {
+	w.Header().Set("Content-Type", "application/json")
+	js, err := json.Marshal(ReturnValue{
+		State: st,
+	})
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(code)
+	w.Write(js)
+}
+
 func isIn(s string, t []string) int {
 
 	for i := 0; i < len(t); i++ {
@@ -54,26 +100,125 @@ func isIn(s string, t []string) int {
 }
 
 //Configuration is the main configuration
+//LeaseSeconds is the time a node has to report on a render before it is requeued and ReapIntervalSeconds
+//the time between two checks of the leases, defaults are used when they are 0.
+//Scheduler is "fifo" (default) or "fairshare", the latter sharing the nodes between the owners (API keys)
+//of the jobs, then between their projects, in proportion to their weights (1 if not set).
+//MaxAttempts is the number of failed renders after which a task is "failed" instead of rendered again, 3 if 0.
+//TransferSeconds is the time the tokens given by /postJob and /getJob allow to exchange the files of their job with the
+//file server, renewed when the node updates the job, 3600 if 0.
 type Configuration struct {
-	Folder      string
-	DBName      string
-	Certname    string
-	UserAPIKeys []string
+	Folder              string
+	DBName              string
+	Certname            string
+	UserAPIKeys         []string
+	LeaseSeconds        int
+	ReapIntervalSeconds int
+	Scheduler           string
+	OwnerWeights        map[string]float64
+	ProjectWeights      map[string]float64
+	MaxAttempts         int
+	TransferSeconds     int
+}
+
+//Validate returns an error if the configuration can't be used
+func (c *Configuration) Validate() error {
+	if _, err := c.NewScheduler(); err != nil {
+		return err
+	}
+	for k, w := range c.OwnerWeights {
+		if w <= 0 {
+			return fmt.Errorf("weight of owner %s must be positive", k)
+		}
+	}
+	for k, w := range c.ProjectWeights {
+		if w <= 0 {
+			return fmt.Errorf("weight of project %s must be positive", k)
+		}
+	}
+	if c.MaxAttempts < 0 {
+		return fmt.Errorf("maximum number of attempts must be positive")
+	}
+	if c.TransferSeconds < 0 {
+		return fmt.Errorf("duration of the transfer tokens must be positive")
+	}
+	return nil
+}
+
+//NewScheduler returns the scheduler selected by the configuration
+func (c *Configuration) NewScheduler() (scheduler.Scheduler, error) {
+	return scheduler.New(c.Scheduler, c.OwnerWeights, c.ProjectWeights)
+}
+
+const (
+	defaultLeaseSeconds        = 120
+	defaultReapIntervalSeconds = 10
+	defaultMaxAttempts         = 3
+	defaultTransferSeconds     = 3600
+)
+
+//maxAttempts returns the number of failed renders after which a task is failed
+func (c *Configuration) maxAttempts() int {
+	if c.MaxAttempts <= 0 {
+		return defaultMaxAttempts
+	}
+	return c.MaxAttempts
+}
+
+//leaseDuration returns the duration of the lease of a render
+func (c *Configuration) leaseDuration() time.Duration {
+	if c.LeaseSeconds <= 0 {
+		return defaultLeaseSeconds * time.Second
+	}
+	return time.Duration(c.LeaseSeconds) * time.Second
+}
+
+//reapInterval returns the time between two checks of the leases of the renders
+func (c *Configuration) reapInterval() time.Duration {
+	if c.ReapIntervalSeconds <= 0 {
+		return defaultReapIntervalSeconds * time.Second
+	}
+	return time.Duration(c.ReapIntervalSeconds) * time.Second
+}
+
+//transferDuration returns the time a transfer token is valid
+func (c *Configuration) transferDuration() time.Duration {
+	if c.TransferSeconds <= 0 {
+		return defaultTransferSeconds * time.Second
+	}
+	return time.Duration(c.TransferSeconds) * time.Second
 }
 
 //Upload allows client to upload file
+//Token is the ID of the job, Transfer the token allowing to send its files to the file server.
 type Upload struct {
-	Token   string
-	Project string
-	State   string
+	Token    string
+	Project  string
+	State    string
+	Transfer string
+}
+
+//JobToSend is the task given to a node by /getJob, with the token allowing to exchange its files with the file server
+//and the SHA-256 of the file uploaded for the job, empty if it is unknown
+type JobToSend struct {
+	*render.Task
+	Transfer string `json:"transfer"`
+	Checksum string `json:"checksum"`
 }
 
 //Render is the base descriptor of a render
+//Its lease expires at expires unless the node renews it, it is protected by the lock of myTask.
+//done is the number of frames of the chunk of myTask already rendered and uploaded by the node.
+//transfer is the token given to the node to exchange the files of the job, renewed with the lease.
 type Render struct {
-	myTask  *render.Task
-	myNode  *node.Node
-	Percent string
-	Mem     string
+	myTask   *render.Task
+	myNode   *node.Node
+	Percent  string
+	Mem      string
+	Warning  string
+	expires  time.Time
+	done     int
+	transfer string
 }
 
 //GetState returns the state of the myTask of the Render Object
@@ -81,6 +226,147 @@ func (r *Render) GetState() string {
 	return r.myTask.State
 }
 
+//failure describes why a render failed, node being the key of the node excluded from the next attempts if not empty
+type failure struct {
+	node   string
+	reason string
+}
+
+//requeue puts the frames of rd not rendered yet back in the waiting tasks, rd must have been removed from the renders.
+//If part of the chunk was rendered, the task is split so that only the remaining frames are rendered again.
+//If the render failed, the attempt is counted and the remaining frames are "failed" after too many attempts, see fail.
+//The frames of a paused job are "paused" instead of waiting.
+func (ws *WorkingSet) requeue(rd *Render, f *failure) {
+	rd.myTask.Lock()
+	rd.Percent = "0.0"
+	rd.Mem = "0.0"
+	done := rd.done
+	rd.myTask.Unlock()
+
+	rest := rd.myTask.Split(done)
+	if rest == nil {
+		if f != nil && ws.fail(rd.myTask, f) {
+			fmt.Printf("Frame %d of %s failed after %d attempts : %s\n", rd.myTask.Frame, rd.myTask.ID, rd.myTask.Attempts, f.reason)
+			ws.Scheduler.Release(rd.myTask)
+		} else if ws.jobPaused(rd.myTask.ID) {
+			ws.Scheduler.Release(rd.myTask)
+			rd.myTask.SetState("paused")
+		} else {
+			ws.Scheduler.Requeue(rd.myTask)
+		}
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
+			OP:       rendererdb.UPDATETASK,
+			Argument: rd.myTask,
+		})
+		return
+	}
+
+	//The rendered frames stay in the task, the others are given to a new one
+	ws.Scheduler.Release(rd.myTask)
+	tmpMap, _ := ws.Tasks.LoadOrStore(rest.ID, new(sync.Map))
+	tmpMap.(*sync.Map).Store(rest.Key(), rest)
+	if f != nil && ws.fail(rest, f) {
+		fmt.Printf("Frame %d of %s failed after %d attempts : %s\n", rest.Frame, rest.ID, rest.Attempts, f.reason)
+	} else if ws.jobPaused(rest.ID) {
+		rest.SetState("paused")
+	} else {
+		ws.Scheduler.Add(rest)
+	}
+
+	ws.DBTransacts.Add(&rendererdb.DBTransact{
+		OP:       rendererdb.UPDATETASK,
+		Argument: rd.myTask,
+	})
+	ws.DBTransacts.Add(&rendererdb.DBTransact{
+		OP:       rendererdb.INSERTPROJECT,
+		Argument: []*render.Task{rest},
+	})
+}
+
+//fail records the failure f of t and returns true if t failed too many times to be rendered again.
+//The nodes t failed on may render it again once every capable node failed it, so that it doesn't wait forever.
+func (ws *WorkingSet) fail(t *render.Task, f *failure) bool {
+	if t.Fail(f.node, f.reason, ws.Config.maxAttempts()) {
+		return true
+	}
+	if ws.excluded(t) {
+		t.ForgetFailedNodes()
+	}
+	return false
+}
+
+//excluded returns true if t failed on every registered node able to render it
+func (ws *WorkingSet) excluded(t *render.Task) bool {
+	capable, allowed := false, false
+	ws.RenderNodes.Range(func(k, v interface{}) bool {
+		if v.(*node.Node).Supports(t.RendererName, t.RendererVersion) {
+			capable = true
+			allowed = !t.FailedOn(v.(*node.Node).Key())
+		}
+		return !allowed
+	})
+	return capable && !allowed
+}
+
+//jobPaused returns true if the job is paused, see /pauseJob
+func (ws *WorkingSet) jobPaused(id string) bool {
+	_, ok := ws.Paused.Load(id)
+	return ok
+}
+
+//stitchFrame assembles the tiles of the frame of t in the folder of its job once all of them are rendered
+func (ws *WorkingSet) stitchFrame(t *render.Task) error {
+	ws.stitchLock.Lock()
+	defer ws.stitchLock.Unlock()
+
+	tmpMap, ok := ws.Tasks.Load(t.ID)
+	if !ok {
+		return nil
+	}
+
+	var err error
+	tiles := make([]string, t.Settings.Tiles())
+	for i := range tiles {
+		tile, ok := tmpMap.(*sync.Map).Load(render.TaskKey{Frame: t.Frame, Tile: i})
+		if !ok || tile.(*render.Task).GetState() != "rendered" {
+			return nil
+		}
+		if tiles[i], err = utils.SafeJoin(ws.Config.Folder, t.ID, tile.(*render.Task).OutputName(t.Frame)); err != nil {
+			return err
+		}
+	}
+
+	frame, err := utils.SafeJoin(ws.Config.Folder, t.ID, t.FrameName(t.Frame))
+	if err != nil {
+		return err
+	}
+	columns, _ := t.Settings.TileGrid()
+	if err := render.Stitch(tiles, columns, frame); err != nil {
+		return err
+	}
+
+	//The stitched frame is downloaded like the ones sent by the nodes
+	sum, err := filexchange.HashFile(frame)
+	if err != nil {
+		return err
+	}
+	return filexchange.WriteChecksum(frame, sum)
+}
+
+//inputChecksum returns the SHA-256 of the file uploaded for the job of t, its bundle or its input, "" if it is unknown
+func (ws *WorkingSet) inputChecksum(t *render.Task) string {
+	name := t.Input
+	if t.Bundle != "" {
+		name = t.Bundle
+	}
+	p, err := utils.SafeJoin(ws.Config.Folder, t.ID, name)
+	if err != nil {
+		return ""
+	}
+	sum, _ := filexchange.Checksum(p)
+	return sum
+}
+
 //This function updates states in database using state of objects
 func (r *Render) UpdateDatabase(db *sql.DB) {
 
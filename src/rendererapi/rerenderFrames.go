@@ -0,0 +1,129 @@
+package rendererapi
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/LeoMarche/blenderer/src/rendererdb"
+)
+
+//RerenderFrames is handler for rendering again some frames of a job, even if they were rendered
+//The request must be a post with api_key, id and frames, the frames to render again like 1-10,15,20-40x5 (see
+//render.ParseFrames). Rendered and failed frames are put back in "waiting" state, or in "paused" state if the job is
+//paused, chunks being cut so that their other frames stay rendered. Frames waiting or being rendered are left as they are.
+func (ws *WorkingSet) RerenderFrames(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/rerenderFrames" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+
+	if r.FormValue("api_key") == "" || isIn(r.FormValue("api_key"), ws.Config.UserAPIKeys) == -1 {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if r.FormValue("id") == "" || r.FormValue("frames") == "" {
+		sendState(w, http.StatusBadRequest, "Error : Missing Parameter")
+		return
+	}
+
+	frames, err := render.ParseFrames(r.FormValue("frames"))
+	if err != nil {
+		sendState(w, http.StatusBadRequest, "Error : "+err.Error())
+		return
+	}
+	rerender := make(map[int]bool, len(frames))
+	for _, f := range frames {
+		rerender[f] = true
+	}
+
+	tmpMap, ok := ws.Tasks.Load(r.FormValue("id"))
+	if !ok {
+		sendState(w, http.StatusNotFound, "Error : can't find job")
+		return
+	}
+
+	//Tasks are listed first as cutting a chunk stores new ones
+	paused := ws.jobPaused(r.FormValue("id"))
+	var tasks []*render.Task
+	tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+		tasks = append(tasks, value.(*render.Task))
+		return true
+	})
+	sort.Slice(tasks, func(i, j int) bool {
+		return tasks[i].Frame < tasks[j].Frame || (tasks[i].Frame == tasks[j].Frame && tasks[i].Tile < tasks[j].Tile)
+	})
+
+	var updated, inserted, reset []*render.Task
+	for _, tsk := range tasks {
+		if st := tsk.GetState(); st != "rendered" && st != "failed" {
+			continue
+		}
+
+		cur, last := tsk, tsk.LastFrame()
+		for f := tsk.Frame; f <= last; f++ {
+			if !rerender[f] {
+				continue
+			}
+			if len(updated) == 0 || updated[len(updated)-1] != tsk {
+				updated = append(updated, tsk)
+			}
+
+			//Cut the chunk around the run of frames to render again starting at f
+			end := f
+			for end < last && rerender[end+1] {
+				end++
+			}
+			if f > cur.Frame {
+				cur = cur.Cut(f)
+				inserted = append(inserted, cur)
+				tmpMap.(*sync.Map).Store(cur.Key(), cur)
+			}
+			var next *render.Task
+			if end < last {
+				next = cur.Cut(end + 1)
+				inserted = append(inserted, next)
+				tmpMap.(*sync.Map).Store(next.Key(), next)
+			}
+
+			cur.Reset()
+			if paused {
+				cur.SetState("paused")
+			}
+			reset = append(reset, cur)
+			if next == nil {
+				break
+			}
+			cur, f = next, end
+		}
+	}
+
+	//The tasks are written once all of them are cut, before a node can be given them
+	for _, tsk := range updated {
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
+			OP:       rendererdb.UPDATETASK,
+			Argument: tsk,
+		})
+	}
+	if len(inserted) > 0 {
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
+			OP:       rendererdb.INSERTPROJECT,
+			Argument: inserted,
+		})
+	}
+	for _, tsk := range reset {
+		if !paused {
+			ws.Scheduler.Add(tsk)
+		}
+	}
+
+	sendState(w, http.StatusOK, "OK")
+}
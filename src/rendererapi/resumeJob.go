@@ -0,0 +1,80 @@
+package rendererapi
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/LeoMarche/blenderer/src/rendererdb"
+)
+
+//ResumeJob is handler for resuming paused jobs
+//The request must be a post with api_key and id. The paused frames of the job are given to the nodes again, the ones
+//whose nodes weren't told to stop yet being rendered again.
+func (ws *WorkingSet) ResumeJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/resumeJob" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+
+	if r.FormValue("api_key") == "" || isIn(r.FormValue("api_key"), ws.Config.UserAPIKeys) == -1 {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if r.FormValue("id") == "" {
+		sendState(w, http.StatusBadRequest, "Error : Missing Parameter")
+		return
+	}
+
+	tmpMap, ok := ws.Tasks.Load(r.FormValue("id"))
+	if !ok {
+		sendState(w, http.StatusNotFound, "Error : can't find job")
+		return
+	}
+
+	if _, ok := ws.Paused.LoadAndDelete(r.FormValue("id")); ok {
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
+			OP:       rendererdb.PAUSEPROJECT,
+			Argument: &rendererdb.ProjectPause{ID: r.FormValue("id"), Paused: false},
+		})
+	}
+
+	rendersMap, _ := ws.Renders.LoadOrStore(r.FormValue("id"), new(sync.Map))
+
+	tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+		tsk := value.(*render.Task)
+
+		//Frames still being rendered by their node go on rendering, /updateJob removes them from the renders under
+		//their lock when telling their node to stop
+		tsk.Lock()
+		paused := tsk.State == "paused"
+		if paused {
+			tsk.State = "waiting"
+			if rd, ok := rendersMap.(*sync.Map).Load(key); ok && rd.(*Render).myTask == tsk {
+				tsk.State = "rendering"
+			}
+		}
+		waiting := tsk.State == "waiting"
+		tsk.Unlock()
+
+		if paused {
+			ws.DBTransacts.Add(&rendererdb.DBTransact{
+				OP:       rendererdb.UPDATETASK,
+				Argument: tsk,
+			})
+			if waiting {
+				ws.Scheduler.Add(tsk)
+			}
+		}
+		return true
+	})
+
+	sendState(w, http.StatusOK, "OK")
+}
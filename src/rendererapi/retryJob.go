@@ -0,0 +1,67 @@
+package rendererapi
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/LeoMarche/blenderer/src/rendererdb"
+)
+
+//RetryJob is handler for rendering again the failed frames of a job
+//The request must be a post with api_key and id. The failed frames are put back in "waiting" state with their
+//attempts and excluded nodes forgotten, or in "paused" state if the job is paused.
+func (ws *WorkingSet) RetryJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/retryJob" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+
+	if r.FormValue("api_key") == "" || isIn(r.FormValue("api_key"), ws.Config.UserAPIKeys) == -1 {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if r.FormValue("id") == "" {
+		sendState(w, http.StatusBadRequest, "Error : Missing Parameter")
+		return
+	}
+
+	tmpMap, ok := ws.Tasks.Load(r.FormValue("id"))
+	if !ok {
+		sendState(w, http.StatusNotFound, "Error : can't find job")
+		return
+	}
+
+	paused := ws.jobPaused(r.FormValue("id"))
+	tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+		if tsk := value.(*render.Task); tsk.GetState() == "failed" {
+			ws.reset(tsk, paused)
+		}
+		return true
+	})
+
+	sendState(w, http.StatusOK, "OK")
+}
+
+//reset puts tsk back in the waiting tasks to be rendered again, or in "paused" state if its job is paused, and
+//updates it in the database
+func (ws *WorkingSet) reset(tsk *render.Task, paused bool) {
+	tsk.Reset()
+	if paused {
+		tsk.SetState("paused")
+	}
+	ws.DBTransacts.Add(&rendererdb.DBTransact{
+		OP:       rendererdb.UPDATETASK,
+		Argument: tsk,
+	})
+	if !paused {
+		ws.Scheduler.Add(tsk)
+	}
+}
@@ -0,0 +1,63 @@
+package rendererapi
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/LeoMarche/blenderer/src/rendererdb"
+)
+
+//SetPriority is handler for changing the priority of a job
+//The request must be a post with api_key, id and priority
+func (ws *WorkingSet) SetPriority(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" || r.URL.Path != "/setPriority" {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		fmt.Fprintf(w, "ParseForm() err: %v", err)
+		return
+	}
+
+	if r.FormValue("api_key") == "" || isIn(r.FormValue("api_key"), ws.Config.UserAPIKeys) == -1 {
+		http.Error(w, "404 not found.", http.StatusNotFound)
+		return
+	}
+
+	if r.FormValue("id") == "" {
+		sendState(w, http.StatusBadRequest, "Error : Missing Parameter")
+		return
+	}
+
+	priority, err := strconv.Atoi(r.FormValue("priority"))
+	if err != nil {
+		sendState(w, http.StatusBadRequest, "Error : invalid parameter 'priority'")
+		return
+	}
+
+	tmpMap, ok := ws.Tasks.Load(r.FormValue("id"))
+	if !ok {
+		sendState(w, http.StatusNotFound, "Error : can't find job")
+		return
+	}
+
+	//Change the priority of every frame of the job, the ones already rendered included,
+	//and reschedule the waiting ones with it
+	tmpMap.(*sync.Map).Range(func(key, value interface{}) bool {
+		value.(*render.Task).SetPriority(priority)
+		if value.(*render.Task).GetState() == "waiting" {
+			ws.Scheduler.Add(value.(*render.Task))
+		}
+		ws.DBTransacts.Add(&rendererdb.DBTransact{
+			OP:       rendererdb.UPDATETASK,
+			Argument: value.(*render.Task),
+		})
+		return true
+	})
+
+	sendState(w, http.StatusOK, "OK")
+}
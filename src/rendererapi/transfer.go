@@ -0,0 +1,71 @@
+package rendererapi
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+)
+
+//transfer allows to exchange the files of the job id with the file server until it expires
+type transfer struct {
+	id      string
+	expires time.Time
+}
+
+//newTransfer issues a token allowing to exchange the files of the job id with the file server
+func (ws *WorkingSet) newTransfer(id string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+	ws.transfers.Store(token, &transfer{id: id, expires: time.Now().Add(ws.Config.transferDuration())})
+	return token, nil
+}
+
+//renewTransfer delays the expiration of the token, the files of a frame being sent while it is rendered
+func (ws *WorkingSet) renewTransfer(token string) {
+	ws.transferLock.Lock()
+	defer ws.transferLock.Unlock()
+
+	if tr, ok := ws.transfers.Load(token); ok {
+		ws.transfers.Store(token, &transfer{id: tr.(*transfer).id, expires: time.Now().Add(ws.Config.transferDuration())})
+	}
+}
+
+//AuthorizeTransfer returns true if credential is an API key of the server, or a token issued for the job id that
+//didn't expire yet. It is the filexchange.Authorizer of the file server.
+func (ws *WorkingSet) AuthorizeTransfer(credential, id string) bool {
+	if credential == "" {
+		return false
+	}
+	if isIn(credential, ws.Config.UserAPIKeys) >= 0 {
+		return true
+	}
+
+	tr, ok := ws.transfers.Load(credential)
+	return ok && tr.(*transfer).id == id && time.Now().Before(tr.(*transfer).expires)
+}
+
+//revokeTransfers forgets the tokens of the job id, its files being removed
+func (ws *WorkingSet) revokeTransfers(id string) {
+	ws.transferLock.Lock()
+	defer ws.transferLock.Unlock()
+
+	ws.transfers.Range(func(key, value interface{}) bool {
+		if value.(*transfer).id == id {
+			ws.transfers.Delete(key)
+		}
+		return true
+	})
+}
+
+//dropExpiredTransfers forgets the tokens expired before now
+func (ws *WorkingSet) dropExpiredTransfers(now time.Time) {
+	ws.transfers.Range(func(key, value interface{}) bool {
+		if !now.Before(value.(*transfer).expires) {
+			ws.transfers.Delete(key)
+		}
+		return true
+	})
+}
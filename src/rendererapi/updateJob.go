@@ -7,13 +7,21 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/LeoMarche/blenderer/src/node"
+	"github.com/LeoMarche/blenderer/src/render"
 	"github.com/LeoMarche/blenderer/src/rendererdb"
 )
 
 //UpdateJob is handler for updating jobs
-//The request must be a post with api_key, id, frame, state, percent, mem
+//The request must be a post with api_key, id, frame, state, percent, mem and optionally warning and done, the number
+//of frames of the chunk already rendered and uploaded. When a chunk is requeued, only the frames not done are rendered again.
+//tile (0 by default) is the tile of the frame rendered, the tiles of a frame being stitched once all of them are rendered.
+//The node is told to stop with "ABORT" if the job was aborted, "PAUSE" if the frame was paused, see /pauseJob, or
+//"DELETED" if the job was deleted, see /deleteJob.
+//state is "rendering", "rendered", "requeue" to give the frame back or "error" if the render failed, the failure counting
+//as an attempt, warning giving its reason. The node is answered "FAILED" and freed.
 func (ws *WorkingSet) UpdateJob(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" || r.URL.Path != "/updateJob" {
 		http.Error(w, "404 not found.", http.StatusNotFound)
@@ -80,13 +88,31 @@ func (ws *WorkingSet) UpdateJob(w http.ResponseWriter, r *http.Request) {
 	}
 
 	fr, _ := strconv.Atoi(r.FormValue("frame"))
+	tile, err := strconv.Atoi(r.FormValue("tile"))
+	if r.FormValue("tile") != "" && (err != nil || tile < 0) {
+		sendState(w, http.StatusBadRequest, "Error : invalid parameter 'tile'")
+		return
+	}
+	key := render.TaskKey{Frame: fr, Tile: tile}
+	done, err := strconv.Atoi(r.FormValue("done"))
+	if r.FormValue("done") != "" && (err != nil || done < 0) {
+		sendState(w, http.StatusBadRequest, "Error : invalid parameter 'done'")
+		return
+	}
+	if isIn(r.FormValue("state"), []string{"rendering", "rendered", "requeue", "error"}) == -1 {
+		sendState(w, http.StatusBadRequest, "Error : invalid parameter 'state'")
+		return
+	}
 
 	var t *Render
 
 	st = "Error : No matching Renders"
+	if _, ok := ws.deleted.Load(r.FormValue("id")); ok {
+		st = "DELETED"
+	}
 	tmpMap, ok := ws.Renders.Load(r.FormValue("id"))
 	if ok {
-		rdr, ok := tmpMap.(*sync.Map).Load(fr)
+		rdr, ok := tmpMap.(*sync.Map).Load(key)
 		if ok {
 			switch rst := rdr.(*Render).myTask.State; rst {
 
@@ -96,21 +122,38 @@ func (ws *WorkingSet) UpdateJob(w http.ResponseWriter, r *http.Request) {
 				if r.FormValue("state") == "requeue" {
 					st = "REQUEUED"
 					t = rdr.(*Render)
-					tmpMap.(*sync.Map).Delete(fr)
+					tmpMap.(*sync.Map).Delete(key)
 					t.myTask.Lock()
-					t.myTask.State = "waiting"
-					t.Percent = "0.0"
-					t.Mem = "0.0"
+					if done > t.done {
+						t.done = done
+					}
 					t.myTask.Unlock()
+					ws.requeue(t, nil)
 					t.myNode.SetState("available")
 					ws.DBTransacts.Add(&rendererdb.DBTransact{
 						OP:       rendererdb.UPDATENODE,
 						Argument: t.myNode,
 					})
+				} else if r.FormValue("state") == "error" {
 
+					//The failed render counts as an attempt, the node not being given the frame again
+					st = "FAILED"
+					t = rdr.(*Render)
+					tmpMap.(*sync.Map).Delete(key)
+					t.myTask.Lock()
+					if done > t.done {
+						t.done = done
+					}
+					t.myTask.Unlock()
+					reason := "render failed on node " + n.Name
+					if r.FormValue("warning") != "" {
+						reason += " : " + r.FormValue("warning")
+					}
+					ws.requeue(t, &failure{node: t.myNode.Key(), reason: reason})
+					t.myNode.Free()
 					ws.DBTransacts.Add(&rendererdb.DBTransact{
-						OP:       rendererdb.UPDATETASK,
-						Argument: t.myTask,
+						OP:       rendererdb.UPDATENODE,
+						Argument: t.myNode,
 					})
 				} else {
 					//Update render stats
@@ -119,6 +162,17 @@ func (ws *WorkingSet) UpdateJob(w http.ResponseWriter, r *http.Request) {
 					t.myTask.State = r.FormValue("state")
 					t.Percent = r.FormValue("percent")
 					t.Mem = r.FormValue("mem")
+					t.expires = time.Now().Add(ws.Config.leaseDuration())
+					ws.renewTransfer(t.transfer)
+					if done > t.done {
+						t.done = done
+					}
+					if w := r.FormValue("warning"); w != t.Warning {
+						if w != "" {
+							fmt.Printf("Warning from node %s on frame %d of %s : %s\n", n.Name, fr, t.myTask.ID, w)
+						}
+						t.Warning = w
+					}
 					t.myTask.Unlock()
 
 					//Handle the case 'frame rendered'
@@ -132,23 +186,82 @@ func (ws *WorkingSet) UpdateJob(w http.ResponseWriter, r *http.Request) {
 						})
 
 						//Removing task from Renders and updating database
-						tmpMap.(*sync.Map).Delete(fr)
+						tmpMap.(*sync.Map).Delete(key)
+						ws.Scheduler.Release(t.myTask)
 						ws.DBTransacts.Add(&rendererdb.DBTransact{
 							OP:       rendererdb.UPDATETASK,
 							Argument: t.myTask,
 						})
+
+						//Assemble the frame once all its tiles are rendered
+						if t.myTask.Settings.Tiled() {
+							go func(tsk *render.Task) {
+								if err := ws.stitchFrame(tsk); err != nil {
+									fmt.Printf("Couldn't stitch the tiles of frame %d of %s : %s\n", tsk.Frame, tsk.ID, err.Error())
+								}
+							}(t.myTask)
+						}
 					}
 					st = "OK"
 				}
 
+			//Paused frame whose node must stop
+			case "paused":
+
+				//The frame may have been resumed meanwhile, the node then goes on rendering
+				st = "OK"
+				t = rdr.(*Render)
+				t.myTask.Lock()
+				stopped := t.myTask.State == "paused"
+				if stopped {
+					tmpMap.(*sync.Map).Delete(key)
+					if done > t.done {
+						t.done = done
+					}
+					done = t.done
+				}
+				t.myTask.Unlock()
+
+				if stopped {
+					//Notify worker and keep the frames it rendered
+					st = "PAUSE"
+					ws.Scheduler.Release(t.myTask)
+					if rest := t.myTask.Split(done); rest != nil {
+						rest.SetState("paused")
+						tasksMap, _ := ws.Tasks.LoadOrStore(rest.ID, new(sync.Map))
+						tasksMap.(*sync.Map).Store(rest.Key(), rest)
+						ws.DBTransacts.Add(&rendererdb.DBTransact{
+							OP:       rendererdb.INSERTPROJECT,
+							Argument: []*render.Task{rest},
+						})
+					}
+					ws.DBTransacts.Add(&rendererdb.DBTransact{
+						OP:       rendererdb.UPDATETASK,
+						Argument: t.myTask,
+					})
+
+					t.myNode.Free()
+					ws.DBTransacts.Add(&rendererdb.DBTransact{
+						OP:       rendererdb.UPDATENODE,
+						Argument: t.myNode,
+					})
+				}
+
 			//Aborted frame
 			case "abort":
 
 				//Notify worker
 				st = "ABORT"
 
-				//Delete job from on progress renders
-				tmpMap.(*sync.Map).Delete(fr)
+				//Delete job from on progress renders and free the node
+				t = rdr.(*Render)
+				tmpMap.(*sync.Map).Delete(key)
+				ws.Scheduler.Release(t.myTask)
+				t.myNode.Free()
+				ws.DBTransacts.Add(&rendererdb.DBTransact{
+					OP:       rendererdb.UPDATENODE,
+					Argument: t.myNode,
+				})
 
 			//Default
 			default:
@@ -5,16 +5,18 @@ import (
 	"fmt"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"sync"
 
+	"github.com/LeoMarche/blenderer/src/filexchange"
 	"github.com/LeoMarche/blenderer/src/render"
 	"github.com/LeoMarche/blenderer/src/rendererdb"
+	"github.com/LeoMarche/blenderer/src/utils"
 )
 
 //UploadCompleted must be triggered by client when the upload is completed in the good folder
-//The request should be a post with values id, api_key, size and project and input
+//The request should be a post with values id, api_key, size, sha256 and project and input. The upload is completed if
+//the uploaded file has the size and the SHA-256 given.
 func (ws *WorkingSet) UploadCompleted(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" || r.URL.Path != "/uploadCompleted" {
 		http.Error(w, "404 not found.", http.StatusNotFound)
@@ -31,9 +33,18 @@ func (ws *WorkingSet) UploadCompleted(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resp := new(ReturnValue)
+	pat, err := utils.SafeJoin(ws.Config.Folder, r.FormValue("id"), r.FormValue("input"))
+	if err != nil {
+		sendState(w, http.StatusBadRequest, "Error : "+err.Error())
+		return
+	}
 
-	pat := filepath.Join(ws.Config.Folder, r.FormValue("id"), r.FormValue("input"))
+	if r.FormValue("sha256") == "" {
+		sendState(w, http.StatusBadRequest, "Error : Missing Parameter")
+		return
+	}
+
+	resp := new(ReturnValue)
 
 	expSize, err := strconv.Atoi(r.FormValue("size"))
 
@@ -42,22 +53,43 @@ func (ws *WorkingSet) UploadCompleted(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if st, err := os.Stat(pat); err == nil {
-		if st.Size() == int64(expSize) {
-
+		if st.Size() != int64(expSize) {
+			resp.State = "Uploading"
+		} else if sum, err := filexchange.Checksum(pat); err != nil {
+			resp.State = "General error"
+		} else if sum != r.FormValue("sha256") {
+			resp.State = "Checksum mismatch"
+		} else {
 			resp.State = "Completed"
 			tmpMap, ok := ws.Tasks.Load(r.FormValue("id"))
 			if ok {
+				paused := ws.jobPaused(r.FormValue("id"))
 				tmpMap.(*sync.Map).Range(func(k, v interface{}) bool {
-					v.(*render.Task).SetState("waiting")
-					ws.DBTransacts.Add(&rendererdb.DBTransact{
-						OP:       rendererdb.UPDATETASK,
-						Argument: v.(*render.Task),
-					})
+					tsk := v.(*render.Task)
+
+					//Only the frames still uploading are scheduled, in case the upload is completed twice.
+					//The frames of a job paused during the upload stay paused.
+					tsk.Lock()
+					uploading := tsk.State == "uploading"
+					if uploading && paused {
+						tsk.State = "paused"
+					} else if uploading {
+						tsk.State = "waiting"
+					}
+					tsk.Unlock()
+
+					if uploading {
+						if !paused {
+							ws.Scheduler.Add(tsk)
+						}
+						ws.DBTransacts.Add(&rendererdb.DBTransact{
+							OP:       rendererdb.UPDATETASK,
+							Argument: tsk,
+						})
+					}
 					return true
 				})
 			}
-		} else {
-			resp.State = "Uploading"
 		}
 
 	} else if os.IsNotExist(err) {
@@ -2,6 +2,8 @@ package rendererdb
 
 import (
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -18,6 +20,8 @@ const (
 	INSERTPROJECT int = 1
 	UPDATENODE    int = 2
 	INSERTNODE    int = 3
+	DELETEPROJECT int = 4
+	PAUSEPROJECT  int = 5
 )
 
 type DBTransact struct {
@@ -25,6 +29,52 @@ type DBTransact struct {
 	Argument interface{}
 }
 
+//ProjectPause is the argument of PAUSEPROJECT, storing whether the job ID is paused
+type ProjectPause struct {
+	ID     string
+	Paused bool
+}
+
+//column describes a column added to a table after its creation
+type column struct {
+	table      string
+	name       string
+	definition string
+}
+
+//addedColumns are the columns added to the tables since their creation, in order of addition
+var addedColumns = []column{
+	{"projects", "scene", "TEXT DEFAULT ''"},
+	{"projects", "camera", "TEXT DEFAULT ''"},
+	{"projects", "resolutionX", "integer DEFAULT 0"},
+	{"projects", "resolutionY", "integer DEFAULT 0"},
+	{"projects", "resolutionPercent", "integer DEFAULT 0"},
+	{"projects", "samples", "integer DEFAULT 0"},
+	{"projects", "format", "TEXT DEFAULT ''"},
+	{"projects", "colorDepth", "TEXT DEFAULT ''"},
+	{"projects", "bundle", "TEXT DEFAULT ''"},
+	{"projects", "priority", "integer DEFAULT 0"},
+	{"projects", "submitted", "integer DEFAULT 0"},
+	{"projects", "owner", "TEXT DEFAULT ''"},
+	{"compute_nodes", "renderers", "TEXT DEFAULT '[]'"},
+	{"projects", "frameEnd", "integer DEFAULT 0"},
+	{"projects", "tile", "integer DEFAULT 0"},
+	{"projects", "tilesX", "integer DEFAULT 0"},
+	{"projects", "tilesY", "integer DEFAULT 0"},
+	{"projects", "attempts", "integer DEFAULT 0"},
+	{"projects", "lastError", "TEXT DEFAULT ''"},
+	{"projects", "failedNodes", "TEXT DEFAULT '[]'"},
+	{"projects", "paused", "integer DEFAULT 0"},
+}
+
+//nodeColumns are the columns read and written for each node
+const nodeColumns = `name, ip, api_key, state, renderers`
+
+//projectColumns are the columns read and written for each task
+const projectColumns = `project, id, input, output, frame, state, rendererName, rendererVersion, startTime,
+	scene, camera, resolutionX, resolutionY, resolutionPercent, samples, format, colorDepth, bundle, priority, submitted, owner, frameEnd,
+	tile, tilesX, tilesY, attempts, lastError, failedNodes`
+
 //Creates the two needed tables in sql database
 func createTable(db *sql.DB) {
 
@@ -63,6 +113,26 @@ func createTable(db *sql.DB) {
 	tx.Commit()
 }
 
+//Adds the columns missing in the tables of an older database
+func upgradeTables(db *sql.DB) error {
+	for _, c := range addedColumns {
+		row, err := db.Query("SELECT name FROM pragma_table_info(?) WHERE name = ?", c.table, c.name)
+		if err != nil {
+			return err
+		}
+		exists := row.Next()
+		row.Close()
+
+		if !exists {
+			_, err = db.Exec("ALTER TABLE " + c.table + " ADD COLUMN \"" + c.name + "\" " + c.definition)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 //LoadDatabase loads a sqlite database from a file
 func LoadDatabase(dbName string) (*sql.DB, error) {
 
@@ -86,12 +156,16 @@ func LoadDatabase(dbName string) (*sql.DB, error) {
 		createTable(dB)
 	}
 
+	if err := upgradeTables(dB); err != nil {
+		return nil, err
+	}
+
 	return dB, nil
 }
 
 //LoadNodeFromDB loads nodes from a sqlite database
 func LoadNodeFromDB(db *sql.DB, t *sync.Map) error {
-	row, err := db.Query("SELECT * FROM compute_nodes")
+	row, err := db.Query("SELECT " + nodeColumns + " FROM compute_nodes")
 
 	if err != nil {
 		return err
@@ -100,18 +174,24 @@ func LoadNodeFromDB(db *sql.DB, t *sync.Map) error {
 	defer row.Close()
 
 	for row.Next() { // Iterate and fetch the records from result cursor
-		var na, ip, apiKey, st string
+		var na, ip, apiKey, st, rd string
+		var renderers []node.Renderer
 
-		err = row.Scan(&na, &ip, &apiKey, &st)
+		err = row.Scan(&na, &ip, &apiKey, &st, &rd)
 
 		if err != nil {
 			return err
 		}
 
+		if err = json.Unmarshal([]byte(rd), &renderers); err != nil {
+			return fmt.Errorf("couldn't read renderers of node %s with IP %s : %s", na, ip, err.Error())
+		}
+
 		t.Store(na+"//"+ip, &node.Node{
-			Name:   na,
-			IP:     ip,
-			APIKey: apiKey,
+			Name:      na,
+			IP:        ip,
+			APIKey:    apiKey,
+			Renderers: renderers,
 		})
 
 		newnode, ok := t.Load(na + "//" + ip)
@@ -124,9 +204,10 @@ func LoadNodeFromDB(db *sql.DB, t *sync.Map) error {
 	return nil
 }
 
-//LoadTasksFromDB loads tasks from sqlite db
-func LoadTasksFromDB(db *sql.DB, t *sync.Map) error {
-	row, err := db.Query("SELECT * FROM projects")
+//LoadTasksFromDB loads tasks from sqlite db, and the IDs of the paused jobs in paused
+//Frames being rendered are waiting again, or paused if their job is paused.
+func LoadTasksFromDB(db *sql.DB, t *sync.Map, paused *sync.Map) error {
+	row, err := db.Query("SELECT " + projectColumns + ", paused FROM projects")
 
 	if err != nil {
 		return err
@@ -136,38 +217,74 @@ func LoadTasksFromDB(db *sql.DB, t *sync.Map) error {
 
 	for row.Next() {
 
-		var pr, id, in, ou, st, rN, rV, sT string
-		var fr int
+		var pr, id, in, ou, st, rN, rV, sT, bu, ow, le, fn string
+		var fr, pri, fe, ti, at, pa int
+		var sub int64
+		var se render.Settings
 
-		err = row.Scan(&pr, &id, &in, &ou, &fr, &st, &rN, &rV, &sT)
+		err = row.Scan(&pr, &id, &in, &ou, &fr, &st, &rN, &rV, &sT,
+			&se.Scene, &se.Camera, &se.ResolutionX, &se.ResolutionY, &se.ResolutionPercent, &se.Samples, &se.Format, &se.ColorDepth, &bu, &pri, &sub, &ow, &fe,
+			&ti, &se.TilesX, &se.TilesY, &at, &le, &fn, &pa)
 
 		if err != nil {
 			return err
 		}
 
-		if st != "failed" && st != "completed" {
+		var failedNodes []string
+		if err = json.Unmarshal([]byte(fn), &failedNodes); err != nil {
+			return err
+		}
+
+		//Failed tasks are kept to be listed, they aren't rendered again
+		if st != "completed" {
 			newMap := new(sync.Map)
 			tmpMap, _ := t.LoadOrStore(id, newMap)
 			if st == "rendering" {
 				st = "waiting"
 			}
-			tmpMap.(*sync.Map).Store(fr, &render.Task{
+			if pa != 0 {
+				paused.Store(id, true)
+			}
+			tmpMap.(*sync.Map).Store(render.TaskKey{Frame: fr, Tile: ti}, &render.Task{
 				Project:         pr,
 				ID:              id,
 				Input:           in,
 				Output:          ou,
 				Frame:           fr,
+				FrameEnd:        fe,
+				Tile:            ti,
 				State:           st,
 				RendererName:    rN,
 				RendererVersion: rV,
 				StartTime:       sT,
+				Settings:        se,
+				Bundle:          bu,
+				Priority:        pri,
+				Submitted:       sub,
+				Owner:           ow,
+				Attempts:        at,
+				LastError:       le,
+				FailedNodes:     failedNodes,
 			})
 		}
 	}
+
+	//The frames that were being rendered stay paused with the rest of their job
+	paused.Range(func(key, value interface{}) bool {
+		if tmpMap, ok := t.Load(key); ok {
+			tmpMap.(*sync.Map).Range(func(key2, value2 interface{}) bool {
+				if value2.(*render.Task).State == "waiting" {
+					value2.(*render.Task).State = "paused"
+				}
+				return true
+			})
+		}
+		return true
+	})
 	return nil
 }
 
-//Updates a project in database
+//Updates the mutable fields of a project in database
 func UpdateTaskInDB(db *sql.DB, rt *render.Task) error {
 	tx, err := db.Begin()
 
@@ -175,14 +292,23 @@ func UpdateTaskInDB(db *sql.DB, rt *render.Task) error {
 		return err
 	}
 
-	reqTask := "UPDATE projects SET state = ? WHERE project = ? AND id = ? AND frame = ?"
+	reqTask := `UPDATE projects SET state = ?, priority = ?, frameEnd = ?, attempts = ?, lastError = ?, failedNodes = ?
+		WHERE project = ? AND id = ? AND frame = ? AND tile = ?`
 	statement, err := db.Prepare(reqTask)
 
 	if err != nil {
 		tx.Rollback()
 		return nil
 	}
-	_, err = statement.Exec(rt.State, rt.Project, rt.ID, rt.Frame)
+	rt.Lock()
+	state, priority, frameEnd, attempts, lastError := rt.State, rt.Priority, rt.FrameEnd, rt.Attempts, rt.LastError
+	failedNodes, err := json.Marshal(append([]string{}, rt.FailedNodes...))
+	rt.Unlock()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = statement.Exec(state, priority, frameEnd, attempts, lastError, string(failedNodes), rt.Project, rt.ID, rt.Frame, rt.Tile)
 
 	if err != nil {
 		tx.Rollback()
@@ -200,13 +326,67 @@ func UpdateNodeInDB(db *sql.DB, nd *node.Node) error {
 		return err
 	}
 
-	reqNode := "UPDATE compute_nodes SET state = ? WHERE name = ? AND ip = ?"
+	renderers, err := json.Marshal(nd.GetRenderers())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	reqNode := "UPDATE compute_nodes SET state = ?, renderers = ? WHERE name = ? AND ip = ?"
 	statement, err := db.Prepare(reqNode)
 	if err != nil {
 		return err
 	}
 
-	_, err = statement.Exec(nd.State(), nd.Name, nd.IP)
+	_, err = statement.Exec(nd.State(), string(renderers), nd.Name, nd.IP)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//DeleteProjectInDB deletes every task of the job id from the database
+func DeleteProjectInDB(db *sql.DB, id string) error {
+	tx, err := db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	statement, err := db.Prepare("DELETE FROM projects WHERE id = ?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = statement.Exec(id)
+
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+//PauseProjectInDB stores whether the job id is paused, see LoadTasksFromDB
+func PauseProjectInDB(db *sql.DB, id string, paused bool) error {
+	tx, err := db.Begin()
+
+	if err != nil {
+		return err
+	}
+
+	statement, err := db.Prepare("UPDATE projects SET paused = ? WHERE id = ?")
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	_, err = statement.Exec(paused, id)
 
 	if err != nil {
 		tx.Rollback()
@@ -224,7 +404,7 @@ func InsertProjectsInDB(db *sql.DB, it []*render.Task) error {
 		return err
 	}
 
-	rq := "INSERT INTO projects VALUES(?,?,?,?,?,?,?,?,?)"
+	rq := "INSERT INTO projects (" + projectColumns + ") VALUES(?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)"
 	statement, err := db.Prepare(rq)
 
 	if err != nil {
@@ -232,6 +412,12 @@ func InsertProjectsInDB(db *sql.DB, it []*render.Task) error {
 	}
 
 	for i := 0; i < len(it); i++ {
+		failedNodes, err := json.Marshal(append([]string{}, it[i].FailedNodes...))
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
 		_, err = statement.Exec(
 			it[i].Project,
 			it[i].ID,
@@ -241,7 +427,26 @@ func InsertProjectsInDB(db *sql.DB, it []*render.Task) error {
 			it[i].State,
 			it[i].RendererName,
 			it[i].RendererVersion,
-			it[i].StartTime)
+			it[i].StartTime,
+			it[i].Settings.Scene,
+			it[i].Settings.Camera,
+			it[i].Settings.ResolutionX,
+			it[i].Settings.ResolutionY,
+			it[i].Settings.ResolutionPercent,
+			it[i].Settings.Samples,
+			it[i].Settings.Format,
+			it[i].Settings.ColorDepth,
+			it[i].Bundle,
+			it[i].Priority,
+			it[i].Submitted,
+			it[i].Owner,
+			it[i].FrameEnd,
+			it[i].Tile,
+			it[i].Settings.TilesX,
+			it[i].Settings.TilesY,
+			it[i].Attempts,
+			it[i].LastError,
+			string(failedNodes))
 		if err != nil {
 			tx.Rollback()
 			return err
@@ -258,14 +463,20 @@ func InsertNodeInDB(db *sql.DB, n *node.Node) error {
 		return err
 	}
 
-	rq := "INSERT INTO compute_nodes VALUES(?,?,?,?)"
+	renderers, err := json.Marshal(n.GetRenderers())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	rq := "INSERT INTO compute_nodes (" + nodeColumns + ") VALUES(?,?,?,?,?)"
 	statement, err := db.Prepare(rq)
 
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
-	_, err = statement.Exec(n.Name, n.IP, n.APIKey, n.State())
+	_, err = statement.Exec(n.Name, n.IP, n.APIKey, n.State(), string(renderers))
 
 	if err != nil {
 		tx.Rollback()
@@ -275,35 +486,45 @@ func InsertNodeInDB(db *sql.DB, n *node.Node) error {
 	return tx.Commit()
 }
 
+//Apply runs the transaction on the database
+func (t *DBTransact) Apply(db *sql.DB) error {
+	switch t.OP {
+	case UPDATETASK:
+		if err := UpdateTaskInDB(db, t.Argument.(*render.Task)); err != nil {
+			return fmt.Errorf("error when trying to update Task in DB : %s", err.Error())
+		}
+	case INSERTPROJECT:
+		if err := InsertProjectsInDB(db, t.Argument.([]*render.Task)); err != nil {
+			return errors.New("Error when trying to insert Project in DB")
+		}
+	case UPDATENODE:
+		if err := UpdateNodeInDB(db, t.Argument.(*node.Node)); err != nil {
+			return fmt.Errorf("Error when trying to update Node in DB : %s", err.Error())
+		}
+	case INSERTNODE:
+		if err := InsertNodeInDB(db, t.Argument.(*node.Node)); err != nil {
+			return errors.New("Error when trying to insert Node in DB")
+		}
+	case DELETEPROJECT:
+		if err := DeleteProjectInDB(db, t.Argument.(string)); err != nil {
+			return fmt.Errorf("Error when trying to delete Project in DB : %s", err.Error())
+		}
+	case PAUSEPROJECT:
+		pp := t.Argument.(*ProjectPause)
+		if err := PauseProjectInDB(db, pp.ID, pp.Paused); err != nil {
+			return fmt.Errorf("Error when trying to pause Project in DB : %s", err.Error())
+		}
+	}
+	return nil
+}
+
 func DBTransactRoutines(db *sql.DB, transacts *fifo.Queue, stopDB *bool) {
 
 	for !(*stopDB) {
 		t := transacts.Next()
 		for t != nil {
-			OP := t.(*DBTransact).OP
-			arg := t.(*DBTransact).Argument
-
-			switch OP {
-			case 0:
-				err := UpdateTaskInDB(db, arg.(*render.Task))
-				if err != nil {
-					fmt.Printf("error when trying to update Task in DB : %s\n", err.Error())
-				}
-			case 1:
-				err := InsertProjectsInDB(db, arg.([]*render.Task))
-				if err != nil {
-					fmt.Println("Error when trying to insert Project in DB")
-				}
-			case 2:
-				err := UpdateNodeInDB(db, arg.(*node.Node))
-				if err != nil {
-					fmt.Printf("Error when trying to update Node in DB : %s\n", err.Error())
-				}
-			case 3:
-				err := InsertNodeInDB(db, arg.(*node.Node))
-				if err != nil {
-					fmt.Println("Error when trying to insert Node in DB")
-				}
+			if err := t.(*DBTransact).Apply(db); err != nil {
+				fmt.Println(err.Error())
 			}
 
 			t = transacts.Next()
@@ -0,0 +1,134 @@
+package scheduler
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/node"
+	"github.com/LeoMarche/blenderer/src/render"
+)
+
+//fairShare shares the nodes between the owners of the tasks, then between the projects of each owner,
+//in proportion to their weights. The fifo order applies between tasks of equal shares.
+type fairShare struct {
+	ownerWeights   map[string]float64
+	projectWeights map[string]float64
+	waiting        map[string]map[string]queues //Waiting tasks by owner, then by project
+	owners         map[string]int               //Tasks being rendered by owner
+	projects       map[string]int               //Tasks being rendered by owner+"//"+project
+	sync.Mutex
+}
+
+//NewFairShare returns a scheduler sharing the nodes between the owners of the tasks, then between the projects
+//of each owner, in proportion to their weights (1 if not set)
+func NewFairShare(ownerWeights, projectWeights map[string]float64) Scheduler {
+	return &fairShare{
+		ownerWeights:   ownerWeights,
+		projectWeights: projectWeights,
+		waiting:        make(map[string]map[string]queues),
+		owners:         make(map[string]int),
+		projects:       make(map[string]int),
+	}
+}
+
+//weight returns the weight of key in weights, 1 if not set
+func weight(weights map[string]float64, key string) float64 {
+	if w, ok := weights[key]; ok && w > 0 {
+		return w
+	}
+	return 1
+}
+
+//Add makes the waiting task t available to the nodes
+func (s *fairShare) Add(t *render.Task) {
+	e := newEntry(t)
+
+	s.Lock()
+	defer s.Unlock()
+
+	projects, ok := s.waiting[t.Owner]
+	if !ok {
+		projects = make(map[string]queues)
+		s.waiting[t.Owner] = projects
+	}
+	qs, ok := projects[t.Project]
+	if !ok {
+		qs = make(queues)
+		projects[t.Project] = qs
+	}
+	qs.add(e)
+}
+
+//Next commissions n and returns the first waiting task it supports of the owner, then the project, using the fewest nodes
+func (s *fairShare) Next(n *node.Node) (*render.Task, error) {
+	if !n.Commission() {
+		return nil, ErrNodeBusy
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for {
+		var best *queue
+		var bestIndex int
+		var bestEntry entry
+		var bestOwner, bestProject float64
+
+		for owner, projects := range s.waiting {
+			ownerShare := float64(s.owners[owner]) / weight(s.ownerWeights, owner)
+
+			for project, qs := range projects {
+				q, i, e, ok := qs.peek(n)
+				if !ok {
+					if len(qs) == 0 {
+						delete(projects, project)
+					}
+					continue
+				}
+				projectShare := float64(s.projects[owner+"//"+project]) / weight(s.projectWeights, project)
+
+				if best == nil || ownerShare < bestOwner ||
+					(ownerShare == bestOwner && (projectShare < bestProject ||
+						(projectShare == bestProject && e.before(bestEntry)))) {
+					best, bestIndex, bestEntry, bestOwner, bestProject = q, i, e, ownerShare, projectShare
+				}
+			}
+
+			if len(projects) == 0 {
+				delete(s.waiting, owner)
+			}
+		}
+
+		if best == nil {
+			n.Free()
+			return nil, ErrNoTask
+		}
+
+		heap.Remove(best, bestIndex)
+		if bestEntry.take() {
+			s.owners[bestEntry.task.Owner]++
+			s.projects[bestEntry.task.Owner+"//"+bestEntry.task.Project]++
+			return bestEntry.task, nil
+		}
+	}
+}
+
+//Release stops counting t in the nodes used by its owner and its project
+func (s *fairShare) Release(t *render.Task) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.owners[t.Owner] > 0 {
+		s.owners[t.Owner]--
+	}
+	if s.projects[t.Owner+"//"+t.Project] > 0 {
+		s.projects[t.Owner+"//"+t.Project]--
+	}
+}
+
+//Requeue puts t back in the waiting tasks
+func (s *fairShare) Requeue(t *render.Task) {
+	s.Release(t)
+	t.SetState("waiting")
+	s.Add(t)
+}
@@ -0,0 +1,63 @@
+package scheduler
+
+import (
+	"container/heap"
+	"sync"
+
+	"github.com/LeoMarche/blenderer/src/node"
+	"github.com/LeoMarche/blenderer/src/render"
+)
+
+//fifo gives the waiting tasks by priority, then in order of submission
+type fifo struct {
+	waiting queues
+	sync.Mutex
+}
+
+//NewFIFO returns a scheduler giving the waiting tasks by priority, then in order of submission
+func NewFIFO() Scheduler {
+	return &fifo{waiting: make(queues)}
+}
+
+//Add makes the waiting task t available to the nodes
+func (s *fifo) Add(t *render.Task) {
+	e := newEntry(t)
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.waiting.add(e)
+}
+
+//Next commissions n and returns the first waiting task it supports
+func (s *fifo) Next(n *node.Node) (*render.Task, error) {
+	if !n.Commission() {
+		return nil, ErrNodeBusy
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	for {
+		q, i, e, ok := s.waiting.peek(n)
+		if !ok {
+			break
+		}
+		heap.Remove(q, i)
+		if e.take() {
+			return e.task, nil
+		}
+	}
+
+	n.Free()
+	return nil, ErrNoTask
+}
+
+//Release does nothing as the order of the tasks doesn't depend on the ones being rendered
+func (s *fifo) Release(t *render.Task) {}
+
+//Requeue puts t back in the waiting tasks
+func (s *fifo) Requeue(t *render.Task) {
+	t.SetState("waiting")
+	s.Add(t)
+}
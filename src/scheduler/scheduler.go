@@ -0,0 +1,184 @@
+package scheduler
+
+import (
+	"container/heap"
+	"errors"
+
+	"github.com/LeoMarche/blenderer/src/node"
+	"github.com/LeoMarche/blenderer/src/render"
+)
+
+//ErrNoTask is returned by Next when no task the node supports is waiting
+var ErrNoTask = errors.New("no task waiting")
+
+//ErrNodeBusy is returned by Next when the node can't be commissioned
+var ErrNodeBusy = errors.New("node isn't available")
+
+//Scheduler decides which waiting task each node renders
+type Scheduler interface {
+	//Add makes the waiting task t available to the nodes, it must be called again when its priority changes
+	Add(t *render.Task)
+
+	//Next commissions n and returns the task it must render among the ones it supports, put in "rendering" state
+	Next(n *node.Node) (*render.Task, error)
+
+	//Release tells the scheduler that t, given by Next, isn't rendered by its node anymore
+	Release(t *render.Task)
+
+	//Requeue puts t, given by Next, back in "waiting" state for another node to render it
+	Requeue(t *render.Task)
+}
+
+//New returns the scheduler of the given kind, "fifo" (default) or "fairshare"
+func New(kind string, ownerWeights, projectWeights map[string]float64) (Scheduler, error) {
+	switch kind {
+	case "", "fifo":
+		return NewFIFO(), nil
+	case "fairshare":
+		return NewFairShare(ownerWeights, projectWeights), nil
+	}
+	return nil, errors.New("unknown scheduler " + kind)
+}
+
+//entry is a task in a queue with the fields ordering it, read under its lock when it was added
+type entry struct {
+	task      *render.Task
+	renderer  node.Renderer
+	priority  int
+	submitted int64
+}
+
+func newEntry(t *render.Task) entry {
+	t.Lock()
+	defer t.Unlock()
+
+	return entry{
+		task:      t,
+		renderer:  node.Renderer{Name: t.RendererName, Version: t.RendererVersion},
+		priority:  t.Priority,
+		submitted: t.Submitted,
+	}
+}
+
+//before returns true if e must be rendered before o : highest priority first, then oldest submission,
+//then lowest frame number and tile, the ID of the jobs breaking ties between jobs submitted at the same time
+func (e entry) before(o entry) bool {
+	if e.priority != o.priority {
+		return e.priority > o.priority
+	}
+	if e.submitted != o.submitted {
+		return e.submitted < o.submitted
+	}
+	if e.task.ID != o.task.ID {
+		return e.task.ID < o.task.ID
+	}
+	if e.task.Frame != o.task.Frame {
+		return e.task.Frame < o.task.Frame
+	}
+	return e.task.Tile < o.task.Tile
+}
+
+//valid returns true if the task of e is still waiting with the priority e was added with.
+//Entries of tasks aborted, commissioned or whose priority changed since are skipped.
+func (e entry) valid() bool {
+	e.task.Lock()
+	defer e.task.Unlock()
+
+	return e.task.State == "waiting" && e.task.Priority == e.priority
+}
+
+//take puts the task of e in "rendering" state if e is still valid and returns true if it did
+func (e entry) take() bool {
+	e.task.Lock()
+	defer e.task.Unlock()
+
+	if e.task.State != "waiting" || e.task.Priority != e.priority {
+		return false
+	}
+	e.task.State = "rendering"
+	return true
+}
+
+//queue is a heap of entries, the first one being rendered first
+type queue []entry
+
+func (q queue) Len() int            { return len(q) }
+func (q queue) Less(i, j int) bool  { return q[i].before(q[j]) }
+func (q queue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *queue) Push(x interface{}) { *q = append(*q, x.(entry)) }
+
+func (q *queue) Pop() interface{} {
+	old := *q
+	e := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return e
+}
+
+//peek drops the invalid entries at the top of q and returns the first valid one, or false if q is empty
+func (q *queue) peek() (entry, bool) {
+	for q.Len() > 0 {
+		if (*q)[0].valid() {
+			return (*q)[0], true
+		}
+		heap.Pop(q)
+	}
+	return entry{}, false
+}
+
+//first returns the index in q of the first valid entry that didn't fail on n, or false if there is none.
+//The whole queue is only searched when the top entry already failed on n.
+func (q *queue) first(n *node.Node) (int, bool) {
+	e, ok := q.peek()
+	if !ok {
+		return -1, false
+	}
+	if !e.task.FailedOn(n.Key()) {
+		return 0, true
+	}
+
+	best := -1
+	for i, e := range *q {
+		if e.valid() && !e.task.FailedOn(n.Key()) && (best == -1 || e.before((*q)[best])) {
+			best = i
+		}
+	}
+	return best, best >= 0
+}
+
+//queues are the queues of the waiting tasks by renderer, so that a node only looks at the tasks it can render
+type queues map[node.Renderer]*queue
+
+//add pushes e in the queue of its renderer
+func (qs queues) add(e entry) {
+	q, ok := qs[e.renderer]
+	if !ok {
+		q = new(queue)
+		qs[e.renderer] = q
+	}
+	heap.Push(q, e)
+}
+
+//peek returns the first valid entry n didn't fail to render among the queues of the renderers it supports, its queue
+//and its index in the queue. The queues left empty are deleted.
+func (qs queues) peek(n *node.Node) (*queue, int, entry, bool) {
+	var best *queue
+	var bestIndex int
+	var bestEntry entry
+
+	for r, q := range qs {
+		if !n.Supports(r.Name, r.Version) {
+			continue
+		}
+		i, ok := q.first(n)
+		if !ok {
+			if q.Len() == 0 {
+				delete(qs, r)
+			}
+			continue
+		}
+		if e := (*q)[i]; best == nil || e.before(bestEntry) {
+			best, bestIndex, bestEntry = q, i, e
+		}
+	}
+	return best, bestIndex, bestEntry, best != nil
+}
@@ -0,0 +1,220 @@
+package scheduler
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/LeoMarche/blenderer/src/node"
+	"github.com/LeoMarche/blenderer/src/render"
+	"github.com/stretchr/testify/assert"
+)
+
+func newNode(renderers ...node.Renderer) *node.Node {
+	n := new(node.Node)
+	n.SetState("available")
+	if len(renderers) == 0 {
+		renderers = []node.Renderer{{Name: "blender", Version: "2.91.0"}}
+	}
+	n.SetRenderers(renderers)
+	return n
+}
+
+func newTask(owner, project, id string, frame, priority int, submitted int64) *render.Task {
+	return &render.Task{
+		Owner:           owner,
+		Project:         project,
+		ID:              id,
+		Frame:           frame,
+		State:           "waiting",
+		RendererName:    "blender",
+		RendererVersion: "2.91.0",
+		Priority:        priority,
+		Submitted:       submitted,
+	}
+}
+
+//next gives the tasks to available nodes until none is left and returns them in order as "ID/frame"
+func next(s Scheduler) ([]string, []*render.Task) {
+	order := []string{}
+	tasks := []*render.Task{}
+	for {
+		t, err := s.Next(newNode())
+		if err != nil {
+			return order, tasks
+		}
+		order = append(order, t.ID+"/"+strconv.Itoa(t.Frame))
+		tasks = append(tasks, t)
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	assert := assert.New(t)
+
+	kinds := []string{"", "fifo", "fairshare", "roundrobin"}
+	errs := []bool{false, false, false, true}
+
+	for i := 0; i < len(kinds); i++ {
+		s, err := New(kinds[i], nil, nil)
+		assert.Equal(errs[i], err != nil, kinds[i])
+		assert.Equal(errs[i], s == nil, kinds[i])
+	}
+}
+
+func TestFIFO(t *testing.T) {
+
+	assert := assert.New(t)
+
+	s := NewFIFO()
+	a1 := newTask("u", "p", "a", 1, 0, 2)
+	a2 := newTask("u", "p", "a", 2, 0, 2)
+	b1 := newTask("u", "p", "b", 1, 0, 1)
+	c1 := newTask("u", "p", "c", 1, 5, 3)
+	d1 := newTask("u", "p", "d", 1, 0, 0)
+	for _, tsk := range []*render.Task{a2, a1, b1, c1, d1} {
+		s.Add(tsk)
+	}
+
+	//Aborted tasks are skipped, the ones whose priority changed are only given once re-added
+	d1.SetState("aborted")
+	a2.SetPriority(1)
+	s.Add(a2)
+
+	order, tasks := next(s)
+	assert.Equal([]string{"c/1", "a/2", "b/1", "a/1"}, order)
+	for _, tsk := range tasks {
+		assert.Equal("rendering", tsk.State)
+	}
+
+	//A requeued task is given again
+	s.Requeue(b1)
+	assert.Equal("waiting", b1.State)
+	order, _ = next(s)
+	assert.Equal([]string{"b/1"}, order)
+}
+
+func TestNextNode(t *testing.T) {
+
+	assert := assert.New(t)
+
+	for _, s := range []Scheduler{NewFIFO(), NewFairShare(nil, nil)} {
+		n := newNode()
+
+		//The node is freed when no task is waiting
+		_, err := s.Next(n)
+		assert.Equal(ErrNoTask, err)
+		assert.Equal("available", n.State())
+
+		tsk := newTask("u", "p", "a", 1, 0, 0)
+		s.Add(tsk)
+
+		//A busy node doesn't get the task
+		n.SetState("rendering")
+		_, err = s.Next(n)
+		assert.Equal(ErrNodeBusy, err)
+		assert.Equal("waiting", tsk.State)
+
+		n.SetState("available")
+		got, err := s.Next(n)
+		assert.NoError(err)
+		assert.Equal(tsk, got)
+		assert.Equal("rendering", n.State())
+	}
+}
+
+func TestNextRenderer(t *testing.T) {
+
+	assert := assert.New(t)
+
+	for _, s := range []Scheduler{NewFIFO(), NewFairShare(nil, nil)} {
+		old := newTask("u", "p", "a", 1, 0, 0)
+		recent := newTask("u", "p", "b", 1, 0, 1)
+		recent.RendererVersion = "3.6.0"
+		s.Add(old)
+		s.Add(recent)
+
+		//A node only gets the tasks of the renderers it supports, the other tasks stay waiting
+		n := newNode(node.Renderer{Name: "blender", Version: "3.6.0"})
+		got, err := s.Next(n)
+		assert.NoError(err)
+		assert.Equal(recent, got)
+		n.Free()
+
+		_, err = s.Next(n)
+		assert.Equal(ErrNoTask, err)
+		assert.Equal("available", n.State())
+		assert.Equal("waiting", old.State)
+
+		order, _ := next(s)
+		assert.Equal([]string{"a/1"}, order)
+	}
+}
+
+func TestNextFailedNode(t *testing.T) {
+
+	assert := assert.New(t)
+
+	for _, s := range []Scheduler{NewFIFO(), NewFairShare(nil, nil)} {
+		first := newTask("u", "p", "a", 1, 0, 0)
+		second := newTask("u", "p", "a", 2, 0, 0)
+		first.Fail("bad//1.2.3.4", "render error", 3)
+		s.Add(first)
+		s.Add(second)
+
+		//The node the first task failed on gets the next one, the first one staying first for the others
+		bad := newNode()
+		bad.Name, bad.IP = "bad", "1.2.3.4"
+		got, err := s.Next(bad)
+		assert.NoError(err)
+		assert.Equal(second, got)
+		bad.Free()
+
+		_, err = s.Next(bad)
+		assert.Equal(ErrNoTask, err)
+		assert.Equal("waiting", first.State)
+
+		order, _ := next(s)
+		assert.Equal([]string{"a/1"}, order)
+	}
+}
+
+func TestFairShare(t *testing.T) {
+
+	assert := assert.New(t)
+
+	s := NewFairShare(map[string]float64{"u": 2}, nil)
+	tasks := []*render.Task{
+		newTask("u", "p", "a", 1, 0, 0),
+		newTask("u", "p", "a", 2, 0, 0),
+		newTask("u", "p", "a", 3, 0, 0),
+		newTask("u", "q", "b", 1, 0, 1),
+		newTask("v", "p", "c", 1, 0, 2),
+		newTask("v", "p", "c", 2, 0, 2),
+	}
+	for _, tsk := range tasks {
+		s.Add(tsk)
+	}
+
+	//u has twice the weight of v, its nodes are shared between its projects
+	n := newNode()
+	got := []string{}
+	given := []*render.Task{}
+	for i := 0; i < 4; i++ {
+		tsk, err := s.Next(n)
+		assert.NoError(err)
+		got = append(got, tsk.ID+"/"+strconv.Itoa(tsk.Frame))
+		given = append(given, tsk)
+		n.Free()
+	}
+	assert.Equal([]string{"a/1", "c/1", "b/1", "a/2"}, got)
+
+	//Once the tasks of u are released (releasing twice doesn't count), u is using fewer nodes than v
+	for _, tsk := range given {
+		if tsk.Owner == "u" {
+			s.Release(tsk)
+		}
+	}
+	s.Release(given[0])
+	order, _ := next(s)
+	assert.Equal([]string{"a/3", "c/2"}, order)
+}
@@ -0,0 +1,43 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+//CheckName returns an error if name can't be used as a single element of a path, such as the ID of a job
+func CheckName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00") || filepath.VolumeName(name) != "" {
+		return fmt.Errorf("invalid name '%s'", name)
+	}
+	return nil
+}
+
+//CheckPath returns an error if the slash separated path name is empty, absolute, contains '..', a backslash or a NUL byte
+func CheckPath(name string) error {
+	if name == "" || strings.HasPrefix(name, "/") || strings.ContainsAny(name, "\\\x00") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return fmt.Errorf("invalid path '%s'", name)
+	}
+	for _, e := range strings.Split(name, "/") {
+		if e == ".." {
+			return fmt.Errorf("invalid path '%s'", name)
+		}
+	}
+	return nil
+}
+
+//SafeJoin joins the ID of a job and the slash separated path name, relative to the folder of the job, to the folder
+//root. It returns an error if id isn't a valid name or name a valid path, an empty name giving the folder of the job.
+func SafeJoin(root, id, name string) (string, error) {
+	if err := CheckName(id); err != nil {
+		return "", err
+	}
+	if name == "" {
+		return filepath.Join(root, id), nil
+	}
+	if err := CheckPath(name); err != nil {
+		return "", err
+	}
+	return filepath.Join(root, id, filepath.FromSlash(name)), nil
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSafeJoin(t *testing.T) {
+	assert := assert.New(t)
+
+	IDs := []string{"job", "job", "job", "job", "job", "", ".", "..", "a/b", "a\\b", "a\x00b", "job", "job", "job", "job", "job", "job"}
+	names := []string{"cube.blend", "scenes/main.blend", "", "a/./b.png", "..png", "cube.blend", "cube.blend", "cube.blend", "cube.blend", "cube.blend", "cube.blend",
+		"../cube.blend", "a/../../b", "..", "/etc/passwd", "a\\..\\b", "a\x00b"}
+	expected := []string{filepath.Join("root", "job", "cube.blend"), filepath.Join("root", "job", "scenes", "main.blend"), filepath.Join("root", "job"),
+		filepath.Join("root", "job", "a", "b.png"), filepath.Join("root", "job", "..png"), "", "", "", "", "", "", "", "", "", "", "", ""}
+
+	for i := 0; i < len(IDs); i++ {
+		p, err := SafeJoin("root", IDs[i], names[i])
+		assert.Equal(expected[i], p, "Bad path in test %d", i)
+		assert.Equal(expected[i] == "", err != nil, "Bad error in test %d : %v", i, err)
+	}
+}
+
+func FuzzSafeJoin(f *testing.F) {
+	f.Add("job", "cube.blend")
+	f.Add("job", "scenes/../main.blend")
+	f.Add("..", "cube.blend")
+	f.Add("job", "../../etc/passwd")
+	f.Add("job", "a\\..\\b")
+
+	root := filepath.Join("root", "folder")
+	f.Fuzz(func(t *testing.T, id, name string) {
+		p, err := SafeJoin(root, id, name)
+		if err != nil {
+			return
+		}
+
+		//Accepted paths stay in the folder of the job, whose ID is a single element
+		rel, err := filepath.Rel(filepath.Join(root, id), p)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			t.Fatalf("SafeJoin(%q, %q) = %q escapes the folder of the job", id, name, p)
+		}
+		if filepath.Dir(filepath.Join(root, id)) != root {
+			t.Fatalf("SafeJoin accepted the ID %q", id)
+		}
+		if strings.ContainsRune(p, 0) {
+			t.Fatalf("SafeJoin(%q, %q) = %q contains a NUL byte", id, name, p)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package version
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Version is a MAJOR.MINOR.PATCH version of a renderer, the missing components being 0
+type Version struct {
+	Major int
+	Minor int
+	Patch int
+}
+
+//Parse reads a version like 3, 3.6 or 3.6.2. A suffix starting with '-', '+' or a space, like in 3.6.2-lts,
+//is ignored.
+func Parse(s string) (Version, error) {
+	v, _, err := parse(s)
+	return v, err
+}
+
+//parse reads a version and returns the number of components it was given with
+func parse(s string) (Version, int, error) {
+	var v Version
+
+	num := s
+	if i := strings.IndexAny(s, "-+ "); i >= 0 {
+		num = s[:i]
+	}
+	parts := strings.Split(num, ".")
+	if len(parts) > 3 {
+		return v, 0, fmt.Errorf("invalid version '%s'", s)
+	}
+
+	fields := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, 0, fmt.Errorf("invalid version '%s'", s)
+		}
+		*fields[i] = n
+	}
+	return v, len(parts), nil
+}
+
+//Compare returns -1, 0 or 1 if v is lower, equal or greater than o
+func (v Version) Compare(o Version) int {
+	a := []int{v.Major, v.Minor, v.Patch}
+	b := []int{o.Major, o.Minor, o.Patch}
+	for i := range a {
+		if a[i] < b[i] {
+			return -1
+		}
+		if a[i] > b[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+//term is a single comparison of a constraint
+type term struct {
+	op      string
+	version Version
+}
+
+func (t term) check(v Version) bool {
+	c := v.Compare(t.version)
+	switch t.op {
+	case "=":
+		return c == 0
+	case "!=":
+		return c != 0
+	case ">":
+		return c > 0
+	case ">=":
+		return c >= 0
+	case "<":
+		return c < 0
+	case "<=":
+		return c <= 0
+	}
+	return false
+}
+
+//Constraint is a list of comparisons a version must all satisfy
+type Constraint struct {
+	terms []term
+}
+
+//ParseConstraint reads a constraint made of comparisons separated by spaces or commas, all of them having to be
+//satisfied. A comparison is a version preceded by =, !=, >, >=, <, <=, ~ or ^ :
+//  - a version alone or preceded by = must be equal, 3.6 being 3.6.0
+//  - ~3.6 accepts the patches of 3.6 (>=3.6.0 <3.7.0), ~3 the minors of 3 (>=3.0.0 <4.0.0)
+//  - ^3.6 accepts the versions compatible with 3.6 (>=3.6.0 <4.0.0)
+//
+//An empty constraint or * accepts any version.
+func ParseConstraint(s string) (Constraint, error) {
+	var c Constraint
+
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == ','
+	})
+
+	for i := 0; i < len(fields); i++ {
+		f := fields[i]
+		if f == "*" {
+			continue
+		}
+
+		op := ""
+		for _, o := range []string{">=", "<=", "!=", ">", "<", "=", "~", "^"} {
+			if strings.HasPrefix(f, o) {
+				op = o
+				break
+			}
+		}
+		vs := f[len(op):]
+
+		//Allow a space between the operator and the version, as in >= 3.6
+		if vs == "" && i+1 < len(fields) {
+			i++
+			vs = fields[i]
+		}
+
+		v, parts, err := parse(vs)
+		if err != nil {
+			return c, fmt.Errorf("invalid constraint '%s'", s)
+		}
+
+		switch op {
+		case "", "=":
+			c.terms = append(c.terms, term{"=", v})
+		case "~":
+			upper := Version{v.Major + 1, 0, 0}
+			if parts > 1 {
+				upper = Version{v.Major, v.Minor + 1, 0}
+			}
+			c.terms = append(c.terms, term{">=", v}, term{"<", upper})
+		case "^":
+			c.terms = append(c.terms, term{">=", v}, term{"<", Version{v.Major + 1, 0, 0}})
+		default:
+			c.terms = append(c.terms, term{op, v})
+		}
+	}
+	return c, nil
+}
+
+//Exact returns true if s isn't a constraint and has none of its operators, like a custom build named "latest".
+//Such a version is only matched by Match when equal.
+func Exact(s string) bool {
+	if _, err := ParseConstraint(s); err == nil {
+		return false
+	}
+	return !strings.ContainsAny(s, "=!<>~^,*")
+}
+
+//Check returns true if v satisfies every comparison of c
+func (c Constraint) Check(v Version) bool {
+	for _, t := range c.terms {
+		if !t.check(v) {
+			return false
+		}
+	}
+	return true
+}
+
+//Match returns true if the version satisfies the constraint. A version equal to the constraint always matches,
+//even if it can't be parsed.
+func Match(constraint, version string) bool {
+	if constraint == version {
+		return true
+	}
+	c, err := ParseConstraint(constraint)
+	if err != nil {
+		return false
+	}
+	v, err := Parse(version)
+	if err != nil {
+		return false
+	}
+	return c.Check(v)
+}
+
+//Best returns the index of the highest of versions satisfying the constraint, -1 if none does. An exact match is
+//preferred to the others.
+func Best(constraint string, versions []string) int {
+	best := -1
+	var bestVersion Version
+
+	for i, vs := range versions {
+		if vs == constraint {
+			return i
+		}
+		if !Match(constraint, vs) {
+			continue
+		}
+		v, _ := Parse(vs)
+		if best == -1 || v.Compare(bestVersion) > 0 {
+			best, bestVersion = i, v
+		}
+	}
+	return best
+}
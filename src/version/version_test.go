@@ -0,0 +1,84 @@
+package version
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+
+	assert := assert.New(t)
+
+	inputs := []string{"3", "3.6", "3.6.2", "2.83.20-lts", "4.1.0+build7", "3.6.a", "1.2.3.4", "", "-3"}
+	expected := []Version{{3, 0, 0}, {3, 6, 0}, {3, 6, 2}, {2, 83, 20}, {4, 1, 0}, {}, {}, {}, {}}
+	errs := []bool{false, false, false, false, false, true, true, true, true}
+
+	for i := 0; i < len(inputs); i++ {
+		v, err := Parse(inputs[i])
+		assert.Equal(errs[i], err != nil, "Bad error for %s", inputs[i])
+		if !errs[i] {
+			assert.Equal(expected[i], v, "Bad version for %s", inputs[i])
+		}
+	}
+
+	assert.Equal(-1, Version{3, 6, 2}.Compare(Version{3, 10, 0}))
+	assert.Equal(0, Version{3, 6, 0}.Compare(Version{3, 6, 0}))
+	assert.Equal(1, Version{4, 0, 0}.Compare(Version{3, 6, 9}))
+}
+
+func TestMatch(t *testing.T) {
+
+	assert := assert.New(t)
+
+	constraints := []string{"2.91.0", "2.91", "2.91.0", "~3.6", "~3.6", "~3.6.2", "~3.6.2", "~3", "^3.6", "^3.6",
+		">=3.6 <4.0", ">=3.6, <4.0", ">= 3.6 < 4.0", ">=3.6 <4.0", "!=3.6.1", ">3.6 <=3.6.4", "", "*", "4.x", "custom", "custom"}
+	versions := []string{"2.91.0", "2.91.0", "2.91.1", "3.6.5", "3.7.0", "3.6.1", "3.6.2", "3.11.1", "3.11.1", "4.0.0",
+		"3.6.5-lts", "3.9.0", "4.0.0", "4.0.0", "3.6.1", "3.6.4", "2.79", "4.2.0", "4.2.0", "custom", "1.0"}
+	expected := []bool{true, true, false, true, false, false, true, true, true, false,
+		true, true, false, false, false, true, true, true, false, true, false}
+
+	for i := 0; i < len(constraints); i++ {
+		assert.Equal(expected[i], Match(constraints[i], versions[i]), "Bad match of %s with constraint %s", versions[i], constraints[i])
+	}
+}
+
+func TestParseConstraint(t *testing.T) {
+
+	assert := assert.New(t)
+
+	constraints := []string{">=3.6 <4.0", "~3.6", "2.91.0", "", ">=", "~three", "=>3.6", "3.6 || 4.0"}
+	errs := []bool{false, false, false, false, true, true, true, true}
+
+	for i := 0; i < len(constraints); i++ {
+		_, err := ParseConstraint(constraints[i])
+		assert.Equal(errs[i], err != nil, "Bad error for constraint %s", constraints[i])
+	}
+}
+
+func TestExact(t *testing.T) {
+
+	assert := assert.New(t)
+
+	inputs := []string{"latest", "4.x", "2.91 custom", "2.91.0", ">=3.6", ">=latest", "~three", "=>3.6"}
+	expected := []bool{true, true, true, false, false, false, false, false}
+
+	for i := 0; i < len(inputs); i++ {
+		assert.Equal(expected[i], Exact(inputs[i]), "Bad result for %s", inputs[i])
+	}
+}
+
+func TestBest(t *testing.T) {
+
+	assert := assert.New(t)
+
+	//The LTS releases and a newer one, the highest matching patch being chosen
+	installed := []string{"3.3.12", "3.6.2", "3.6.9-lts", "4.1.0", "3.6.5"}
+
+	constraints := []string{"~3.6", ">=3.6 <4.0", "~3.3", "^3", ">=3.3", "3.6.2", "~3.6.10", "~2.93", "3.6.9-lts"}
+	expected := []int{2, 2, 0, 2, 3, 1, -1, -1, 2}
+
+	for i := 0; i < len(constraints); i++ {
+		assert.Equal(expected[i], Best(constraints[i], installed), "Bad version chosen for constraint %s", constraints[i])
+	}
+}